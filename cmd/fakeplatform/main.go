@@ -0,0 +1,198 @@
+// fakeplatform is a delivery platform running locally, to try the delivery webhooks without a real one.
+//
+// Start the restaurant with DELIVERY_PLATFORMS=fakeplatform, DELIVERY_FAKEPLATFORM_SECRET=<secret> and
+// DELIVERY_FAKEPLATFORM_CALLBACK_URL=http://localhost:9100/callbacks, then run
+//
+//	go run ./cmd/fakeplatform -secret <secret>
+//
+// POST /orders sends an order to the restaurant (the body, or a sample one with a new id when empty),
+// ?repeat=N sends it N times like a platform retrying, and ?bad_signature=true signs it with the wrong secret.
+// POST /callbacks takes the statuses sent back by the restaurant, and GET /callbacks lists them (?id= for one order).
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"restaurantms/delivery"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A status received from the restaurant
+type callback struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	At          time.Time `json:"at"`
+	Received_at time.Time `json:"received_at"`
+}
+
+type platform struct {
+	secret string
+	target string
+	client *http.Client
+
+	mu        sync.Mutex
+	sent      int
+	callbacks []callback
+}
+
+func main() {
+	addr := flag.String("addr", ":9100", "where the platform listens")
+	target := flag.String("target", "http://localhost:8000/webhooks/delivery/fakeplatform", "the webhook of the restaurant")
+	secret := flag.String("secret", os.Getenv("DELIVERY_FAKEPLATFORM_SECRET"), "the secret shared with the restaurant")
+	flag.Parse()
+	if *secret == "" {
+		log.Fatal("a -secret is required")
+	}
+
+	p := newPlatform(*secret, *target)
+	log.Printf("fake delivery platform listening on %s, sending to %s", *addr, *target)
+	log.Fatal(http.ListenAndServe(*addr, p.handler()))
+}
+
+func newPlatform(secret string, target string) *platform {
+	return &platform{secret: secret, target: target, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// The routes of the platform
+func (p *platform) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders", p.sendOrder)
+	mux.HandleFunc("/callbacks", p.handleCallbacks)
+	return mux
+}
+
+// This function sends an order to the restaurant and answers with what the restaurant answered each time
+func (p *platform) sendOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = p.sampleOrder()
+	}
+	repeat, err := strconv.Atoi(r.URL.Query().Get("repeat"))
+	if err != nil || repeat < 1 {
+		repeat = 1
+	}
+	secret := p.secret
+	if r.URL.Query().Get("bad_signature") == "true" {
+		secret = "not-" + secret
+	}
+
+	type answer struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	}
+	answers := []answer{}
+	for i := 0; i < repeat; i++ {
+		req, err := http.NewRequest(http.MethodPost, p.target, bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		delivery.SignRequest(req, secret, body)
+
+		res, err := p.client.Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		resBody, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !json.Valid(resBody) {
+			resBody, _ = json.Marshal(string(resBody))
+		}
+		answers = append(answers, answer{Status: res.StatusCode, Body: resBody})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"order": json.RawMessage(body), "answers": answers})
+}
+
+// A delivery order for two items, with an id never sent before
+func (p *platform) sampleOrder() []byte {
+	p.mu.Lock()
+	p.sent++
+	id := fmt.Sprintf("FP-%d-%d", time.Now().Unix(), p.sent)
+	p.mu.Unlock()
+
+	readyBy := time.Now().Add(30 * time.Minute).UTC().Truncate(time.Second)
+	order, _ := json.Marshal(map[string]interface{}{
+		"id":       id,
+		"type":     "DELIVERY",
+		"ready_by": readyBy,
+		"customer": map[string]string{"name": "Fake Customer", "phone": "+15550100"},
+		"address": map[string]string{
+			"street":       "1 Test Street",
+			"city":         "Testville",
+			"postal_code":  "00000",
+			"instructions": "leave at the door",
+		},
+		"delivery_fee": 2.5,
+		"items": []map[string]interface{}{
+			{"id": "fp-burger", "name": "Burger", "quantity": 2, "size": "M", "price": 9.5},
+			{"id": "fp-fries", "name": "Fries", "quantity": 1, "size": "L", "price": 3.25},
+		},
+	})
+	return order
+}
+
+// This function takes the statuses of the restaurant, checking their signature, and lists them
+func (p *platform) handleCallbacks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := delivery.VerifySignature(r.Header, p.secret, body); err != nil {
+			log.Printf("rejected a callback: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var received callback
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received.Received_at = time.Now().UTC()
+
+		p.mu.Lock()
+		p.callbacks = append(p.callbacks, received)
+		p.mu.Unlock()
+		log.Printf("order %s is %s", received.ID, received.Status)
+		writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	case http.MethodGet:
+		id := r.URL.Query().Get("id")
+		p.mu.Lock()
+		listed := []callback{}
+		for _, received := range p.callbacks {
+			if id == "" || received.ID == id {
+				listed = append(listed, received)
+			}
+		}
+		p.mu.Unlock()
+		writeJSON(w, http.StatusOK, listed)
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"restaurantms/delivery"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testSecret = "test-secret"
+
+// A restaurant taking the orders of the platform the way the webhook does: checking the signature and parsing the order
+type restaurant struct {
+	mu       sync.Mutex
+	received []delivery.ExternalOrder
+}
+
+func (rs *restaurant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	adapter := delivery.NewJSONAdapter(testSecret)
+	body, _ := io.ReadAll(r.Body)
+	if err := adapter.Verify(r.Header, body); err != nil {
+		http.Error(w, `{"error": "invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+	order, err := adapter.Parse(body)
+	if err != nil {
+		http.Error(w, `{"error": "invalid order"}`, http.StatusBadRequest)
+		return
+	}
+	rs.mu.Lock()
+	rs.received = append(rs.received, order)
+	rs.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"order_id": "order-" + order.External_id})
+}
+
+// Starts the restaurant and the platform sending to it
+func startPlatform(t *testing.T) (*restaurant, *httptest.Server) {
+	rs := &restaurant{}
+	restaurantServer := httptest.NewServer(rs)
+	t.Cleanup(restaurantServer.Close)
+	platformServer := httptest.NewServer(newPlatform(testSecret, restaurantServer.URL).handler())
+	t.Cleanup(platformServer.Close)
+	return rs, platformServer
+}
+
+type sendAnswer struct {
+	Order struct {
+		ID string `json:"id"`
+	} `json:"order"`
+	Answers []struct {
+		Status int `json:"status"`
+	} `json:"answers"`
+}
+
+func sendOrder(t *testing.T, platformServer *httptest.Server, query string) sendAnswer {
+	res, err := http.Post(platformServer.URL+"/orders"+query, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("sending the order answered %d", res.StatusCode)
+	}
+	var answer sendAnswer
+	if err := json.NewDecoder(res.Body).Decode(&answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+func TestSampleOrderIsImported(t *testing.T) {
+	rs, platformServer := startPlatform(t)
+
+	answer := sendOrder(t, platformServer, "")
+	if len(answer.Answers) != 1 || answer.Answers[0].Status != http.StatusOK {
+		t.Fatalf("the restaurant answered %+v", answer.Answers)
+	}
+	if len(rs.received) != 1 {
+		t.Fatalf("the restaurant got %d orders", len(rs.received))
+	}
+	order := rs.received[0]
+	if order.External_id != answer.Order.ID || order.Fulfillment != delivery.FulfillmentDelivery {
+		t.Fatalf("the restaurant got %+v", order)
+	}
+	if len(order.Items) != 2 || order.Address == nil {
+		t.Fatalf("the order lost its items or its address: %+v", order)
+	}
+}
+
+func TestRepeatSendsTheSameOrder(t *testing.T) {
+	rs, platformServer := startPlatform(t)
+
+	answer := sendOrder(t, platformServer, "?repeat=3")
+	if len(answer.Answers) != 3 {
+		t.Fatalf("the order was sent %d times", len(answer.Answers))
+	}
+	for _, order := range rs.received {
+		if order.External_id != answer.Order.ID {
+			t.Fatalf("a retry sent another order: %s", order.External_id)
+		}
+	}
+}
+
+func TestBadSignatureIsRejected(t *testing.T) {
+	rs, platformServer := startPlatform(t)
+
+	answer := sendOrder(t, platformServer, "?bad_signature=true")
+	if len(answer.Answers) != 1 || answer.Answers[0].Status != http.StatusUnauthorized {
+		t.Fatalf("the restaurant answered %+v", answer.Answers)
+	}
+	if len(rs.received) != 0 {
+		t.Fatal("the restaurant took an order with a bad signature")
+	}
+}
+
+func listCallbacks(t *testing.T, platformServer *httptest.Server, id string) []callback {
+	res, err := http.Get(platformServer.URL + "/callbacks?id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var listed []callback
+	if err := json.NewDecoder(res.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	return listed
+}
+
+func TestStatusCallbacksAreListedInOrder(t *testing.T) {
+	_, platformServer := startPlatform(t)
+	client := delivery.NewHTTPStatusClient(platformServer.URL+"/callbacks", testSecret)
+
+	for _, status := range []string{"ACCEPTED", "READY", "PICKED_UP"} {
+		if err := client.SendStatus(context.Background(), "FP-1", status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.SendStatus(context.Background(), "FP-2", "CANCELLED"); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := listCallbacks(t, platformServer, "FP-1")
+	statuses := []string{}
+	for _, received := range listed {
+		statuses = append(statuses, received.Status)
+	}
+	if strings.Join(statuses, ",") != "ACCEPTED,READY,PICKED_UP" {
+		t.Fatalf("the platform got %v", statuses)
+	}
+}
+
+func TestCallbackWithBadSignatureIsRejected(t *testing.T) {
+	_, platformServer := startPlatform(t)
+	client := delivery.NewHTTPStatusClient(platformServer.URL+"/callbacks", "not-"+testSecret)
+
+	if err := client.SendStatus(context.Background(), "FP-1", "READY"); err == nil {
+		t.Fatal("the platform took a callback with a bad signature")
+	}
+	if listed := listCallbacks(t, platformServer, "FP-1"); len(listed) != 0 {
+		t.Fatalf("the platform kept %d callbacks", len(listed))
+	}
+}
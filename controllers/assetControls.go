@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"restaurantms/storage"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Largest image we accept, anything above is rejected before decoding
+const maxImageSize = 5 << 20
+
+// The widths of the thumbnails generated for every uploaded image
+var thumbnailWidths = []int{64, 256, 512}
+
+var assetCollection *mongo.Collection = database.OpenCollection(database.Client, "assets")
+
+var blobStore storage.BlobStore = openBlobStore()
+
+func openBlobStore() storage.BlobStore {
+	store, err := storage.NewBlobStore(database.OpenDatabase(database.Client))
+	if err != nil {
+		log.Fatal("Error while opening the blob storage: ", err)
+	}
+	return store
+}
+
+// This function takes a multipart upload (field "image") and attaches it to the food as its image
+func UploadFoodImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foodID := c.Param("food_id")
+
+		var food models.Food
+		if err := foodCollection.FindOne(ctx, bson.M{"food_id": foodID, "deleted_at": nil}).Decode(&food); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food not found"})
+			return
+		}
+
+		// a little extra room for the multipart boundaries and headers
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageSize+(64<<10))
+		fileHeader, err := c.FormFile("image")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an image file is required in the image field, of at most 5MB"})
+			return
+		}
+		if fileHeader.Size > maxImageSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image is larger than 5MB"})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "couldn't read the uploaded image"})
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxImageSize+1))
+		if err != nil || len(data) > maxImageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "couldn't read the uploaded image"})
+			return
+		}
+
+		// we don't trust the header sent by the client, the content type is sniffed from the bytes
+		contentType := http.DetectContentType(data)
+		thumbType, ok := helpers.ImageFormats[contentType]
+		if !ok {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "only jpeg, png and gif images are allowed"})
+			return
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the image couldn't be decoded"})
+			return
+		}
+
+		var asset models.Asset
+		asset.ID = primitive.NewObjectID()
+		asset.Asset_id = asset.ID.Hex()
+		asset.Content_type = contentType
+		asset.Size = int64(len(data))
+		asset.Width = img.Bounds().Dx()
+		asset.Height = img.Bounds().Dy()
+		asset.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		asset.Variants = map[string]models.AssetVariant{}
+
+		original := models.AssetVariant{
+			Key:          asset.Asset_id + "-original",
+			Content_type: contentType,
+			Width:        asset.Width,
+			Height:       asset.Height,
+			Size:         asset.Size,
+		}
+		if err = blobStore.Put(ctx, original.Key, bytes.NewReader(data)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while storing the image"})
+			return
+		}
+		asset.Variants["original"] = original
+
+		for _, width := range thumbnailWidths {
+			thumb := helpers.Thumbnail(img, width)
+			encoded, err := helpers.EncodeImage(thumb, thumbType)
+			if err != nil {
+				removeAssetBlobs(ctx, asset)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating thumbnails"})
+				return
+			}
+
+			name := "thumb_" + strconv.Itoa(width)
+			variant := models.AssetVariant{
+				Key:          asset.Asset_id + "-" + name,
+				Content_type: thumbType,
+				Width:        thumb.Bounds().Dx(),
+				Height:       thumb.Bounds().Dy(),
+				Size:         int64(len(encoded)),
+			}
+			if err = blobStore.Put(ctx, variant.Key, bytes.NewReader(encoded)); err != nil {
+				removeAssetBlobs(ctx, asset)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while storing the thumbnails"})
+				return
+			}
+			asset.Variants[name] = variant
+		}
+
+		imageURL := "/assets/" + asset.Asset_id
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		err = unitOfWork.Do(ctx, func(ctx context.Context) error {
+			if _, err := assetCollection.InsertOne(ctx, asset); err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, c, models.AuditCreate, "asset", asset.Asset_id, nil, asset); err != nil {
+				return err
+			}
+			return updateEachAudited(ctx, c, foodCollection, "food", "food_id", models.AuditUpdate,
+				bson.M{"food_id": foodID, "deleted_at": nil},
+				bson.M{
+					"$set": bson.M{
+						"image_asset_id": asset.Asset_id,
+						"food_image":     imageURL,
+						"updated_at":     updatedAt,
+					},
+					"$inc": bson.M{"version": 1},
+				},
+			)
+		})
+		if err != nil {
+			removeAssetBlobs(ctx, asset)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while attaching the image to the food"})
+			return
+		}
+
+		// the previous image is not referenced anymore
+		if food.Image_asset_id != nil {
+			deleteAsset(ctx, c, *food.Image_asset_id)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"asset": asset, "food_image": imageURL})
+	}
+}
+
+// This function serves a stored image, ?size= picks one of the thumbnails (thumb_64, thumb_256, thumb_512)
+// The assets never change once uploaded, so they can be cached by the clients for long
+func GetAsset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var asset models.Asset
+		err := assetCollection.FindOne(ctx, bson.M{"asset_id": c.Param("asset_id")}).Decode(&asset)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+			return
+		}
+
+		size := c.DefaultQuery("size", "original")
+		variant, ok := asset.Variants[size]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown size"})
+			return
+		}
+
+		etag := fmt.Sprintf(`"%s-%s"`, asset.Asset_id, size)
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("Last-Modified", asset.Created_at.UTC().Format(http.TimeFormat))
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		blob, err := blobStore.Get(ctx, variant.Key)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the asset"})
+			return
+		}
+		defer blob.Close()
+
+		c.DataFromReader(http.StatusOK, variant.Size, variant.Content_type, blob, nil)
+	}
+}
+
+func removeAssetBlobs(ctx context.Context, asset models.Asset) {
+	for _, variant := range asset.Variants {
+		if err := blobStore.Delete(ctx, variant.Key); err != nil {
+			log.Println("Error while removing blob", variant.Key, err)
+		}
+	}
+}
+
+func deleteAsset(ctx context.Context, c *gin.Context, assetID string) {
+	var asset models.Asset
+	if err := assetCollection.FindOneAndDelete(ctx, bson.M{"asset_id": assetID}).Decode(&asset); err != nil {
+		return
+	}
+	if err := recordAudit(ctx, c, models.AuditDelete, "asset", assetID, asset, nil); err != nil {
+		log.Println("Error while auditing the removal of asset", assetID, err)
+	}
+	removeAssetBlobs(ctx, asset)
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The audit log is append only: nothing in the code updates or removes its entries, short of rolling back a unit of
+// work run in memory
+var auditCollection *mongo.Collection = database.OpenCollection(database.Client, "audit_log")
+
+// Filtering and sorting allowed on GET /audit
+var auditListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"actor_id":      helpers.FilterString,
+		"action":        helpers.FilterString,
+		"resource_type": helpers.FilterString,
+		"resource_id":   helpers.FilterString,
+		"request_id":    helpers.FilterString,
+		"ip":            helpers.FilterString,
+		"created_at":    helpers.FilterTime,
+	},
+}
+
+// This function writes an audit entry for a change, the ctx decides the transaction it belongs to.
+// Before is nil for a creation; after is nil when the change removed the record.
+func recordAudit(ctx context.Context, c *gin.Context, action string, resourceType string, resourceID string, before interface{}, after interface{}) error {
+	var entry models.AuditEntry
+	var err error
+
+	if entry.Before, err = auditDocument(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditDocument(after); err != nil {
+		return err
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.Audit_id = entry.ID.Hex()
+	// the changes the service makes by itself, in reaction to an event, have no request
+	entry.Actor_id = systemActor
+	if c != nil {
+		entry.Actor_id = c.GetString("uid")
+		entry.Ip = c.ClientIP()
+		entry.Request_id = c.GetString("request_id")
+	}
+	entry.Action = action
+	entry.Resource_type = resourceType
+	entry.Resource_id = resourceID
+	entry.Diff = auditDiff(entry.Before, entry.After)
+	entry.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if _, err = auditCollection.InsertOne(ctx, entry); err != nil {
+		return err
+	}
+	database.OnRollback(ctx, func(ctx context.Context) error {
+		_, err := auditCollection.DeleteOne(ctx, bson.M{"_id": entry.ID})
+		return err
+	})
+	return nil
+}
+
+// The record as it is stored, without the secrets
+func auditDocument(record interface{}) (bson.M, error) {
+	if record == nil {
+		return nil, nil
+	}
+	if value := reflect.ValueOf(record); (value.Kind() == reflect.Ptr || value.Kind() == reflect.Map) && value.IsNil() {
+		return nil, nil
+	}
+	data, err := bson.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.M
+	if err = bson.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return withoutSecrets(document), nil
+}
+
+// The fields whose value changed between before and after
+func auditDiff(before bson.M, after bson.M) map[string]models.AuditChange {
+	diff := map[string]models.AuditChange{}
+	for field, from := range before {
+		if to, ok := after[field]; !ok || !reflect.DeepEqual(from, to) {
+			diff[field] = models.AuditChange{From: from, To: after[field]}
+		}
+	}
+	for field, to := range after {
+		if _, ok := before[field]; !ok {
+			diff[field] = models.AuditChange{From: nil, To: to}
+		}
+	}
+	delete(diff, "_id")
+	return diff
+}
+
+// This function inserts a new record along with its audit entry, both or none are written
+func insertAudited(ctx context.Context, c *gin.Context, collection *mongo.Collection, resourceType string, resourceID string, record interface{}) (*mongo.InsertOneResult, error) {
+	return insertAuditedThen(ctx, c, collection, resourceType, resourceID, record, nil)
+}
+
+// Same as insertAudited, with more work done in the same transaction once the record is inserted
+func insertAuditedThen(ctx context.Context, c *gin.Context, collection *mongo.Collection, resourceType string, resourceID string, record interface{}, then func(ctx context.Context) error) (*mongo.InsertOneResult, error) {
+	var res *mongo.InsertOneResult
+	err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if res, err = collection.InsertOne(ctx, record); err != nil {
+			return err
+		}
+		database.OnRollback(ctx, func(ctx context.Context) error {
+			_, err := collection.DeleteOne(ctx, bson.M{"_id": res.InsertedID})
+			return err
+		})
+		if then != nil {
+			if err = then(ctx); err != nil {
+				return err
+			}
+		}
+		return recordAudit(ctx, c, models.AuditCreate, resourceType, resourceID, nil, record)
+	})
+	return res, err
+}
+
+// This function registers putting a record back as it was, if the unit of work it was changed in fails
+func undoReplace(ctx context.Context, collection *mongo.Collection, before bson.M) {
+	database.OnRollback(ctx, func(ctx context.Context) error {
+		_, err := collection.ReplaceOne(ctx, bson.M{"_id": before["_id"]}, before)
+		return err
+	})
+}
+
+// This function applies the same update to every record matching the filter, one by one, so each change is audited
+func updateEachAudited(ctx context.Context, c *gin.Context, collection *mongo.Collection, resourceType string, idField string, action string, filter bson.M, update bson.M) error {
+	ids, err := distinctStrings(ctx, collection, idField, filter)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var before, after bson.M
+		err := collection.FindOneAndUpdate(ctx, bson.M{idField: id}, update).Decode(&before)
+		if err != nil {
+			return err
+		}
+		undoReplace(ctx, collection, before)
+		if err = collection.FindOne(ctx, bson.M{idField: id}).Decode(&after); err != nil {
+			return err
+		}
+		if err = recordAudit(ctx, c, action, resourceType, id, before, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function lists the audit log, for the managers.
+// With ?format=csv the whole log matching the filters is exported as CSV instead of a page of JSON.
+func GetAudit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("format") != "csv" {
+			listCollection(c, auditCollection, auditListSpec, bson.M{})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter, err := helpers.ListFilter(c, auditListSpec, bson.M{})
+		if errors.Is(err, helpers.ErrInvalidListQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := auditCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while listing the audit log"})
+			return
+		}
+		defer res.Close(ctx)
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="audit.csv"`)
+		c.Status(http.StatusOK)
+
+		writer := csv.NewWriter(c.Writer)
+		writer.Write([]string{"created_at", "audit_id", "actor_id", "action", "resource_type", "resource_id", "ip", "request_id", "diff"})
+		for res.Next(ctx) {
+			var entry models.AuditEntry
+			if err := res.Decode(&entry); err != nil {
+				break
+			}
+			diff, _ := json.Marshal(entry.Diff)
+			writer.Write([]string{
+				entry.Created_at.Format(time.RFC3339),
+				entry.Audit_id,
+				entry.Actor_id,
+				entry.Action,
+				entry.Resource_type,
+				entry.Resource_id,
+				entry.Ip,
+				entry.Request_id,
+				string(diff),
+			})
+		}
+		writer.Flush()
+	}
+}
@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"restaurantms/database"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The closed business days, with their Z report
+var businessDayCollection *mongo.Collection = database.OpenCollection(database.Client, "business_days")
+
+// The time zone of the restaurant, deciding which business day a payment belongs to (BUSINESS_TIMEZONE, UTC by default)
+var businessTimezone string = os.Getenv("BUSINESS_TIMEZONE")
+
+var businessLocation *time.Location = loadBusinessLocation()
+
+func loadBusinessLocation() *time.Location {
+	if businessTimezone == "" {
+		businessTimezone = "UTC"
+	}
+	location, err := time.LoadLocation(businessTimezone)
+	if err != nil {
+		log.Fatal("Unknown BUSINESS_TIMEZONE ", businessTimezone)
+	}
+	return location
+}
+
+// Returned from inside a unit of work when the record belongs to a closed business day, becomes a 409
+type dayLockedError struct {
+	day string
+}
+
+func (e dayLockedError) Error() string {
+	return fmt.Sprintf("the business day %s is closed", e.day)
+}
+
+// The business day of a moment, like 2026-01-31
+func businessDay(t time.Time) string {
+	return t.In(businessLocation).Format("2006-01-02")
+}
+
+// The moments the business day starts and ends at
+func businessDayRange(day string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01-02", day, businessLocation)
+	if err != nil {
+		return start, start, err
+	}
+	return start, start.AddDate(0, 0, 1), nil
+}
+
+// This function fails with a dayLockedError when the business day has been closed
+func ensureDayOpen(ctx context.Context, day string) error {
+	count, err := businessDayCollection.CountDocuments(ctx, bson.M{"business_day": day})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return dayLockedError{day}
+	}
+	return nil
+}
+
+// This function fails with a dayLockedError when the record belongs to a closed business day
+func ensureRecordDayOpen(ctx context.Context, resourceType string, record bson.M) error {
+	days, err := recordDays(ctx, resourceType, record)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if err := ensureDayOpen(ctx, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The business days a record belongs to, the records which are not tied to a day (foods, menus...) have none
+func recordDays(ctx context.Context, resourceType string, record bson.M) ([]string, error) {
+	days := []string{}
+	switch resourceType {
+	case "invoice":
+		if day, ok := record["business_day"].(string); ok {
+			days = append(days, day)
+		} else if created, ok := record["created_at"].(primitive.DateTime); ok {
+			days = append(days, businessDay(created.Time()))
+		}
+		if day, ok := record["refund_day"].(string); ok {
+			days = append(days, day)
+		}
+	case "order":
+		if date, ok := record["order_date"].(primitive.DateTime); ok {
+			days = append(days, businessDay(date.Time()))
+		}
+	case "order_item":
+		var order bson.M
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": record["order_id"]}).Decode(&order)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		return recordDays(ctx, "order", order)
+	case "drawer":
+		if day, ok := record["business_day"].(string); ok {
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+// This function closes a business day: it computes the Z report and locks the day, so nothing paid,
+// ordered or counted that day can be changed anymore. The drawers of the day have to be closed first.
+func CloseBusinessDay() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Business_day string `json:"business_day"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Business_day == "" {
+			body.Business_day = businessDay(time.Now())
+		}
+		start, _, err := businessDayRange(body.Business_day)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "business_day must be a date like 2026-01-31"})
+			return
+		}
+		if start.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the business day has not started yet"})
+			return
+		}
+
+		var closed models.BusinessDay
+		err = unitOfWork.Do(ctx, func(ctx context.Context) error {
+			if err := ensureDayOpen(ctx, body.Business_day); err != nil {
+				return err
+			}
+			open, err := drawerCollection.CountDocuments(ctx, bson.M{"business_day": body.Business_day, "status": models.DrawerOpen})
+			if err != nil {
+				return err
+			}
+			if open > 0 {
+				return validationError{"every drawer of the day has to be closed first"}
+			}
+
+			report, err := computeZReport(ctx, body.Business_day)
+			if err != nil {
+				return err
+			}
+
+			closed.ID = primitive.NewObjectID()
+			closed.Business_day = body.Business_day
+			closed.Z_report = report
+			closed.Locked_by = c.GetString("uid")
+			closed.Locked_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			closed.Created_at = closed.Locked_at
+
+			if _, err = businessDayCollection.InsertOne(ctx, closed); err != nil {
+				return err
+			}
+			return recordAudit(ctx, c, models.AuditCreate, "business_day", closed.Business_day, nil, closed)
+		})
+
+		var locked dayLockedError
+		if errors.As(err, &locked) || mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the business day is already closed"})
+			return
+		}
+		var invalid validationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while closing the business day"})
+			return
+		}
+
+		c.JSON(http.StatusOK, closed)
+	}
+}
+
+// This function gives the Z report of a closed business day
+func GetZReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var closed models.BusinessDay
+		err := businessDayCollection.FindOne(ctx, bson.M{"business_day": c.Param("business_day")}).Decode(&closed)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the business day is not closed"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the Z report"})
+			return
+		}
+		c.JSON(http.StatusOK, closed)
+	}
+}
+
+// This function totals the invoices paid and refunded during the business day, and the drawers used
+func computeZReport(ctx context.Context, day string) (models.ZReport, error) {
+	report := models.ZReport{Business_day: day, Tenders: []models.ZReportTender{}, Drawers: []models.ZReportDrawer{}}
+
+	// refunded invoices were still sold that day, their refund is counted on the day it happened
+	paid := bson.M{"business_day": day, "payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}}, "deleted_at": nil}
+
+	res, err := invoiceCollections.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: paid}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"checks":   bson.M{"$sum": 1},
+			"subtotal": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$subtotal", 0}}},
+			"discount": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$discount", 0}}},
+			"service":  bson.M{"$sum": bson.M{"$ifNull": bson.A{"$service_charge", 0}}},
+			"delivery": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$delivery_fee", 0}}},
+			"tax":      bson.M{"$sum": bson.M{"$ifNull": bson.A{"$tax", 0}}},
+			"tip":      bson.M{"$sum": bson.M{"$ifNull": bson.A{"$tip", 0}}},
+			"total":    bson.M{"$sum": bson.M{"$ifNull": bson.A{"$total", 0}}},
+		}}},
+	})
+	if err != nil {
+		return report, err
+	}
+	var sums []struct {
+		Checks   int64
+		Subtotal float64
+		Discount float64
+		Service  float64
+		Delivery float64
+		Tax      float64
+		Tip      float64
+		Total    float64
+	}
+	if err = res.All(ctx, &sums); err != nil {
+		return report, err
+	}
+	if len(sums) > 0 {
+		report.Checks = sums[0].Checks
+		report.Gross_sales = Tofixed(sums[0].Subtotal, 2)
+		report.Discounts = Tofixed(sums[0].Discount, 2)
+		report.Net_sales = Tofixed(sums[0].Subtotal-sums[0].Discount, 2)
+		report.Service_charges = Tofixed(sums[0].Service, 2)
+		report.Delivery_fees = Tofixed(sums[0].Delivery, 2)
+		report.Taxes = Tofixed(sums[0].Tax, 2)
+		report.Tips = Tofixed(sums[0].Tip, 2)
+		report.Total = Tofixed(sums[0].Total, 2)
+	}
+
+	// the part paid with gift cards is a tender of its own
+	giftCardAmount := bson.M{"$ifNull": bson.A{"$gift_card_amount", 0}}
+	res, err = invoiceCollections.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: paid}},
+		{{Key: "$project", Value: bson.M{"tenders": bson.A{
+			bson.M{
+				"method": bson.M{"$ifNull": bson.A{"$payment_method", ""}},
+				"amount": bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$total", 0}}, giftCardAmount}},
+			},
+			bson.M{"method": models.PaymentGiftCard, "amount": giftCardAmount},
+		}}}},
+		{{Key: "$unwind", Value: "$tenders"}},
+		{{Key: "$match", Value: bson.M{"tenders.amount": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$tenders.method",
+			"checks": bson.M{"$sum": 1},
+			"amount": bson.M{"$sum": "$tenders.amount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return report, err
+	}
+	var tenders []struct {
+		Method string `bson:"_id"`
+		Checks int64
+		Amount float64
+	}
+	if err = res.All(ctx, &tenders); err != nil {
+		return report, err
+	}
+	for _, tender := range tenders {
+		report.Tenders = append(report.Tenders, models.ZReportTender{Payment_method: tender.Method, Checks: tender.Checks, Amount: Tofixed(tender.Amount, 2)})
+	}
+
+	res, err = invoiceCollections.Find(ctx, bson.M{"refund_day": day, "payment_status": "REFUNDED", "deleted_at": nil})
+	if err != nil {
+		return report, err
+	}
+	var refunded []models.Invoice
+	if err = res.All(ctx, &refunded); err != nil {
+		return report, err
+	}
+	for _, invoice := range refunded {
+		report.Refund_count++
+		if invoice.Total != nil {
+			report.Refunds += *invoice.Total
+		}
+	}
+	report.Refunds = Tofixed(report.Refunds, 2)
+
+	start, end, err := businessDayRange(day)
+	if err != nil {
+		return report, err
+	}
+	report.Open_checks, err = invoiceCollections.CountDocuments(ctx, bson.M{
+		"payment_status": "PENDING",
+		"created_at":     bson.M{"$gte": start, "$lt": end},
+		"deleted_at":     nil,
+	})
+	if err != nil {
+		return report, err
+	}
+
+	res, err = drawerCollection.Find(ctx, bson.M{"business_day": day}, options.Find().SetSort(bson.D{{Key: "opened_at", Value: 1}}))
+	if err != nil {
+		return report, err
+	}
+	var drawers []models.Drawer
+	if err = res.All(ctx, &drawers); err != nil {
+		return report, err
+	}
+	for _, drawer := range drawers {
+		summary := models.ZReportDrawer{Drawer_id: drawer.Drawer_id, Name: *drawer.Name, Opening_float: *drawer.Opening_float}
+		if drawer.Expected_amount != nil {
+			summary.Expected_amount = *drawer.Expected_amount
+		}
+		if drawer.Counted_amount != nil {
+			summary.Counted_amount = *drawer.Counted_amount
+		}
+		if drawer.Variance != nil {
+			summary.Variance = *drawer.Variance
+		}
+		report.Drawers = append(report.Drawers, summary)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var customerCollection *mongo.Collection = database.OpenCollection(database.Client, "customers")
+
+// What the customer listing can be filtered and sorted by, the phone goes through GET /customers/search
+var customerListSpec = helpers.ListSpec{
+	SortFields:  []string{"name", "created_at", "updated_at"},
+	DefaultSort: "name",
+	Filters: map[string]helpers.FilterType{
+		"name":       helpers.FilterString,
+		"email":      helpers.FilterString,
+		"created_at": helpers.FilterTime,
+		"updated_at": helpers.FilterTime,
+	},
+}
+
+var phoneNoise = regexp.MustCompile(`[^0-9]`)
+
+// Phones are kept with their digits only, and the leading + when there is one, so "+1 (555) 010-2000" is "+15550102000"
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	digits := phoneNoise.ReplaceAllString(phone, "")
+	if strings.HasPrefix(phone, "+") {
+		return "+" + digits
+	}
+	return digits
+}
+
+func GetCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, customerCollection, customerListSpec, bson.M{})
+	}
+}
+
+// This function finds the customers whose phone contains the digits of ?phone=, however they are typed
+func SearchCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		digits := phoneNoise.ReplaceAllString(c.Query("phone"), "")
+		if len(digits) < 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "phone needs at least 3 digits"})
+			return
+		}
+		listCollection(c, customerCollection, customerListSpec, bson.M{"phone": bson.M{"$regex": regexp.QuoteMeta(digits)}})
+	}
+}
+
+func GetCustomerbyID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		err := customerCollection.FindOne(ctx, withDeleted(c, bson.M{"customer_id": c.Param("customer_id")})).Decode(&customer)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the customer"})
+			return
+		}
+		helpers.SetETag(c, customer.Version)
+		c.JSON(http.StatusOK, customer)
+	}
+}
+
+// This function creates a customer, two customers can't share a phone
+func CreateCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		if err := c.BindJSON(&customer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if customer.Phone != nil {
+			phone := normalizePhone(*customer.Phone)
+			customer.Phone = &phone
+		}
+		if err := validate.Struct(customer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg, err := phoneTaken(ctx, *customer.Phone, ""); err != nil || msg != "" {
+			respondPhoneTaken(c, msg, err)
+			return
+		}
+
+		customer.ID = primitive.NewObjectID()
+		customer.Customer_id = customer.ID.Hex()
+		customer.Allergies = normalizeAllergens(customer.Allergies)
+		customer.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		customer.Updated_at = customer.Created_at
+		customer.Version = 1
+		customer.Deleted_at, customer.Deleted_by = nil, nil
+
+		res, err := insertAudited(ctx, c, customerCollection, "customer", customer.Customer_id, customer)
+		if mongo.IsDuplicateKeyError(err) {
+			respondPhoneTaken(c, "another customer has this phone", nil)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the customer"})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func UpdateCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customerID := c.Param("customer_id")
+
+		var customer models.Customer
+		if err := c.BindJSON(&customer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if customer.Name != nil {
+			if err := validate.Var(*customer.Name, "min=2,max=100"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 2 to 100 characters"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "name", Value: customer.Name})
+		}
+
+		if customer.Phone != nil {
+			phone := normalizePhone(*customer.Phone)
+			if err := validate.Var(phone, "min=6,max=20"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "phone must have 6 to 20 digits"})
+				return
+			}
+			if msg, err := phoneTaken(ctx, phone, customerID); err != nil || msg != "" {
+				respondPhoneTaken(c, msg, err)
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "phone", Value: phone})
+		}
+
+		if customer.Email != nil {
+			if err := validate.Var(*customer.Email, "omitempty,email"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "email is not valid"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "email", Value: customer.Email})
+		}
+
+		if customer.Preferences != nil {
+			if err := validate.Var(*customer.Preferences, "max=1000"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "preferences can't be longer than 1000 characters"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "preferences", Value: customer.Preferences})
+		}
+
+		if customer.Allergies != nil {
+			updateObj = append(updateObj, bson.E{Key: "allergies", Value: normalizeAllergens(customer.Allergies)})
+		}
+
+		customer.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: customer.Updated_at})
+
+		updateVersioned(ctx, c, customerCollection, "customer", "customer_id", customerID, updateObj)
+	}
+}
+
+// Tells if another customer (even a deleted one, which can still be restored) has the phone
+func phoneTaken(ctx context.Context, phone string, customerID string) (string, error) {
+	count, err := customerCollection.CountDocuments(ctx, bson.M{"phone": phone, "customer_id": bson.M{"$ne": customerID}})
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "another customer has this phone", nil
+	}
+	return "", nil
+}
+
+func respondPhoneTaken(c *gin.Context, msg string, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the phone"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": msg})
+}
+
+// This function fails with a validationError when the customer attached to a record doesn't exist
+func ensureCustomer(ctx context.Context, customerID *string) error {
+	if customerID == nil {
+		return nil
+	}
+	count, err := customerCollection.CountDocuments(ctx, bson.M{"customer_id": *customerID, "deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return validationError{"customer not found"}
+	}
+	return nil
+}
+
+// This function gives the profile of a customer: their visits (the orders attached to them), what they spent
+// on the paid invoices, and the foods they order the most. ?limit= is the number of visits listed, 20 by default.
+func GetCustomerProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+
+		var profile models.CustomerProfile
+		err = customerCollection.FindOne(ctx, bson.M{"customer_id": c.Param("customer_id"), "deleted_at": nil}).Decode(&profile.Customer)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the customer"})
+			return
+		}
+
+		if err = buildCustomerProfile(ctx, &profile, limit); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while computing the customer profile"})
+			return
+		}
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+func buildCustomerProfile(ctx context.Context, profile *models.CustomerProfile, limit int) error {
+	profile.Favorite_items = []models.FavoriteItem{}
+	profile.Visit_history = []models.CustomerVisit{}
+
+	res, err := orderCollection.Find(ctx,
+		bson.M{"customer_id": profile.Customer.Customer_id, "deleted_at": nil},
+		options.Find().SetSort(bson.D{{Key: "order_date", Value: -1}}),
+	)
+	if err != nil {
+		return err
+	}
+	var orders []models.Order
+	if err = res.All(ctx, &orders); err != nil {
+		return err
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+
+	orderIDs := bson.A{}
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.Order_id)
+	}
+	profile.Visits = int64(len(orders))
+	profile.Last_visit = &orders[0].Order_Date
+	profile.First_visit = &orders[len(orders)-1].Order_Date
+
+	// what each visit came to, from its items
+	res, err = orderItemsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"order_id": bson.M{"$in": orderIDs}, "deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$order_id",
+			"items":  bson.M{"$sum": 1},
+			"amount": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$unit_price", 0}}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var visits []struct {
+		Order_id string `bson:"_id"`
+		Items    int64
+		Amount   float64
+	}
+	if err = res.All(ctx, &visits); err != nil {
+		return err
+	}
+	byOrder := map[string]int{}
+	for i, visit := range visits {
+		byOrder[visit.Order_id] = i
+	}
+	for i, order := range orders {
+		if i == limit {
+			break
+		}
+		visit := models.CustomerVisit{Order_id: order.Order_id, Order_date: order.Order_Date}
+		if order.Table_id != nil {
+			visit.Table_id = *order.Table_id
+		}
+		if j, ok := byOrder[order.Order_id]; ok {
+			visit.Items = visits[j].Items
+			visit.Amount = Tofixed(visits[j].Amount, 2)
+		}
+		profile.Visit_history = append(profile.Visit_history, visit)
+	}
+
+	// the spend is what was paid, refunded invoices don't count
+	res, err = invoiceCollections.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"order_id": bson.M{"$in": orderIDs}, "payment_status": "PAID", "deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    nil,
+			"checks": bson.M{"$sum": 1},
+			"total":  bson.M{"$sum": bson.M{"$ifNull": bson.A{"$total", 0}}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var spend []struct {
+		Checks int64
+		Total  float64
+	}
+	if err = res.All(ctx, &spend); err != nil {
+		return err
+	}
+	if len(spend) > 0 && spend[0].Checks > 0 {
+		profile.Lifetime_spend = Tofixed(spend[0].Total, 2)
+		profile.Average_check = Tofixed(spend[0].Total/float64(spend[0].Checks), 2)
+	}
+
+	res, err = orderItemsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"order_id": bson.M{"$in": orderIDs}, "deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$food_id",
+			"count":  bson.M{"$sum": 1},
+			"amount": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$unit_price", 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "amount", Value: -1}}}},
+		{{Key: "$limit", Value: 5}},
+		{{Key: "$lookup", Value: bson.M{"from": "food", "localField": "_id", "foreignField": "food_id", "as": "food"}}},
+		{{Key: "$project", Value: bson.M{
+			"food_id": "$_id",
+			"name":    bson.M{"$ifNull": bson.A{firstOf("$food.name"), ""}},
+			"count":   1,
+			"amount":  1,
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var favorites []models.FavoriteItem
+	if err = res.All(ctx, &favorites); err != nil {
+		return err
+	}
+	for _, favorite := range favorites {
+		favorite.Amount = Tofixed(favorite.Amount, 2)
+		profile.Favorite_items = append(profile.Favorite_items, favorite)
+	}
+	return nil
+}
+
+// Orders and invoices keep the customer they were attached to, so a customer can always be deleted
+var deletableCustomer = deletable{
+	collection:   customerCollection,
+	resourceType: "customer",
+	idField:      "customer_id",
+}
+
+func DeleteCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableCustomer, c.Param("customer_id"))
+	}
+}
+
+func RestoreCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableCustomer, c.Param("customer_id"))
+	}
+}
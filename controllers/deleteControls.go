@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Describes how a resource is soft deleted
+type deletable struct {
+	collection   *mongo.Collection
+	resourceType string
+	idField      string
+	// tells why the record can't be deleted right now, "" when it can
+	references func(ctx context.Context, id string) (string, error)
+	// deletes the records which only exist as part of this one, marking them with the same deleted_at
+	cascadeDelete func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error
+	// restores the records deleted along with this one, the ones having the same deleted_at,
+	// a referencedError refuses the restore
+	cascadeRestore func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error
+}
+
+// Returned from inside the unit of work when the record is referenced, becomes a 409
+type referencedError struct {
+	reason string
+}
+
+func (e referencedError) Error() string {
+	return e.reason
+}
+
+// The filter for records which are not deleted, unless the client asked for them with ?include_deleted=true
+func withDeleted(c *gin.Context, filter bson.M) bson.M {
+	if c.Query("include_deleted") != "true" {
+		filter["deleted_at"] = nil
+	}
+	return filter
+}
+
+// This function soft deletes a record: it is only marked with deleted_at and deleted_by,
+// and disappears from the listings and lookups. It can be brought back with restoreRecord.
+func deleteRecord(c *gin.Context, resource deletable, id string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	deletedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	deletedBy := c.GetString("uid")
+
+	var deleted bson.M
+	err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+		if resource.references != nil {
+			reason, err := resource.references(ctx, id)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				return referencedError{reason}
+			}
+		}
+
+		var before bson.M
+		err := resource.collection.FindOneAndUpdate(ctx,
+			bson.M{resource.idField: id, "deleted_at": nil},
+			softDelete(deletedAt, deletedBy),
+		).Decode(&before)
+		if err != nil {
+			return err
+		}
+		if err = ensureRecordDayOpen(ctx, resource.resourceType, before); err != nil {
+			return err
+		}
+		if err = resource.collection.FindOne(ctx, bson.M{resource.idField: id}).Decode(&deleted); err != nil {
+			return err
+		}
+		if err = recordAudit(ctx, c, models.AuditDelete, resource.resourceType, id, before, deleted); err != nil {
+			return err
+		}
+
+		if resource.cascadeDelete != nil {
+			return resource.cascadeDelete(ctx, c, id, deletedAt)
+		}
+		return nil
+	})
+
+	var referenced referencedError
+	if errors.As(err, &referenced) {
+		c.JSON(http.StatusConflict, gin.H{"error": referenced.reason})
+		return
+	}
+	var locked dayLockedError
+	if errors.As(err, &locked) {
+		c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "record not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting the record"})
+		return
+	}
+
+	helpers.SetETag(c, versionOf(deleted))
+	c.JSON(http.StatusOK, withoutSecrets(deleted))
+}
+
+// This function brings back a soft deleted record
+func restoreRecord(c *gin.Context, resource deletable, id string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	restoredAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	var restored bson.M
+	err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var before bson.M
+		err := resource.collection.FindOneAndUpdate(ctx,
+			bson.M{resource.idField: id, "deleted_at": bson.M{"$ne": nil}},
+			softRestore(restoredAt),
+		).Decode(&before)
+		if err != nil {
+			return err
+		}
+		if err = ensureRecordDayOpen(ctx, resource.resourceType, before); err != nil {
+			return err
+		}
+		if err = resource.collection.FindOne(ctx, bson.M{resource.idField: id}).Decode(&restored); err != nil {
+			return err
+		}
+		if err = recordAudit(ctx, c, models.AuditRestore, resource.resourceType, id, before, restored); err != nil {
+			return err
+		}
+
+		if deletedAt, ok := before["deleted_at"].(primitive.DateTime); ok && resource.cascadeRestore != nil {
+			return resource.cascadeRestore(ctx, c, id, deletedAt.Time())
+		}
+		return nil
+	})
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no deleted record with this id"})
+		return
+	}
+	var locked dayLockedError
+	if errors.As(err, &locked) {
+		c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+		return
+	}
+	var referenced referencedError
+	if errors.As(err, &referenced) {
+		c.JSON(http.StatusConflict, gin.H{"error": referenced.reason})
+		return
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "another record took the place of this one, it can't be restored"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while restoring the record"})
+		return
+	}
+
+	helpers.SetETag(c, versionOf(restored))
+	c.JSON(http.StatusOK, withoutSecrets(restored))
+}
+
+// The update marking a record as deleted
+func softDelete(deletedAt time.Time, deletedBy string) bson.M {
+	return bson.M{
+		"$set": bson.M{"deleted_at": deletedAt, "deleted_by": deletedBy, "updated_at": deletedAt},
+		"$inc": bson.M{"version": 1},
+	}
+}
+
+// The update bringing a deleted record back
+func softRestore(restoredAt time.Time) bson.M {
+	return bson.M{
+		"$set":   bson.M{"updated_at": restoredAt},
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+}
+
+// This function tells which of the orders are still open: not deleted, and without a paid (or refunded) invoice
+func openOrderIDs(ctx context.Context, orderIDs []string) ([]string, error) {
+	if len(orderIDs) == 0 {
+		return []string{}, nil
+	}
+	paid, err := distinctStrings(ctx, invoiceCollections, "order_id", bson.M{
+		"order_id":       bson.M{"$in": orderIDs},
+		"payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}},
+		"deleted_at":     nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return distinctStrings(ctx, orderCollection, "order_id", bson.M{
+		"order_id":   bson.M{"$in": orderIDs, "$nin": paid},
+		"deleted_at": nil,
+	})
+}
+
+// The string values found by a distinct query
+func distinctStrings(ctx context.Context, collection *mongo.Collection, field string, filter bson.M) ([]string, error) {
+	values, err := collection.Distinct(ctx, field, filter)
+	if err != nil {
+		return nil, err
+	}
+	strs := []string{}
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs, nil
+}
@@ -0,0 +1,557 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/delivery"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var deliveryOrderCollection *mongo.Collection = database.OpenCollection(database.Client, "delivery_orders")
+var deliveryMappingCollection *mongo.Collection = database.OpenCollection(database.Client, "delivery_item_mappings")
+
+var deliveryPlatforms map[string]delivery.Platform = openDeliveryPlatforms()
+
+func openDeliveryPlatforms() map[string]delivery.Platform {
+	platforms, err := delivery.OpenPlatforms()
+	if err != nil {
+		log.Fatal("Error while setting up the delivery platforms: ", err)
+	}
+	return platforms
+}
+
+// The biggest webhook body read from a platform
+const maxDeliveryPayload = 1 << 20
+
+// How many times a status is sent to the platform before waiting for a retry by hand
+const callbackAttempts = 3
+
+// What the listing of the orders received can be filtered and sorted by
+var deliveryOrderListSpec = helpers.ListSpec{
+	SortFields:  []string{"received_at", "updated_at"},
+	DefaultSort: "-received_at",
+	Filters: map[string]helpers.FilterType{
+		"platform":    helpers.FilterString,
+		"external_id": helpers.FilterString,
+		"status":      helpers.FilterString,
+		"order_id":    helpers.FilterString,
+		"received_at": helpers.FilterTime,
+	},
+}
+
+// What the item mapping listing can be filtered and sorted by
+var deliveryMappingListSpec = helpers.ListSpec{
+	SortFields:  []string{"external_item_id", "created_at"},
+	DefaultSort: "external_item_id",
+	Filters: map[string]helpers.FilterType{
+		"platform":         helpers.FilterString,
+		"external_item_id": helpers.FilterString,
+		"food_id":          helpers.FilterString,
+	},
+}
+
+// This function takes an order sent by a delivery platform to /webhooks/delivery/:platform.
+// The webhook must be signed by the platform. The order and its items are created PLACED, waiting for someone
+// to accept them, and the platform gets the order_id back. The same order sent again gets the same order_id,
+// and an order which can't be taken (like an item without a mapping) gets a 422 and is kept REJECTED.
+func ReceiveDeliveryOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		name := c.Param("platform")
+		platform, ok := deliveryPlatforms[name]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown delivery platform"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxDeliveryPayload))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while reading the webhook"})
+			return
+		}
+		if err := platform.Adapter.Verify(c.Request.Header, body); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		external, err := platform.Adapter.Parse(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the audit log shows the platform as the author of the order
+		c.Set("uid", "delivery:"+name)
+
+		if orderID, err := receivedOrder(ctx, name, external.External_id); err != nil || orderID != "" {
+			respondReceivedOrder(c, orderID, err)
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var orderID string
+		err = unitOfWork.Do(ctx, func(ctx context.Context) error {
+			order, orderItems, err := mapDeliveryOrder(ctx, name, external, now)
+			if err != nil {
+				return err
+			}
+			if err := ensureDayOpen(ctx, businessDay(order.Order_Date)); err != nil {
+				return err
+			}
+			if err := checkOrderType(ctx, &order); err != nil {
+				return err
+			}
+			if orderID, err = OrderItemsOrderCreator(ctx, c, order); err != nil {
+				return err
+			}
+			for _, orderItem := range orderItems {
+				orderItem.ID = primitive.NewObjectID()
+				orderItem.Order_item_id = orderItem.ID.Hex()
+				orderItem.Order_id = orderID
+				orderItem.Created_at, orderItem.Updated_at = now, now
+				orderItem.Version = 1
+				if _, err := orderItemsCollection.InsertOne(ctx, orderItem); err != nil {
+					return err
+				}
+				if err := recordAudit(ctx, c, models.AuditCreate, "order_item", orderItem.Order_item_id, nil, orderItem); err != nil {
+					return err
+				}
+			}
+			// the unique index on the platform and the external id stops the same order sent twice at once
+			return saveDeliveryOrder(ctx, name, external.External_id, bson.M{
+				"status":   models.DeliveryOrderCreated,
+				"error":    nil,
+				"order_id": orderID,
+				"payload":  string(body),
+			}, now)
+		})
+
+		if mongo.IsDuplicateKeyError(err) {
+			orderID, err := receivedOrder(ctx, name, external.External_id)
+			respondReceivedOrder(c, orderID, err)
+			return
+		}
+		var invalid validationError
+		var locked dayLockedError
+		if errors.As(err, &invalid) || errors.As(err, &locked) {
+			rejectErr := saveDeliveryOrder(ctx, name, external.External_id, bson.M{
+				"status":  models.DeliveryOrderRejected,
+				"error":   err.Error(),
+				"payload": string(body),
+			}, now)
+			if rejectErr != nil && !mongo.IsDuplicateKeyError(rejectErr) {
+				log.Print("Error while recording the rejected delivery order: ", rejectErr)
+			}
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the order"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID})
+	}
+}
+
+// The id of the order already created for an external order, "" when there is none yet
+func receivedOrder(ctx context.Context, platform string, externalID string) (string, error) {
+	var received models.DeliveryOrder
+	err := deliveryOrderCollection.FindOne(ctx, bson.M{"platform": platform, "external_id": externalID}).Decode(&received)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return stringValue(received.Order_id), nil
+}
+
+func respondReceivedOrder(c *gin.Context, orderID string, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the delivery order"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "duplicate": true})
+}
+
+// This function records what became of an external order. Only the ones without an order yet can change.
+// The ctx decides the transaction it belongs to.
+func saveDeliveryOrder(ctx context.Context, platform string, externalID string, fields bson.M, now time.Time) error {
+	fields["updated_at"] = now
+	id := primitive.NewObjectID()
+	_, err := deliveryOrderCollection.UpdateOne(ctx,
+		bson.M{"platform": platform, "external_id": externalID, "order_id": nil},
+		bson.M{
+			"$set": fields,
+			"$setOnInsert": bson.M{
+				"_id":               id,
+				"delivery_order_id": id.Hex(),
+				"callbacks":         bson.A{},
+				"received_at":       now,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// This function turns an external order into one of our orders and its items, every external item has to be mapped
+// onto one of our foods. A unit of the external items is an order item. What can't be mapped is a validationError.
+func mapDeliveryOrder(ctx context.Context, platform string, external delivery.ExternalOrder, now time.Time) (models.Order, []models.OrderItem, error) {
+	var order models.Order
+
+	externalIDs := bson.A{}
+	for _, item := range external.Items {
+		externalIDs = append(externalIDs, item.External_item_id)
+	}
+	res, err := deliveryMappingCollection.Find(ctx, bson.M{"platform": platform, "external_item_id": bson.M{"$in": externalIDs}, "deleted_at": nil})
+	if err != nil {
+		return order, nil, err
+	}
+	var mappings []models.DeliveryItemMapping
+	if err = res.All(ctx, &mappings); err != nil {
+		return order, nil, err
+	}
+	foods := map[string]string{}
+	for _, mapping := range mappings {
+		foods[*mapping.External_item_id] = *mapping.Food_id
+	}
+
+	unmapped := []string{}
+	orderItems := []models.OrderItem{}
+	for _, item := range external.Items {
+		foodID, ok := foods[item.External_item_id]
+		if !ok {
+			unmapped = append(unmapped, item.External_item_id)
+			continue
+		}
+		count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": foodID, "deleted_at": nil})
+		if err != nil {
+			return order, nil, err
+		}
+		if count == 0 {
+			return order, nil, validationError{fmt.Sprintf("the food of the item %s doesn't exist anymore", item.External_item_id)}
+		}
+
+		size := item.Size
+		if size == "" {
+			size = "M"
+		}
+		if err := validate.Var(size, "eq=S|eq=M|eq=L"); err != nil {
+			return order, nil, validationError{fmt.Sprintf("the size of the item %s must be S, M or L", item.External_item_id)}
+		}
+		price := Tofixed(item.Unit_price, 2)
+		for i := 0; i < item.Quantity; i++ {
+			orderItems = append(orderItems, models.OrderItem{Quantity: &size, Unit_price: &price, Food_id: &foodID})
+		}
+	}
+	if len(unmapped) > 0 {
+		return order, nil, validationError{"no food is mapped to the items " + strings.Join(unmapped, ", ")}
+	}
+
+	orderType := models.OrderDelivery
+	if external.Fulfillment == delivery.FulfillmentPickup {
+		orderType = models.OrderPickup
+	}
+	status := models.OrderPlaced
+	externalID := external.External_id
+	order = models.Order{
+		Order_Date:  now,
+		Order_type:  &orderType,
+		Status:      &status,
+		Source:      &platform,
+		External_id: &externalID,
+		Promised_at: external.Promised_at,
+	}
+	if external.Customer_name != "" {
+		order.Customer_name = &external.Customer_name
+	} else {
+		// the platforms don't always share the name, the ticket still needs one
+		name := fmt.Sprintf("%s %s", platform, external.External_id)
+		order.Customer_name = &name
+	}
+	if external.Customer_phone != "" {
+		order.Customer_phone = &external.Customer_phone
+	}
+	if orderType == models.OrderDelivery {
+		if address := external.Address; address != nil {
+			order.Delivery_address = &models.DeliveryAddress{
+				Street:       &address.Street,
+				City:         &address.City,
+				Postal_code:  &address.Postal_code,
+				Instructions: &address.Instructions,
+			}
+		}
+		fee := Tofixed(external.Delivery_fee, 2)
+		order.Delivery_fee = &fee
+	}
+	if err := validate.Struct(order); err != nil {
+		return order, nil, validationError{err.Error()}
+	}
+	return order, orderItems, nil
+}
+
+// This function queues the new status of an order for its platform, orders taken here have none.
+// The ctx decides the transaction it belongs to.
+func queueDeliveryCallback(ctx context.Context, order models.Order, status string, now time.Time) error {
+	if order.External_id == nil || stringValue(order.Source) == models.OrderSourcePOS {
+		return nil
+	}
+	callback := models.DeliveryCallback{
+		Callback_id: primitive.NewObjectID().Hex(),
+		Status:      status,
+		Created_at:  now,
+	}
+	_, err := deliveryOrderCollection.UpdateOne(ctx,
+		bson.M{"order_id": order.Order_id},
+		bson.M{"$push": bson.M{"callbacks": callback}, "$set": bson.M{"updated_at": now}},
+	)
+	return err
+}
+
+// This function sends the statuses of an order its platform didn't get yet, in the order they happened.
+// Each one is tried a few times, and the sending stops at the first one still failing so the platform
+// never sees the statuses out of order.
+func sendDeliveryCallbacks(ctx context.Context, orderID string) error {
+	var received models.DeliveryOrder
+	err := deliveryOrderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&received)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	platform, ok := deliveryPlatforms[received.Platform]
+	if !ok || platform.Client == nil {
+		return nil
+	}
+
+	for _, callback := range received.Callbacks {
+		if callback.Sent_at != nil {
+			continue
+		}
+		var sendErr error
+		for attempt := 0; attempt < callbackAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt*attempt) * time.Second)
+			}
+			callback.Attempts++
+			if sendErr = platform.Client.SendStatus(ctx, received.External_id, callback.Status); sendErr == nil {
+				break
+			}
+		}
+
+		set := bson.M{"callbacks.$.attempts": callback.Attempts, "updated_at": time.Now()}
+		if sendErr == nil {
+			set["callbacks.$.sent_at"] = time.Now()
+			set["callbacks.$.error"] = nil
+		} else {
+			set["callbacks.$.error"] = sendErr.Error()
+		}
+		if _, err := deliveryOrderCollection.UpdateOne(ctx,
+			bson.M{"delivery_order_id": received.Delivery_order_id, "callbacks.callback_id": callback.Callback_id},
+			bson.M{"$set": set},
+		); err != nil {
+			return err
+		}
+		if sendErr != nil {
+			return sendErr
+		}
+	}
+	return nil
+}
+
+func GetDeliveryOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, deliveryOrderCollection, deliveryOrderListSpec, bson.M{})
+	}
+}
+
+func GetDeliveryOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var received models.DeliveryOrder
+		err := deliveryOrderCollection.FindOne(ctx, bson.M{"delivery_order_id": c.Param("delivery_order_id")}).Decode(&received)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery order not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the delivery order"})
+			return
+		}
+		c.JSON(http.StatusOK, received)
+	}
+}
+
+// This function sends again the statuses the platform didn't get, and answers with the delivery order as it is then
+func RetryDeliveryCallbacks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var received models.DeliveryOrder
+		err := deliveryOrderCollection.FindOne(ctx, bson.M{"delivery_order_id": c.Param("delivery_order_id")}).Decode(&received)
+		if err == mongo.ErrNoDocuments || (err == nil && received.Order_id == nil) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery order not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the delivery order"})
+			return
+		}
+
+		sendErr := sendDeliveryCallbacks(ctx, *received.Order_id)
+		if err := deliveryOrderCollection.FindOne(ctx, bson.M{"delivery_order_id": received.Delivery_order_id}).Decode(&received); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the delivery order"})
+			return
+		}
+		if sendErr != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": sendErr.Error(), "delivery_order": received})
+			return
+		}
+		c.JSON(http.StatusOK, received)
+	}
+}
+
+func GetDeliveryMappings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, deliveryMappingCollection, deliveryMappingListSpec, bson.M{})
+	}
+}
+
+// This function maps an item of a delivery platform onto one of our foods, an item has one mapping per platform
+func CreateDeliveryMapping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var mapping models.DeliveryItemMapping
+		if err := c.BindJSON(&mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		platform := strings.ToLower(*mapping.Platform)
+		mapping.Platform = &platform
+		if _, ok := deliveryPlatforms[platform]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown delivery platform"})
+			return
+		}
+		if msg, err := mappedFood(ctx, *mapping.Food_id); err != nil || msg != "" {
+			respondMappingError(c, http.StatusBadRequest, msg, err)
+			return
+		}
+		// even a deleted mapping, which can still be restored
+		count, err := deliveryMappingCollection.CountDocuments(ctx, bson.M{"platform": platform, "external_item_id": *mapping.External_item_id})
+		if err != nil || count > 0 {
+			respondMappingError(c, http.StatusConflict, "the item is already mapped", err)
+			return
+		}
+
+		mapping.ID = primitive.NewObjectID()
+		mapping.Mapping_id = mapping.ID.Hex()
+		mapping.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		mapping.Updated_at = mapping.Created_at
+		mapping.Version = 1
+		mapping.Deleted_at, mapping.Deleted_by = nil, nil
+
+		res, err := insertAudited(ctx, c, deliveryMappingCollection, "delivery_mapping", mapping.Mapping_id, mapping)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item is already mapped"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the mapping"})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// This function maps the item onto another food, the orders already received keep theirs
+func UpdateDeliveryMapping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var mapping models.DeliveryItemMapping
+		if err := c.BindJSON(&mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if mapping.Food_id != nil {
+			if msg, err := mappedFood(ctx, *mapping.Food_id); err != nil || msg != "" {
+				respondMappingError(c, http.StatusBadRequest, msg, err)
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "food_id", Value: mapping.Food_id})
+		}
+
+		mapping.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: mapping.Updated_at})
+
+		updateVersioned(ctx, c, deliveryMappingCollection, "delivery_mapping", "mapping_id", c.Param("mapping_id"), updateObj)
+	}
+}
+
+// Tells what is wrong with the food an item is mapped onto, "" when it is fine
+func mappedFood(ctx context.Context, foodID string) (string, error) {
+	count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": foodID, "deleted_at": nil})
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "food not found", nil
+	}
+	return "", nil
+}
+
+func respondMappingError(c *gin.Context, status int, msg string, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the mapping"})
+		return
+	}
+	c.JSON(status, gin.H{"error": msg})
+}
+
+// The orders keep their foods, so a mapping can always be deleted
+var deletableDeliveryMapping = deletable{
+	collection:   deliveryMappingCollection,
+	resourceType: "delivery_mapping",
+	idField:      "mapping_id",
+}
+
+func DeleteDeliveryMapping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableDeliveryMapping, c.Param("mapping_id"))
+	}
+}
+
+func RestoreDeliveryMapping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableDeliveryMapping, c.Param("mapping_id"))
+	}
+}
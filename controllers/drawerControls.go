@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var drawerCollection *mongo.Collection = database.OpenCollection(database.Client, "drawers")
+
+// What the drawer listing can be filtered and sorted by
+var drawerListSpec = helpers.ListSpec{
+	SortFields:  []string{"opened_at", "created_at"},
+	DefaultSort: "-opened_at",
+	Filters: map[string]helpers.FilterType{
+		"name":         helpers.FilterString,
+		"status":       helpers.FilterString,
+		"business_day": helpers.FilterString,
+		"opened_by":    helpers.FilterString,
+		"opened_at":    helpers.FilterTime,
+	},
+}
+
+func GetDrawers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, drawerCollection, drawerListSpec, bson.M{})
+	}
+}
+
+// This function gives a drawer, with the cash expected in it right now when it is still open
+func GetDrawerbyID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var drawer models.Drawer
+		err := drawerCollection.FindOne(ctx, bson.M{"drawer_id": c.Param("drawer_id")}).Decode(&drawer)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "drawer not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the drawer"})
+			return
+		}
+
+		if drawer.Status == models.DrawerOpen {
+			expected, err := expectedCash(ctx, drawer)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while counting the drawer"})
+				return
+			}
+			drawer.Expected_amount = &expected
+		}
+
+		helpers.SetETag(c, drawer.Version)
+		c.JSON(http.StatusOK, drawer)
+	}
+}
+
+// This function opens a drawer with its float, there can only be one open drawer with the same name
+func OpenDrawer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var drawer models.Drawer
+		if err := c.BindJSON(&drawer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(drawer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		openingFloat := Tofixed(*drawer.Opening_float, 2)
+		drawer = models.Drawer{
+			ID:            primitive.NewObjectID(),
+			Name:          drawer.Name,
+			Status:        models.DrawerOpen,
+			Business_day:  businessDay(now),
+			Opening_float: &openingFloat,
+			Movements:     []models.DrawerMovement{},
+			Opened_by:     c.GetString("uid"),
+			Opened_at:     now,
+			Created_at:    now,
+			Updated_at:    now,
+			Version:       1,
+		}
+		drawer.Drawer_id = drawer.ID.Hex()
+
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			if err := ensureDayOpen(ctx, drawer.Business_day); err != nil {
+				return err
+			}
+			count, err := drawerCollection.CountDocuments(ctx, bson.M{"name": drawer.Name, "status": models.DrawerOpen})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return validationError{"a drawer with this name is already open"}
+			}
+			if _, err = drawerCollection.InsertOne(ctx, drawer); err != nil {
+				return err
+			}
+			return recordAudit(ctx, c, models.AuditCreate, "drawer", drawer.Drawer_id, nil, drawer)
+		})
+		if respondDrawerError(c, err) {
+			return
+		}
+
+		helpers.SetETag(c, drawer.Version)
+		c.JSON(http.StatusOK, drawer)
+	}
+}
+
+// This function records cash dropped from the drawer to the safe
+func DropCash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		moveCash(c, models.DrawerDrop)
+	}
+}
+
+// This function records cash paid out of the drawer
+func PayoutCash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		moveCash(c, models.DrawerPayout)
+	}
+}
+
+func moveCash(c *gin.Context, kind string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var movement models.DrawerMovement
+	if err := c.BindJSON(&movement); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validate.Struct(movement); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	amount := Tofixed(*movement.Amount, 2)
+	movement.Amount = &amount
+	movement.Kind = kind
+	movement.Created_by = c.GetString("uid")
+	movement.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	var drawer models.Drawer
+	err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		drawer, err = updateOpenDrawer(ctx, c, c.Param("drawer_id"), bson.M{
+			"$push": bson.M{"movements": movement},
+			"$set":  bson.M{"updated_at": movement.Created_at},
+			"$inc":  bson.M{"version": 1},
+		})
+		return err
+	})
+	if respondDrawerError(c, err) {
+		return
+	}
+
+	helpers.SetETag(c, drawer.Version)
+	c.JSON(http.StatusOK, drawer)
+}
+
+// This function closes a drawer with the cash counted in it, and reports the variance with what was expected
+func CloseDrawer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Counted_amount *float64 `json:"counted_amount" validate:"required,min=0"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		drawerID := c.Param("drawer_id")
+		closedBy := c.GetString("uid")
+		closedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		counted := Tofixed(*body.Counted_amount, 2)
+
+		var closed models.Drawer
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			var drawer models.Drawer
+			err := drawerCollection.FindOne(ctx, bson.M{"drawer_id": drawerID, "status": models.DrawerOpen}).Decode(&drawer)
+			if err != nil {
+				return err
+			}
+			expected, err := expectedCash(ctx, drawer)
+			if err != nil {
+				return err
+			}
+			variance := Tofixed(counted-expected, 2)
+
+			closed, err = updateOpenDrawer(ctx, c, drawerID, bson.M{
+				"$set": bson.M{
+					"status":          models.DrawerClosed,
+					"counted_amount":  counted,
+					"expected_amount": expected,
+					"variance":        variance,
+					"closed_by":       closedBy,
+					"closed_at":       closedAt,
+					"updated_at":      closedAt,
+				},
+				"$inc": bson.M{"version": 1},
+			})
+			return err
+		})
+		if respondDrawerError(c, err) {
+			return
+		}
+
+		helpers.SetETag(c, closed.Version)
+		c.JSON(http.StatusOK, closed)
+	}
+}
+
+// This function changes a drawer which is still open, auditing the change. The ctx decides the transaction it belongs to.
+func updateOpenDrawer(ctx context.Context, c *gin.Context, drawerID string, update bson.M) (models.Drawer, error) {
+	var drawer models.Drawer
+	var before bson.M
+	err := drawerCollection.FindOneAndUpdate(ctx, bson.M{"drawer_id": drawerID, "status": models.DrawerOpen}, update).Decode(&before)
+	if err != nil {
+		return drawer, err
+	}
+	if err = ensureRecordDayOpen(ctx, "drawer", before); err != nil {
+		return drawer, err
+	}
+	if err = drawerCollection.FindOne(ctx, bson.M{"drawer_id": drawerID}).Decode(&drawer); err != nil {
+		return drawer, err
+	}
+	return drawer, recordAudit(ctx, c, models.AuditUpdate, "drawer", drawerID, before, drawer)
+}
+
+// Answers the errors of the drawer handlers, tells if there was one
+func respondDrawerError(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var locked dayLockedError
+	var invalid validationError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no open drawer with this id"})
+	case errors.As(err, &locked):
+		c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating the drawer"})
+	}
+	return true
+}
+
+// The cash which should be in the drawer: the float, plus the cash payments taken,
+// minus the cash refunds given, the drops and the payouts. The part of an invoice paid with gift cards is not cash.
+func expectedCash(ctx context.Context, drawer models.Drawer) (float64, error) {
+	expected := *drawer.Opening_float
+
+	res, err := invoiceCollections.Find(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"drawer_id": drawer.Drawer_id},
+			bson.M{"refund_drawer_id": drawer.Drawer_id},
+		},
+		"payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}},
+		"deleted_at":     nil,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var invoices []models.Invoice
+	if err = res.All(ctx, &invoices); err != nil {
+		return 0, err
+	}
+	for _, invoice := range invoices {
+		if invoice.Total == nil {
+			continue
+		}
+		cash := *invoice.Total
+		if invoice.Gift_card_amount != nil {
+			cash -= *invoice.Gift_card_amount
+		}
+		if invoice.Drawer_id != nil && *invoice.Drawer_id == drawer.Drawer_id {
+			expected += cash
+		}
+		if invoice.Refund_drawer_id != nil && *invoice.Refund_drawer_id == drawer.Drawer_id {
+			expected -= cash
+		}
+	}
+
+	for _, movement := range drawer.Movements {
+		if movement.Amount != nil {
+			expected -= *movement.Amount
+		}
+	}
+	return Tofixed(expected, 2), nil
+}
+
+// This function picks the drawer cash goes in or out of: the one asked for, or the only open one
+func pickDrawer(ctx context.Context, requested *string) (string, error) {
+	filter := bson.M{"status": models.DrawerOpen}
+	if requested != nil && *requested != "" {
+		filter["drawer_id"] = *requested
+	}
+	ids, err := distinctStrings(ctx, drawerCollection, "drawer_id", filter)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(ids) == 1:
+		return ids[0], nil
+	case requested != nil && *requested != "":
+		return "", validationError{"the drawer is not open"}
+	case len(ids) == 0:
+		return "", validationError{"open a drawer before taking cash"}
+	default:
+		return "", validationError{fmt.Sprintf("%d drawers are open, tell which one with drawer_id", len(ids))}
+	}
+}
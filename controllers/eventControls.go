@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/events"
+	"restaurantms/models"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Who the changes made by the service itself are recorded as
+const systemActor = "system"
+
+var outboxCollection *mongo.Collection = database.OpenCollection(database.Client, "outbox")
+var eventCheckpointCollection *mongo.Collection = database.OpenCollection(database.Client, "outbox_checkpoints")
+
+// The domain events go through the outbox, a broker could take its place behind events.Bus
+var eventBus events.Bus = events.NewMongoBus(outboxCollection, eventCheckpointCollection)
+
+// This function publishes an event about a record, with the record as it is stored once changed (without its secrets).
+// The ctx decides the transaction it belongs to, so the event only exists if the change is saved.
+// Without a request, the event is the service's own doing.
+func publishEvent(ctx context.Context, c *gin.Context, eventType string, resourceType string, collection *mongo.Collection, idField string, id string) error {
+	return publishEventWith(ctx, c, eventType, resourceType, collection, idField, id, nil)
+}
+
+// Same as publishEvent, with more about the change than the record tells, like where it was before
+func publishEventWith(ctx context.Context, c *gin.Context, eventType string, resourceType string, collection *mongo.Collection, idField string, id string, extra bson.M) error {
+	var record bson.M
+	if err := collection.FindOne(ctx, bson.M{idField: id}).Decode(&record); err != nil {
+		return err
+	}
+	delete(record, "_id")
+	for key, value := range extra {
+		record[key] = value
+	}
+
+	event := events.Event{
+		Event_id:      primitive.NewObjectID().Hex(),
+		Type:          eventType,
+		Resource_type: resourceType,
+		Resource_id:   id,
+		Data:          withoutSecrets(record),
+		Actor_id:      systemActor,
+	}
+	event.Occurred_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if c != nil {
+		event.Actor_id = c.GetString("uid")
+		event.Request_id = c.GetString("request_id")
+	}
+	if err := eventBus.Publish(ctx, event); err != nil {
+		return err
+	}
+	database.OnRollback(ctx, func(ctx context.Context) error {
+		_, err := outboxCollection.DeleteOne(ctx, bson.M{"event_id": event.Event_id})
+		return err
+	})
+	return nil
+}
+
+// This function subscribes the parts of the service reacting to the events, and starts the workers running in the
+// background: the event bus and the webhook dispatcher. They stop once the ctx is done, the wait group tells when.
+// The kitchen display and the inventory will subscribe here the same way once they exist.
+func StartWorkers(ctx context.Context) *sync.WaitGroup {
+	eventBus.Subscribe("webhooks", queueWebhookDeliveries)
+	eventBus.Subscribe("delivery_callbacks", sendStatusToPlatform)
+	eventBus.Subscribe("table_status", followTableOrders)
+	eventBus.Subscribe("loyalty", followInvoiceLoyalty)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := eventBus.Run(ctx); err != nil {
+			log.Print("Error while running the event bus: ", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		runWebhookDispatcher(ctx)
+	}()
+	return &wg
+}
+
+// This function tells the platform of an order coming from a delivery platform its new status.
+// A status the platform doesn't get fails the event, so the bus tries it again later; once the bus gives up on it,
+// it stays on the delivery order, to be sent again by hand.
+func sendStatusToPlatform(ctx context.Context, event events.Event) error {
+	if event.Type != models.EventOrderStatusChanged || event.Data["external_id"] == nil {
+		return nil
+	}
+	return sendDeliveryCallbacks(ctx, event.Resource_id)
+}
+
+// This function tells how far each subscriber of the event bus got, how many events it still has to handle and how
+// many it gave up on
+func GetEventSubscribers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		res, err := eventCheckpointCollection.Find(ctx, bson.M{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the subscribers"})
+			return
+		}
+		var checkpoints []bson.M
+		if err = res.All(ctx, &checkpoints); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the subscribers"})
+			return
+		}
+		for _, checkpoint := range checkpoints {
+			delete(checkpoint, "_id")
+			pending, err := outboxCollection.CountDocuments(ctx, bson.M{"pending": checkpoint["subscriber"]})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the subscribers"})
+				return
+			}
+			checkpoint["pending"] = pending
+			deadLetters, err := outboxCollection.CountDocuments(ctx, bson.M{"dead_letters": checkpoint["subscriber"]})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the subscribers"})
+				return
+			}
+			checkpoint["dead_letters"] = deadLetters
+		}
+		c.JSON(http.StatusOK, checkpoints)
+	}
+}
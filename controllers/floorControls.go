@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"restaurantms/models"
+	"sort"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How often the floor stream tells the client it is still there
+const floorHeartbeat = 15 * time.Second
+
+// This function shows the whole floor (?section= for one section): every table with its status, how long its party
+// has been seated and what its open orders come to, along with the sections and how many tables are in each status
+func GetFloor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"deleted_at": nil}
+		if section := c.Query("section"); section != "" {
+			filter["section"] = section
+		}
+		res, err := tablesCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "section", Value: 1}, {Key: "table_number", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the floor"})
+			return
+		}
+		var tables []models.Table
+		if err = res.All(ctx, &tables); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the floor"})
+			return
+		}
+
+		tableIDs := []string{}
+		for _, table := range tables {
+			tableIDs = append(tableIDs, table.Table_id)
+		}
+		openByTable, err := tablesOpenOrders(ctx, tableIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the floor"})
+			return
+		}
+
+		now := time.Now()
+		floor := []models.FloorTable{}
+		sections := []string{}
+		counts := map[string]int{}
+		openOrders := bson.A{}
+		for _, table := range tables {
+			floorTable := floorTableOf(table, openByTable[table.Table_id], now)
+			floor = append(floor, floorTable)
+			counts[*floorTable.Status]++
+			if table.Section != nil && !containsString(sections, *table.Section) {
+				sections = append(sections, *table.Section)
+			}
+			for _, orderID := range floorTable.Open_orders {
+				openOrders = append(openOrders, orderID)
+			}
+		}
+		sort.Strings(sections)
+
+		checks, err := openCheckTotals(ctx, openOrders)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the floor"})
+			return
+		}
+		for i := range floor {
+			for _, orderID := range floor[i].Open_orders {
+				floor[i].Open_check_total += checks[orderID]
+			}
+			floor[i].Open_check_total = Tofixed(floor[i].Open_check_total, 2)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tables": floor, "sections": sections, "counts": counts})
+	}
+}
+
+// A table with its seated time and open orders, their checks are added up by openCheckTotals
+func floorTableOf(table models.Table, open []string, now time.Time) models.FloorTable {
+	status := tableStatusOf(table)
+	table.Status = &status
+	floorTable := models.FloorTable{Table: table, Open_orders: []string{}}
+	if table.Seated_at != nil {
+		minutes := int(now.Sub(*table.Seated_at).Minutes())
+		floorTable.Seated_minutes = &minutes
+	}
+	floorTable.Open_orders = append(floorTable.Open_orders, open...)
+	return floorTable
+}
+
+// The open orders of each of the tables, like tableOpenOrders with the same few queries for the whole floor
+func tablesOpenOrders(ctx context.Context, tableIDs []string) (map[string][]string, error) {
+	byTable := map[string][]string{}
+	if len(tableIDs) == 0 {
+		return byTable, nil
+	}
+	res, err := orderCollection.Find(ctx, bson.M{
+		"table_id":   bson.M{"$in": tableIDs},
+		"status":     bson.M{"$nin": bson.A{models.OrderCancelled, models.OrderMerged}},
+		"deleted_at": nil,
+	}, options.Find().SetProjection(bson.M{"order_id": 1, "table_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var orders []models.Order
+	if err = res.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	orderIDs := []string{}
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.Order_id)
+	}
+	open, err := openOrderIDs(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	isOpen := map[string]bool{}
+	for _, orderID := range open {
+		isOpen[orderID] = true
+	}
+	for _, order := range orders {
+		if order.Table_id != nil && isOpen[order.Order_id] {
+			byTable[*order.Table_id] = append(byTable[*order.Table_id], order.Order_id)
+		}
+	}
+	return byTable, nil
+}
+
+// The open check of each order: the total stored on its pending invoice when one was dropped, or what its items come
+// to otherwise. Nothing is computed again, so a coupon expired since can't make the floor fail.
+func openCheckTotals(ctx context.Context, orderIDs bson.A) (map[string]float64, error) {
+	checks := map[string]float64{}
+	if len(orderIDs) == 0 {
+		return checks, nil
+	}
+
+	res, err := invoiceCollections.Find(ctx, bson.M{"order_id": bson.M{"$in": orderIDs}, "payment_status": "PENDING", "deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+	var invoices []models.Invoice
+	if err = res.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+	dropped := map[string]bool{}
+	for _, invoice := range invoices {
+		dropped[invoice.Order_id] = true
+		checks[invoice.Order_id] += floatValue(invoice.Total)
+	}
+
+	undropped := bson.A{}
+	for _, orderID := range orderIDs {
+		if !dropped[orderID.(string)] {
+			undropped = append(undropped, orderID)
+		}
+	}
+	rows, err := orderItemsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"order_id": bson.M{"$in": undropped}, "deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{"_id": "$order_id", "amount": bson.M{"$sum": "$unit_price"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var amounts []struct {
+		Order_id string  `bson:"_id"`
+		Amount   float64 `bson:"amount"`
+	}
+	if err = rows.All(ctx, &amounts); err != nil {
+		return nil, err
+	}
+	for _, amount := range amounts {
+		checks[amount.Order_id] = amount.Amount
+	}
+	return checks, nil
+}
+
+// This function streams the status changes of the tables as server-sent events, an event "table" for each change
+// with the table as it became. It follows the changes made by every instance of the service, and ends when the
+// client leaves or the server stops.
+func StreamFloor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		changes, err := eventBus.Watch(ctx, models.EventTableStatusChanged)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while following the floor"})
+			return
+		}
+		section := c.Query("section")
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(floorHeartbeat)
+		defer heartbeat.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-heartbeat.C:
+				io.WriteString(w, ": heartbeat\n\n")
+				return true
+			case event, ok := <-changes:
+				if !ok {
+					return false
+				}
+				if section != "" && event.Data["section"] != section {
+					return true
+				}
+				c.Render(-1, sse.Event{Id: event.Event_id, Event: "table", Data: event.Data})
+				return true
+			}
+		})
+	}
+}
@@ -3,12 +3,11 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"log"
 	"math"
 	"net/http"
 	"restaurantms/database"
+	"restaurantms/helpers"
 	"restaurantms/models"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,64 +15,29 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var foodCollection *mongo.Collection = database.OpenCollection(database.Client, "food")
 var validate = validator.New()
 
+// What the food listing can be filtered and sorted by
+var foodListSpec = helpers.ListSpec{
+	SortFields:  []string{"name", "price", "created_at", "updated_at"},
+	DefaultSort: "name",
+	Filters: map[string]helpers.FilterType{
+		"menu_id":      helpers.FilterString,
+		"name":         helpers.FilterString,
+		"price":        helpers.FilterFloat,
+		"is_available": helpers.FilterBool,
+		"created_at":   helpers.FilterTime,
+		"updated_at":   helpers.FilterTime,
+	},
+}
+
 // Getting all at once
 func GetFoods() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-
-		// The number of records we will be sending per page, if the records per page is not given, then by default it willbe 10 records per page
-		recordPerPage, err := strconv.Atoi(c.Query("recordsPerPage"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
-
-		// The page you want to get, if nothing is entered it return the value of the first page
-		page, err := strconv.Atoi(c.Query("page"))
-		if err != nil || page < 1 {
-			page = 1
-		}
-
-		// from where the page is going to start
-		startIndex := (page - 1) * recordPerPage
-		startIndex, err = strconv.Atoi(c.Query("startIndex"))
-
-		// Aggregations
-		// Matching function, this matches the records by following the criteria we pass
-		// Here we are matching by passing nothigng
-		matchStage := bson.D{{"$match", bson.D{{}}}}
-		// Grouping funcitons, this will group the data based on the basis of criteria provided
-		groupStage := bson.D{{"$group", bson.D{{"_id", bson.D{{"_id", "null"}}}, {"total_count", bson.D{{"$sum", 1}}}, {"data", bson.D{{"$push", "$$ROOT"}}}}}}
-		// Project funtions, what will be the values that will be going to the interface for user
-		// If the id is 0, it won't go to interface, and if it's 1 it will go the interface of user.
-		// Then we are getting the food items, using the slice, using $start
-		projectStage := bson.D{
-			{
-				"$project", bson.D{
-					{"_id", 0},
-					{"total_count", 1},
-					{"food_items", bson.D{{"$slice", []interface{}{"$data", startIndex, recordPerPage}}}},
-				}}}
-
-		res, err := foodCollection.Aggregate(ctx, mongo.Pipeline{
-			matchStage, groupStage, projectStage,
-		})
-
-		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while getting the data"})
-		}
-		var allFoods []bson.M
-		if err = res.All(ctx, &allFoods); err != nil {
-			log.Fatal(err)
-		}
-
-		c.JSON(http.StatusOK, allFoods[0])
+		listCollection(c, foodCollection, foodListSpec, bson.M{})
 	}
 }
 
@@ -81,17 +45,23 @@ func GetFoods() gin.HandlerFunc {
 func GetFoodbyID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		foodID := c.Param("food_id")
 		var food models.Food
 
-		err := foodCollection.FindOne(ctx, bson.M{"food_id": foodID}).Decode(&food) //converting into soemething that GOlang understands
+		err := foodCollection.FindOne(ctx, withDeleted(c, bson.M{"food_id": foodID})).Decode(&food) //converting into soemething that GOlang understands
 
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"Error": "Something weird happenend while searching for your request",
 			})
+			return
 		}
-		defer cancel()
+		helpers.SetETag(c, food.Version)
 		c.JSON(http.StatusOK, food)
 	}
 }
@@ -108,6 +78,7 @@ func CreateFood() gin.HandlerFunc {
 			c.JSON(http.StatusBadGateway, gin.H{
 				"error": err.Error(),
 			})
+			return
 		}
 		validationErr := validate.Struct(food)
 		if validationErr != nil {
@@ -117,11 +88,12 @@ func CreateFood() gin.HandlerFunc {
 			return
 		}
 		// Finding the search item
-		err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
+		err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id, "deleted_at": nil}).Decode(&menu)
 		defer cancel()
 		if err != nil {
 			msg := fmt.Sprintf("menu not found")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
 		}
 
 		// Creation updation
@@ -129,11 +101,19 @@ func CreateFood() gin.HandlerFunc {
 		food.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.ID = primitive.NewObjectID()
 		food.Food_id = food.ID.Hex()
+		food.Version = 1
+		// the image asset is only set by uploading the image
+		food.Image_asset_id = nil
+		food.Allergens = normalizeAllergens(food.Allergens)
+		if food.Is_available == nil {
+			available := true
+			food.Is_available = &available
+		}
 		var num = Tofixed(*food.Price, 2)
 		food.Price = &num
 
 		// Inserting in the database
-		res, insertErr := foodCollection.InsertOne(ctx, food)
+		res, insertErr := insertAudited(ctx, c, foodCollection, "food", food.Food_id, food)
 		if insertErr != nil {
 			msg := fmt.Sprintf("Unable to create the food")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
@@ -156,6 +136,7 @@ func Tofixed(num float64, precision int) float64 {
 func UpdateFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var menu *models.Menu
 		var food *models.Food
@@ -166,6 +147,7 @@ func UpdateFood() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": err.Error(),
 			})
+			return
 		}
 
 		var updateObj primitive.D
@@ -175,51 +157,74 @@ func UpdateFood() gin.HandlerFunc {
 		}
 
 		if food.Price != nil {
-			updateObj = append(updateObj, bson.E{"price", food.Price})
+			var num = Tofixed(*food.Price, 2)
+			updateObj = append(updateObj, bson.E{"price", num})
 		}
 
 		if food.Food_image != nil {
 			updateObj = append(updateObj, bson.E{"food_image", food.Food_image})
 		}
 
+		if food.Description != nil {
+			updateObj = append(updateObj, bson.E{Key: "description", Value: food.Description})
+		}
+
+		if food.Allergens != nil {
+			updateObj = append(updateObj, bson.E{Key: "allergens", Value: normalizeAllergens(food.Allergens)})
+		}
+
+		if food.Is_available != nil {
+			updateObj = append(updateObj, bson.E{Key: "is_available", Value: food.Is_available})
+		}
+
 		if food.Menu_id != nil {
-			err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
-			defer cancel()
+			err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id, "deleted_at": nil}).Decode(&menu)
 			if err != nil {
 				msg := fmt.Sprintf("message:Menu was not found")
-				c.JSON(http.StatusInternalServerError, gin.H{
+				c.JSON(http.StatusBadRequest, gin.H{
 					"error": msg})
 				return
 			}
 
-			updateObj = append(updateObj, bson.E{"menu", food.Price})
+			updateObj = append(updateObj, bson.E{Key: "menu_id", Value: food.Menu_id})
 		}
 
 		food.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{"updated_at", food.Updated_at})
 
-		upsert := true
-		filter := bson.M{"food_id": foodID}
+		updateVersioned(ctx, c, foodCollection, "food", "food_id", foodID, updateObj)
+	}
+}
 
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+// A food can't be deleted while it is part of an open order
+var deletableFood = deletable{
+	collection:   foodCollection,
+	resourceType: "food",
+	idField:      "food_id",
+	references: func(ctx context.Context, id string) (string, error) {
+		orderIDs, err := distinctStrings(ctx, orderItemsCollection, "order_id", bson.M{"food_id": id, "deleted_at": nil})
+		if err != nil {
+			return "", err
 		}
-
-		res, err := foodCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{"$set", updateObj},
-			},
-			&opt,
-		)
+		open, err := openOrderIDs(ctx, orderIDs)
 		if err != nil {
-			msg := fmt.Sprintf("Updation Failed: Food Items")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
+			return "", err
+		}
+		if len(open) > 0 {
+			return "the food is part of an open order", nil
 		}
+		return "", nil
+	},
+}
 
-		c.JSON(http.StatusOK, res)
+func DeleteFood() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableFood, c.Param("food_id"))
+	}
+}
 
+func RestoreFood() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableFood, c.Param("food_id"))
 	}
 }
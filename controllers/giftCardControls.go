@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var giftCardCollection *mongo.Collection = database.OpenCollection(database.Client, "gift_cards")
+
+// Every change of a gift card balance, append only
+var giftCardTransactionCollection *mongo.Collection = database.OpenCollection(database.Client, "gift_card_transactions")
+
+// What the gift card listing can be filtered and sorted by
+var giftCardListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "balance", "expires_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"issued_by":  helpers.FilterString,
+		"balance":    helpers.FilterFloat,
+		"expires_at": helpers.FilterTime,
+		"created_at": helpers.FilterTime,
+	},
+}
+
+// What the transactions of a gift card can be filtered and sorted by
+var giftCardTransactionListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"kind":       helpers.FilterString,
+		"invoice_id": helpers.FilterString,
+		"created_at": helpers.FilterTime,
+	},
+}
+
+// The letters of the gift card codes, without the ones easily read as another (0/O, 1/I)
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func newGiftCardCode() (string, error) {
+	code := make([]byte, 16)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = giftCardAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// The code of a gift card is enough to pay with it, only its last 4 characters are shown in the listing
+func maskGiftCardCode(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return strings.Repeat("*", len(code)-4) + code[len(code)-4:]
+}
+
+func GetGiftCards() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollectionMapped(c, giftCardCollection, giftCardListSpec, bson.M{}, func(item bson.M) {
+			if code, ok := item["code"].(string); ok {
+				item["code"] = maskGiftCardCode(code)
+			}
+		})
+	}
+}
+
+// This function gives the balance of a gift card, found by its code
+func GetGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var card models.GiftCard
+		err := giftCardCollection.FindOne(ctx, bson.M{"code": strings.ToUpper(c.Param("code"))}).Decode(&card)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "gift card not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the gift card"})
+			return
+		}
+		card.Balance = Tofixed(card.Balance, 2)
+		helpers.SetETag(c, card.Version)
+		c.JSON(http.StatusOK, gin.H{"gift_card": card, "expired": giftCardExpired(card, time.Now())})
+	}
+}
+
+func GetGiftCardTransactions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, giftCardTransactionCollection, giftCardTransactionListSpec, bson.M{"code": strings.ToUpper(c.Param("code"))})
+	}
+}
+
+// This function issues a gift card with its initial balance and a new unique code
+func IssueGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var card models.GiftCard
+		if err := c.BindJSON(&card); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(card); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		if card.Expires_at != nil && !card.Expires_at.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+
+		initial := Tofixed(*card.Initial_balance, 2)
+		card.Initial_balance = &initial
+		card.Balance = initial
+		card.Issued_by = c.GetString("uid")
+		card.Created_at, card.Updated_at = now, now
+		card.Version = 1
+
+		// a new code is drawn in the unlikely case it is already taken
+		var err error
+		for attempt := 0; attempt < 3; attempt++ {
+			card.ID = primitive.NewObjectID()
+			card.Gift_card_id = card.ID.Hex()
+			if card.Code, err = newGiftCardCode(); err != nil {
+				break
+			}
+			err = unitOfWork.Do(ctx, func(ctx context.Context) error {
+				if _, err := giftCardCollection.InsertOne(ctx, card); err != nil {
+					return err
+				}
+				if _, err := insertGiftCardTransaction(ctx, c, card, models.GiftCardTransaction{Kind: models.GiftCardIssue, Amount: initial}); err != nil {
+					return err
+				}
+				return recordAudit(ctx, c, models.AuditCreate, "gift_card", card.Gift_card_id, nil, card)
+			})
+			if !mongo.IsDuplicateKeyError(err) {
+				break
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while issuing the gift card"})
+			return
+		}
+		c.JSON(http.StatusOK, card)
+	}
+}
+
+// This function adds to the balance of a gift card which hasn't expired
+func ReloadGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Amount *float64 `json:"amount" validate:"required,gt=0"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var card models.GiftCard
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			var err error
+			card, _, err = moveGiftCard(ctx, c, c.Param("code"), models.GiftCardTransaction{Kind: models.GiftCardReload, Amount: Tofixed(*body.Amount, 2)})
+			return err
+		})
+		var invalid validationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reloading the gift card"})
+			return
+		}
+		helpers.SetETag(c, card.Version)
+		c.JSON(http.StatusOK, card)
+	}
+}
+
+func giftCardExpired(card models.GiftCard, at time.Time) bool {
+	return card.Expires_at != nil && !at.Before(*card.Expires_at)
+}
+
+// This function changes the balance of a gift card by the amount of the transaction (negative to take from it),
+// and records the transaction with the balance after it. The balance is checked and changed in one atomic update,
+// so two terminals can't spend the same money. Refunds go back even on an expired card. The ctx decides the transaction it belongs to.
+func moveGiftCard(ctx context.Context, c *gin.Context, code string, transaction models.GiftCardTransaction) (models.GiftCard, models.GiftCardTransaction, error) {
+	code = strings.ToUpper(code)
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	filter := bson.M{"code": code}
+	if transaction.Kind != models.GiftCardRefund {
+		filter["$or"] = bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}}
+	}
+	// the balances are sums of amounts with cents, so they are compared to the half cent
+	if transaction.Amount < 0 {
+		filter["balance"] = bson.M{"$gte": -transaction.Amount - 0.005}
+	}
+
+	var before, after models.GiftCard
+	err := giftCardCollection.FindOneAndUpdate(ctx, filter, bson.M{
+		"$inc": bson.M{"balance": transaction.Amount, "version": 1},
+		"$set": bson.M{"updated_at": now},
+	}).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return after, transaction, giftCardRefusal(ctx, code, now)
+	}
+	if err != nil {
+		return after, transaction, err
+	}
+	if err = giftCardCollection.FindOne(ctx, bson.M{"code": code}).Decode(&after); err != nil {
+		return after, transaction, err
+	}
+	after.Balance = Tofixed(after.Balance, 2)
+	if transaction, err = insertGiftCardTransaction(ctx, c, after, transaction); err != nil {
+		return after, transaction, err
+	}
+	return after, transaction, recordAudit(ctx, c, models.AuditUpdate, "gift_card", after.Gift_card_id, before, after)
+}
+
+// Why a gift card couldn't be used, as a validationError
+func giftCardRefusal(ctx context.Context, code string, at time.Time) error {
+	var card models.GiftCard
+	err := giftCardCollection.FindOne(ctx, bson.M{"code": code}).Decode(&card)
+	if err == mongo.ErrNoDocuments {
+		return validationError{fmt.Sprintf("the gift card %s doesn't exist", code)}
+	}
+	if err != nil {
+		return err
+	}
+	if giftCardExpired(card, at) {
+		return validationError{fmt.Sprintf("the gift card %s has expired", code)}
+	}
+	return validationError{fmt.Sprintf("the gift card %s only has %.2f left", code, Tofixed(card.Balance, 2))}
+}
+
+func insertGiftCardTransaction(ctx context.Context, c *gin.Context, card models.GiftCard, transaction models.GiftCardTransaction) (models.GiftCardTransaction, error) {
+	if transaction.ID.IsZero() {
+		transaction.ID = primitive.NewObjectID()
+	}
+	transaction.Transaction_id = transaction.ID.Hex()
+	transaction.Gift_card_id = card.Gift_card_id
+	transaction.Code = card.Code
+	transaction.Balance = Tofixed(card.Balance, 2)
+	transaction.Created_by = c.GetString("uid")
+	transaction.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := giftCardTransactionCollection.InsertOne(ctx, transaction)
+	return transaction, err
+}
+
+// This function plans how an invoice is paid: the gift cards pay what they can (or the amount asked for each),
+// and the payment method pays the rest. It gives the payment method (GIFT_CARD when the cards pay everything),
+// the gift cards with the amount each one pays and the transaction it will be, and what they pay in all.
+// The balances are only read here; they are taken, and checked again, by chargeGiftCards.
+func planPayment(ctx context.Context, paymentMethod *string, giftCards []models.GiftCardTender, total float64) (*string, []models.GiftCardTender, float64, error) {
+	planned := []models.GiftCardTender{}
+	paid := 0.0
+	now := time.Now()
+
+	for _, tender := range giftCards {
+		code := strings.ToUpper(*tender.Code)
+		left := Tofixed(total-paid, 2)
+		if left <= 0 {
+			return paymentMethod, nil, 0, validationError{fmt.Sprintf("the invoice is already paid without the gift card %s", code)}
+		}
+
+		var card models.GiftCard
+		err := giftCardCollection.FindOne(ctx, bson.M{"code": code}).Decode(&card)
+		if err == mongo.ErrNoDocuments || (err == nil && giftCardExpired(card, now)) {
+			return paymentMethod, nil, 0, giftCardRefusal(ctx, code, now)
+		}
+		if err != nil {
+			return paymentMethod, nil, 0, err
+		}
+
+		card.Balance = Tofixed(card.Balance, 2)
+		amount := card.Balance
+		if tender.Amount != nil {
+			amount = *tender.Amount
+		}
+		amount = Tofixed(amount, 2)
+		if amount > left {
+			amount = left
+		}
+		if amount > card.Balance || amount <= 0 {
+			return paymentMethod, nil, 0, validationError{fmt.Sprintf("the gift card %s only has %.2f left", code, card.Balance)}
+		}
+
+		transactionID := primitive.NewObjectID().Hex()
+		planned = append(planned, models.GiftCardTender{Code: &code, Amount: &amount, Transaction_id: &transactionID})
+		paid += amount
+	}
+	paid = Tofixed(paid, 2)
+
+	if len(planned) > 0 && paid >= total {
+		method := models.PaymentGiftCard
+		return &method, planned, paid, nil
+	}
+	if paymentMethod != nil && *paymentMethod == models.PaymentGiftCard {
+		return paymentMethod, nil, 0, validationError{"the gift cards don't pay the whole invoice, another payment method is needed"}
+	}
+	return paymentMethod, planned, paid, nil
+}
+
+// This function takes from the gift cards the amounts planned for an invoice being paid.
+// A card spent elsewhere in the meantime is a validationError. The ctx decides the transaction it belongs to.
+func chargeGiftCards(ctx context.Context, c *gin.Context, invoiceID string, giftCards []models.GiftCardTender) error {
+	for _, tender := range giftCards {
+		transactionID, _ := primitive.ObjectIDFromHex(stringValue(tender.Transaction_id))
+		_, _, err := moveGiftCard(ctx, c, *tender.Code, models.GiftCardTransaction{
+			ID:         transactionID,
+			Kind:       models.GiftCardRedeem,
+			Amount:     -*tender.Amount,
+			Invoice_id: &invoiceID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function gives back to the gift cards what they paid of an invoice being refunded.
+// The ctx decides the transaction it belongs to.
+func refundGiftCards(ctx context.Context, c *gin.Context, invoiceID string, giftCards []models.GiftCardTender) error {
+	for _, tender := range giftCards {
+		if tender.Amount == nil {
+			continue
+		}
+		_, _, err := moveGiftCard(ctx, c, *tender.Code, models.GiftCardTransaction{
+			Kind:       models.GiftCardRefund,
+			Amount:     *tender.Amount,
+			Invoice_id: &invoiceID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
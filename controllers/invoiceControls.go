@@ -2,10 +2,11 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"restaurantms/database"
+	"restaurantms/helpers"
 	"restaurantms/models"
 	"time"
 
@@ -13,45 +14,54 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type invoiceViewFormat struct {
 	Invoice_Id       string
 	Payment_method   string
 	Order_id         string
+	Customer_id      *string
 	Payment_status   *string
 	Payment_due      interface{}
 	Table_number     interface{}
 	Payment_due_date time.Time
 	Order_details    interface{}
+	Subtotal         *float64
+	Discount         *float64
+	Service_charge   *float64
+	Delivery_fee     *float64
+	Tax              *float64
+	Tip              *float64
+	Total            *float64
+	Discount_lines   []models.DiscountLine
+	Gift_cards       []models.GiftCardTender
+	Gift_card_amount *float64
+	Manual_discount  *models.ManualDiscount
+	Paid_at          *time.Time
+	Business_day     *string
 }
 
 // Initializing the database instance for invoices
 var invoiceCollections *mongo.Collection = database.OpenCollection(database.Client, "Invoice")
 
+// What the invoice listing can be filtered and sorted by
+var invoiceListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "updated_at", "payment_due_date"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"order_id":         helpers.FilterString,
+		"customer_id":      helpers.FilterString,
+		"payment_method":   helpers.FilterString,
+		"payment_status":   helpers.FilterString,
+		"payment_due_date": helpers.FilterTime,
+		"created_at":       helpers.FilterTime,
+	},
+}
+
 // GetInvoice(), will get the details for all the records present in the database
 func GetInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		//initiating search
-		res, err := invoiceCollections.Find(context.TODO(), bson.M{})
-
-		defer cancel()
-		// handling error
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"Error": "Error retrieving invoice"})
-			return
-		}
-
-		// variable for returning all the records
-		var allInvoice []bson.M
-		if err = res.All(ctx, &allInvoice); err != nil {
-			log.Fatal(err)
-		}
-		c.JSON(http.StatusOK, allInvoice)
+		listCollection(c, invoiceCollections, invoiceListSpec, bson.M{})
 	}
 }
 
@@ -66,11 +76,15 @@ func GetInvoicebyID() gin.HandlerFunc {
 		invoiceId := c.Param("invoice_id")
 
 		// initiating search
-		err := invoiceCollections.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
+		err := invoiceCollections.FindOne(ctx, withDeleted(c, bson.M{"invoice_id": invoiceId})).Decode(&invoice)
 
 		defer cancel()
 
 		// handling error
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"Error": "Failed retrieving invoice items"})
 			return
@@ -84,6 +98,7 @@ func GetInvoicebyID() gin.HandlerFunc {
 		}
 
 		invoiceView.Order_id = invoice.Order_id
+		invoiceView.Customer_id = invoice.Customer_id
 		invoiceView.Payment_due_date = invoice.Payment_due_date
 
 		invoiceView.Payment_method = "null"
@@ -93,10 +108,31 @@ func GetInvoicebyID() gin.HandlerFunc {
 
 		invoiceView.Invoice_Id = invoice.Invoice_id
 		invoiceView.Payment_status = *&invoice.Payment_status
-		invoiceView.Payment_due = allOrderItems[0]["payment_due"]
-		invoiceView.Table_number = allOrderItems[0]["table_number"]
-		invoiceView.Order_details = allOrderItems[0]["order_details"]
+		if len(allOrderItems) > 0 {
+			invoiceView.Payment_due = allOrderItems[0]["payment_due"]
+			invoiceView.Table_number = allOrderItems[0]["table_number"]
+			invoiceView.Order_details = allOrderItems[0]["order_details"]
+		}
 
+		// once computed, the total of the invoice is what is due
+		if invoice.Total != nil {
+			invoiceView.Payment_due = *invoice.Total
+		}
+		invoiceView.Subtotal = invoice.Subtotal
+		invoiceView.Discount = invoice.Discount
+		invoiceView.Service_charge = invoice.Service_charge
+		invoiceView.Delivery_fee = invoice.Delivery_fee
+		invoiceView.Tax = invoice.Tax
+		invoiceView.Tip = invoice.Tip
+		invoiceView.Total = invoice.Total
+		invoiceView.Discount_lines = invoice.Discount_lines
+		invoiceView.Gift_cards = invoice.Gift_cards
+		invoiceView.Gift_card_amount = invoice.Gift_card_amount
+		invoiceView.Manual_discount = invoice.Manual_discount
+		invoiceView.Paid_at = invoice.Paid_at
+		invoiceView.Business_day = invoice.Business_day
+
+		helpers.SetETag(c, invoice.Version)
 		c.JSON(http.StatusOK, invoiceView)
 	}
 }
@@ -117,13 +153,27 @@ func CreateInvoice() gin.HandlerFunc {
 
 		var order models.Order
 
-		err = orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id}).Decode(&order)
+		err = orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id, "deleted_at": nil}).Decode(&order)
 
 		defer cancel()
 
 		if err != nil {
 			msg := fmt.Sprintf("Not found")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+		if order.Merged_into != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "the order was merged into " + *order.Merged_into + ", which is invoiced instead"})
+			return
+		}
+
+		// the invoice goes to the customer of the order, unless told otherwise
+		if invoice.Customer_id == nil {
+			invoice.Customer_id = order.Customer_id
+		}
+		if err := ensureCustomer(ctx, invoice.Customer_id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
 		status := "PENDING"
@@ -137,15 +187,107 @@ func CreateInvoice() gin.HandlerFunc {
 
 		invoice.ID = primitive.NewObjectID()
 		invoice.Invoice_id = invoice.ID.Hex()
+		invoice.Version = 1
 		// validating structure before inserting
 		validationErr := validate.Struct(invoice)
 		if validationErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if *invoice.Payment_status == "REFUNDED" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only a paid invoice can be refunded"})
+			return
+		}
+
+		if invoice.Tip != nil {
+			if *invoice.Tip < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tip can't be negative"})
+				return
+			}
+		}
+		if invoice.Manual_discount != nil {
+			if msg := askManualDiscount(c, invoice.Manual_discount); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+		}
+
+		totals, err := computeInvoiceTotals(ctx, invoice)
+		if err != nil {
+			respondInvoiceError(c, err)
+			return
+		}
+		invoice.Subtotal, invoice.Discount, invoice.Tax, invoice.Tip, invoice.Total = &totals.Subtotal, &totals.Discount, &totals.Tax, &totals.Tip, &totals.Total
+		invoice.Service_charge, invoice.Delivery_fee = &totals.Service_charge, &totals.Delivery_fee
+		invoice.Discount_lines = totals.Lines
+
+		requestedDrawer := stringValue(invoice.Drawer_id)
+		invoice.Paid_at, invoice.Business_day, invoice.Drawer_id = nil, nil, nil
+		invoice.Refunded_at, invoice.Refund_day, invoice.Refund_drawer_id = nil, nil, nil
+		invoice.Gift_card_amount = nil
+		if *invoice.Payment_status == "PAID" {
+			if awaitsApproval(invoice.Manual_discount) {
+				c.JSON(http.StatusConflict, gin.H{"error": "the manual discount waits for a manager's approval"})
+				return
+			}
+			var giftCardAmount float64
+			invoice.Payment_method, invoice.Gift_cards, giftCardAmount, err = planPayment(ctx, invoice.Payment_method, invoice.Gift_cards, totals.Total)
+			if err != nil {
+				respondInvoiceError(c, err)
+				return
+			}
+			if invoice.Payment_method == nil || *invoice.Payment_method == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "payment_method is required to pay the invoice"})
+				return
+			}
+			invoice.Gift_card_amount = &giftCardAmount
+			day := businessDay(invoice.Created_at)
+			invoice.Paid_at, invoice.Business_day = &invoice.Created_at, &day
 		}
 
-		res, insertErr := invoiceCollections.InsertOne(ctx, invoice)
+		// an invoice charges every item of its order, so an order has one invoice to pay (a refunded or deleted one
+		// leaves room for another). Invoices are made on today's business day, which can't be closed meanwhile, and a
+		// paid invoice puts its cash in a drawer, takes its gift cards and loyalty points and uses its promotions up
+		// along with its creation. The loyalty subscriber books the points it earns once invoice.paid is published
+		var res *mongo.InsertOneResult
+		insertErr := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			var err error
+			if err = ensureDayOpen(ctx, businessDay(invoice.Created_at)); err != nil {
+				return err
+			}
+			if err = ensureOrderUninvoiced(ctx, invoice.Order_id, invoice.Invoice_id); err != nil {
+				return err
+			}
+			if *invoice.Payment_status == "PAID" {
+				if invoice.Drawer_id, err = cashDrawer(ctx, invoice.Payment_method, requestedDrawer); err != nil {
+					return err
+				}
+				if err := chargeGiftCards(ctx, c, invoice.Invoice_id, invoice.Gift_cards); err != nil {
+					return err
+				}
+				if err := redeemPromotions(ctx, invoice.Discount_lines); err != nil {
+					return err
+				}
+				if err := redeemLoyalty(ctx, c, invoice.Customer_id, invoice.Invoice_id, invoice.Discount_lines, invoice.Created_at); err != nil {
+					return err
+				}
+			}
+			if res, err = invoiceCollections.InsertOne(ctx, invoice); err != nil {
+				return err
+			}
+			if err := publishEvent(ctx, c, models.EventInvoiceCreated, "invoice", invoiceCollections, "invoice_id", invoice.Invoice_id); err != nil {
+				return err
+			}
+			if *invoice.Payment_status == "PAID" {
+				if err := publishEvent(ctx, c, models.EventInvoicePaid, "invoice", invoiceCollections, "invoice_id", invoice.Invoice_id); err != nil {
+					return err
+				}
+			}
+			return recordAudit(ctx, c, models.AuditCreate, "invoice", invoice.Invoice_id, nil, invoice)
+		})
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating new invoice"})
+			respondInvoiceError(c, insertErr)
+			return
 		}
 
 		defer cancel()
@@ -155,6 +297,8 @@ func CreateInvoice() gin.HandlerFunc {
 	}
 }
 
+// This function updates an invoice. A pending invoice follows its order (the totals are computed again),
+// it gets paid once, and a paid invoice can only be refunded. Payments and refunds in cash go through a drawer.
 func UpdateInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -171,45 +315,438 @@ func UpdateInvoice() gin.HandlerFunc {
 			return
 		}
 
-		filter := bson.M{"invoice_id": invoiceId}
+		var current models.Invoice
+		err = invoiceCollections.FindOne(ctx, bson.M{"invoice_id": invoiceId, "deleted_at": nil}).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "record not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating the record"})
+			return
+		}
+		currentStatus := "PENDING"
+		if current.Payment_status != nil {
+			currentStatus = *current.Payment_status
+		}
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 		var updateObj primitive.D
 
+		paymentMethod := current.Payment_method
 		if invoice.Payment_method != nil {
-			updateObj = append(updateObj, bson.E{"payment_method", invoice.Payment_method})
+			if err := validate.Var(*invoice.Payment_method, "eq=CARD|eq=CASH|eq=GIFT_CARD|eq="); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "payment_method must be CARD, CASH or GIFT_CARD"})
+				return
+			}
+			if currentStatus != "PENDING" && (paymentMethod == nil || *paymentMethod != *invoice.Payment_method) {
+				c.JSON(http.StatusConflict, gin.H{"error": "the payment method of a paid invoice can't be changed"})
+				return
+			}
+			paymentMethod = invoice.Payment_method
+		}
+
+		if invoice.Gift_cards != nil {
+			if currentStatus != "PENDING" {
+				c.JSON(http.StatusConflict, gin.H{"error": "the gift cards of a paid invoice can't be changed"})
+				return
+			}
+			if err := validate.Var(invoice.Gift_cards, "dive"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			current.Gift_cards = invoice.Gift_cards
+			updateObj = append(updateObj, bson.E{Key: "gift_cards", Value: invoice.Gift_cards})
+		}
+
+		if invoice.Tip != nil {
+			if *invoice.Tip < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tip can't be negative"})
+				return
+			}
+			if currentStatus != "PENDING" {
+				c.JSON(http.StatusConflict, gin.H{"error": "the tip of a paid invoice can't be changed"})
+				return
+			}
+			current.Tip = invoice.Tip
+		}
+
+		if invoice.Customer_id != nil {
+			if err := ensureCustomer(ctx, invoice.Customer_id); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if currentStatus != "PENDING" {
+				c.JSON(http.StatusConflict, gin.H{"error": "the customer of a paid invoice can't be changed"})
+				return
+			}
+			current.Customer_id = invoice.Customer_id
+			updateObj = append(updateObj, bson.E{Key: "customer_id", Value: invoice.Customer_id})
+		}
+
+		if invoice.Loyalty_points != nil {
+			if *invoice.Loyalty_points < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "loyalty_points can't be negative"})
+				return
+			}
+			if currentStatus != "PENDING" {
+				c.JSON(http.StatusConflict, gin.H{"error": "the loyalty points of a paid invoice can't be changed"})
+				return
+			}
+			current.Loyalty_points = invoice.Loyalty_points
+			updateObj = append(updateObj, bson.E{Key: "loyalty_points", Value: invoice.Loyalty_points})
+		}
+
+		if invoice.Coupon_codes != nil {
+			if currentStatus != "PENDING" {
+				c.JSON(http.StatusConflict, gin.H{"error": "the coupons of a paid invoice can't be changed"})
+				return
+			}
+			current.Coupon_codes = invoice.Coupon_codes
+			updateObj = append(updateObj, bson.E{Key: "coupon_codes", Value: invoice.Coupon_codes})
+		}
+
+		if invoice.Manual_discount != nil {
+			if currentStatus != "PENDING" {
+				c.JSON(http.StatusConflict, gin.H{"error": "the discount of a paid invoice can't be changed"})
+				return
+			}
+			if err := validate.Struct(*invoice.Manual_discount); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if msg := askManualDiscount(c, invoice.Manual_discount); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			current.Manual_discount = invoice.Manual_discount
+			updateObj = append(updateObj, bson.E{Key: "manual_discount", Value: invoice.Manual_discount})
 		}
 
+		newStatus := currentStatus
 		if invoice.Payment_status != nil {
-			updateObj = append(updateObj, bson.E{"payment_status", invoice.Payment_status})
+			if err := validate.Var(*invoice.Payment_status, "eq=PENDING|eq=PAID|eq=REFUNDED"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "payment_status must be PENDING, PAID or REFUNDED"})
+				return
+			}
+			newStatus = *invoice.Payment_status
 		}
 
-		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		updateObj = append(updateObj, bson.E{"updated_at", invoice.Updated_at})
+		// the totals follow the order until the invoice is paid, then they stay as they were
+		var totals invoiceTotals
+		if currentStatus == "PENDING" {
+			totals, err = computeInvoiceTotals(ctx, current)
+			if err != nil {
+				respondInvoiceError(c, err)
+				return
+			}
+			updateObj = append(updateObj, totals.fields()...)
+		}
 
-		upsert := true
+		switch {
+		case newStatus == currentStatus:
+		case currentStatus == "PENDING" && newStatus == "PAID":
+			if awaitsApproval(current.Manual_discount) {
+				c.JSON(http.StatusConflict, gin.H{"error": "the manual discount waits for a manager's approval"})
+				return
+			}
+			var giftCardAmount float64
+			paymentMethod, current.Gift_cards, giftCardAmount, err = planPayment(ctx, paymentMethod, current.Gift_cards, totals.Total)
+			if err != nil {
+				respondInvoiceError(c, err)
+				return
+			}
+			if paymentMethod == nil || *paymentMethod == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "payment_method is required to pay the invoice"})
+				return
+			}
+			updateObj = paymentUpdate(updateObj, current.Gift_cards, giftCardAmount, now)
+		case currentStatus == "PAID" && newStatus == "REFUNDED":
+			// a refund pays money out, like the drawers and the discounts it is for the managers
+			if c.GetString("role") != models.RoleManager {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can refund an invoice"})
+				return
+			}
+			updateObj = append(updateObj,
+				bson.E{Key: "payment_status", Value: newStatus},
+				bson.E{Key: "refunded_at", Value: now},
+				bson.E{Key: "refund_day", Value: businessDay(now)},
+			)
+		default:
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("an invoice can't go from %s to %s", currentStatus, newStatus)})
+			return
+		}
 
-		opts := options.UpdateOptions{
-			Upsert: &upsert,
+		// a payment method was given, or picked while paying
+		if paymentMethod != current.Payment_method {
+			updateObj = append(updateObj, bson.E{Key: "payment_method", Value: paymentMethod})
 		}
 
-		status := "PENDING"
+		invoice.Updated_at = now
+		updateObj = append(updateObj, bson.E{"updated_at", invoice.Updated_at})
 
-		if invoice.Payment_status == nil {
-			invoice.Payment_status = &status
+		// payments and refunds are taken on today's business day, which can't be closed meanwhile, with their cash going
+		// through a drawer. Paying the invoice takes the gift cards and the loyalty points, uses its promotions up and
+		// publishes invoice.paid, refunding it gives the gift cards and the uses of the promotions back and publishes
+		// invoice.refunded. The loyalty subscriber books the points earned and reverses them from these events.
+		var settle func(ctx context.Context) error
+		if currentStatus == "PENDING" && newStatus == "PAID" {
+			settle = func(ctx context.Context) error {
+				if err := takeOnDay(ctx, invoiceId, paymentMethod, stringValue(invoice.Drawer_id), "drawer_id", now); err != nil {
+					return err
+				}
+				if err := chargeGiftCards(ctx, c, invoiceId, current.Gift_cards); err != nil {
+					return err
+				}
+				if err := redeemPromotions(ctx, totals.Lines); err != nil {
+					return err
+				}
+				if err := redeemLoyalty(ctx, c, current.Customer_id, invoiceId, totals.Lines, now); err != nil {
+					return err
+				}
+				return publishEvent(ctx, c, models.EventInvoicePaid, "invoice", invoiceCollections, "invoice_id", invoiceId)
+			}
+		}
+		if currentStatus == "PAID" && newStatus == "REFUNDED" {
+			settle = func(ctx context.Context) error {
+				if err := takeOnDay(ctx, invoiceId, paymentMethod, stringValue(invoice.Refund_drawer_id), "refund_drawer_id", now); err != nil {
+					return err
+				}
+				if err := refundGiftCards(ctx, c, invoiceId, current.Gift_cards); err != nil {
+					return err
+				}
+				if err := releasePromotions(ctx, current.Discount_lines); err != nil {
+					return err
+				}
+				return publishEvent(ctx, c, models.EventInvoiceRefunded, "invoice", invoiceCollections, "invoice_id", invoiceId)
+			}
 		}
+		updateVersionedThen(ctx, c, invoiceCollections, "invoice", "invoice_id", invoiceId, updateObj, settle)
+	}
+}
 
-		res, err := invoiceCollections.UpdateOne(ctx,
-			filter,
-			bson.D{
-				{"$set", updateObj},
-			},
-			&opts,
-		)
+// This function checks the order has no pending or paid invoice but the one given, a validationError otherwise
+func ensureOrderUninvoiced(ctx context.Context, orderID string, invoiceID string) error {
+	count, err := invoiceCollections.CountDocuments(ctx, bson.M{
+		"order_id":       orderID,
+		"invoice_id":     bson.M{"$ne": invoiceID},
+		"payment_status": bson.M{"$in": bson.A{"PENDING", "PAID"}},
+		"deleted_at":     nil,
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return validationError{"the order already has an invoice, update it instead"}
+	}
+	return nil
+}
+
+// The drawer cash is taken in (or given back from), none when the payment is not in cash.
+// The drawer records when cash last went through it, so a drawer closed meanwhile makes the transaction conflict.
+func cashDrawer(ctx context.Context, paymentMethod *string, requested string) (*string, error) {
+	if paymentMethod == nil || *paymentMethod != "CASH" {
+		return nil, nil
+	}
+	drawerID, err := pickDrawer(ctx, &requested)
+	if err != nil {
+		return nil, err
+	}
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	res, err := drawerCollection.UpdateOne(ctx,
+		bson.M{"drawer_id": drawerID, "status": models.DrawerOpen},
+		bson.M{"$set": bson.M{"last_cash_at": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, validationError{"the drawer is not open"}
+	}
+	return &drawerID, nil
+}
+
+// This function takes a payment or a refund of an invoice on the business day of now, which can't be closed, and
+// records the drawer its cash went through under the field. The ctx is the one of the transaction changing the invoice.
+func takeOnDay(ctx context.Context, invoiceID string, paymentMethod *string, requestedDrawer string, drawerField string, now time.Time) error {
+	if err := ensureDayOpen(ctx, businessDay(now)); err != nil {
+		return err
+	}
+	drawerID, err := cashDrawer(ctx, paymentMethod, requestedDrawer)
+	if err != nil {
+		return err
+	}
+	_, err = invoiceCollections.UpdateOne(ctx, bson.M{"invoice_id": invoiceID}, bson.M{"$set": bson.M{drawerField: drawerID}})
+	return err
+}
+
+// Answers the errors met while computing, paying or refunding an invoice
+func respondInvoiceError(c *gin.Context, err error) {
+	var locked dayLockedError
+	var invalid validationError
+	switch {
+	case errors.As(err, &locked):
+		c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while saving the invoice"})
+	}
+}
+
+// This function checks a manual discount asked on an invoice and tells what is wrong, or "" when it is fine.
+// The discounts given by a manager are approved right away, the others wait for a manager.
+func askManualDiscount(c *gin.Context, discount *models.ManualDiscount) string {
+	if (discount.Amount == nil) == (discount.Percentage == nil) {
+		return "a manual discount needs either an amount or a percentage"
+	}
+	discount.Requested_by = c.GetString("uid")
+	discount.Approved_by, discount.Approved_at = nil, nil
+	if c.GetString("role") == models.RoleManager {
+		approvedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		approvedBy := c.GetString("uid")
+		discount.Approved_by, discount.Approved_at = &approvedBy, &approvedAt
+	}
+	return ""
+}
+
+func awaitsApproval(discount *models.ManualDiscount) bool {
+	return discount != nil && discount.Approved_by == nil
+}
 
+// This function approves the manual discount waiting on a pending invoice, for the managers
+func ApproveInvoiceDiscount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewManualDiscount(c, true)
+	}
+}
+
+// This function turns down the manual discount of a pending invoice, it is removed, for the managers
+func RejectInvoiceDiscount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewManualDiscount(c, false)
+	}
+}
+
+func reviewManualDiscount(c *gin.Context, approve bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	invoiceId := c.Param("invoice_id")
+
+	var invoice models.Invoice
+	err := invoiceCollections.FindOne(ctx, bson.M{"invoice_id": invoiceId, "deleted_at": nil}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "record not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating the record"})
+		return
+	}
+	if invoice.Payment_status == nil || *invoice.Payment_status != "PENDING" {
+		c.JSON(http.StatusConflict, gin.H{"error": "the invoice is already paid"})
+		return
+	}
+	if invoice.Manual_discount == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "the invoice has no manual discount"})
+		return
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	if approve {
+		approvedBy := c.GetString("uid")
+		invoice.Manual_discount.Approved_by, invoice.Manual_discount.Approved_at = &approvedBy, &now
+	} else {
+		invoice.Manual_discount = nil
+	}
+
+	totals, err := computeInvoiceTotals(ctx, invoice)
+	if err != nil {
+		respondInvoiceError(c, err)
+		return
+	}
+
+	updateObj := primitive.D{{Key: "manual_discount", Value: invoice.Manual_discount}}
+	updateObj = append(updateObj, totals.fields()...)
+	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+
+	updateVersioned(ctx, c, invoiceCollections, "invoice", "invoice_id", invoiceId, updateObj)
+}
+
+// This function adds the fields of a payment to the update of an invoice. The gift cards given in the same PATCH
+// are replaced by the planned tenders, as a $set can't name a field twice.
+func paymentUpdate(updateObj primitive.D, giftCards []models.GiftCardTender, giftCardAmount float64, now time.Time) primitive.D {
+	updateObj = setField(updateObj, "gift_cards", giftCards)
+	updateObj = setField(updateObj, "gift_card_amount", giftCardAmount)
+	updateObj = setField(updateObj, "payment_status", "PAID")
+	updateObj = setField(updateObj, "paid_at", now)
+	return setField(updateObj, "business_day", businessDay(now))
+}
+
+// This function sets a field of an update, replacing the value it already had there
+func setField(updateObj primitive.D, key string, value interface{}) primitive.D {
+	for i := range updateObj {
+		if updateObj[i].Key == key {
+			updateObj[i].Value = value
+			return updateObj
+		}
+	}
+	return append(updateObj, bson.E{Key: key, Value: value})
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func floatValue(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+// Paid (and refunded) invoices are kept for the books, only pending ones can be deleted
+var deletableInvoice = deletable{
+	collection:   invoiceCollections,
+	resourceType: "invoice",
+	idField:      "invoice_id",
+	references: func(ctx context.Context, id string) (string, error) {
+		count, err := invoiceCollections.CountDocuments(ctx, bson.M{"invoice_id": id, "payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}}})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invoie updation failed"})
-			return
+			return "", err
 		}
-		c.JSON(http.StatusOK, res)
+		if count > 0 {
+			return "the invoice is paid", nil
+		}
+		return "", nil
+	},
+	// the order may have got another invoice since
+	cascadeRestore: func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error {
+		var invoice models.Invoice
+		if err := invoiceCollections.FindOne(ctx, bson.M{"invoice_id": id}).Decode(&invoice); err != nil {
+			return err
+		}
+		err := ensureOrderUninvoiced(ctx, invoice.Order_id, id)
+		var invalid validationError
+		if errors.As(err, &invalid) {
+			return referencedError{invalid.msg}
+		}
+		return err
+	},
+}
+
+func DeleteInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableInvoice, c.Param("invoice_id"))
+	}
+}
+
+func RestoreInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableInvoice, c.Param("invoice_id"))
 	}
 }
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"restaurantms/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPaymentUpdateReplacesTheGiftCardsGivenInTheSamePatch(t *testing.T) {
+	code, given, planned := "CARD1234", 50.0, 20.0
+	transactionID := "tx1"
+	updateObj := primitive.D{
+		{Key: "gift_cards", Value: []models.GiftCardTender{{Code: &code, Amount: &given}}},
+		{Key: "subtotal", Value: 20.0},
+	}
+	tenders := []models.GiftCardTender{{Code: &code, Amount: &planned, Transaction_id: &transactionID}}
+	now := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	updateObj = paymentUpdate(updateObj, tenders, planned, now)
+
+	seen := map[string]int{}
+	for _, field := range updateObj {
+		seen[field.Key]++
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Errorf("%s is set %d times", key, count)
+		}
+	}
+	set := updateObj.Map()
+	got, ok := set["gift_cards"].([]models.GiftCardTender)
+	if !ok || len(got) != 1 || got[0].Transaction_id == nil || *got[0].Transaction_id != transactionID {
+		t.Errorf("gift_cards = %v, want the planned tenders", set["gift_cards"])
+	}
+	if set["payment_status"] != "PAID" || set["gift_card_amount"] != planned || set["paid_at"] != now {
+		t.Errorf("the payment fields are %v", set)
+	}
+	if set["subtotal"] != 20.0 {
+		t.Errorf("subtotal = %v, the other fields must stay", set["subtotal"])
+	}
+}
+
+func TestPaymentUpdateAddsTheGiftCardsWhenNoneWereGiven(t *testing.T) {
+	updateObj := paymentUpdate(primitive.D{}, []models.GiftCardTender{}, 0, time.Now())
+
+	if _, ok := updateObj.Map()["gift_cards"]; !ok {
+		t.Errorf("gift_cards is missing from %v", updateObj)
+	}
+	if len(updateObj) != 5 {
+		t.Errorf("the update has %d fields, want 5", len(updateObj))
+	}
+}
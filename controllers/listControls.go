@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"restaurantms/helpers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// This function answers a list request with the standard envelope (items, next_cursor, total)
+// The deleted records are left out, unless asked for with ?include_deleted=true
+func listCollection(c *gin.Context, collection *mongo.Collection, spec helpers.ListSpec, base bson.M) {
+	listCollectionMapped(c, collection, spec, base, nil)
+}
+
+// Same as listCollection, each item being changed by mapItem before it is sent
+func listCollectionMapped(c *gin.Context, collection *mongo.Collection, spec helpers.ListSpec, base bson.M, mapItem func(item bson.M)) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	page, err := helpers.ListCollection(ctx, c, collection, spec, withDeleted(c, base))
+	if errors.Is(err, helpers.ErrInvalidListQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error while listing the records"})
+		return
+	}
+	if mapItem != nil {
+		for _, item := range page.Items {
+			mapItem(item)
+		}
+	}
+	c.JSON(http.StatusOK, page)
+}
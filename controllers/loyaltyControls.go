@@ -0,0 +1,380 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"restaurantms/database"
+	"restaurantms/events"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The loyalty ledger is append only: nothing in the code updates or removes its entries
+var loyaltyCollection *mongo.Collection = database.OpenCollection(database.Client, "loyalty_ledger")
+
+// The points earned for each currency unit spent (LOYALTY_POINTS_PER_UNIT, 1 by default)
+var loyaltyPointsPerUnit float64 = readLoyaltySetting("LOYALTY_POINTS_PER_UNIT", 1)
+
+// What a point is worth when redeemed (LOYALTY_POINT_VALUE, 0.01 by default)
+var loyaltyPointValue float64 = readLoyaltySetting("LOYALTY_POINT_VALUE", 0.01)
+
+// The points expire when the customer hasn't earned any for this many days (LOYALTY_EXPIRY_DAYS, 365 by default)
+var loyaltyExpiryDays int = int(readLoyaltySetting("LOYALTY_EXPIRY_DAYS", 365))
+
+// The tiers, from the lowest, reached with the points earned over time. Their multiplier applies to the points earned.
+var loyaltyTiers = []models.LoyaltyTier{
+	{Name: "BRONZE", Min_points: 0, Multiplier: 1},
+	{Name: "SILVER", Min_points: 1000, Multiplier: 1.25},
+	{Name: "GOLD", Min_points: 5000, Multiplier: 1.5},
+}
+
+func readLoyaltySetting(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	setting, err := strconv.ParseFloat(value, 64)
+	if err != nil || setting <= 0 {
+		log.Fatal(name, " must be a positive number, got ", value)
+	}
+	return setting
+}
+
+// What the loyalty transactions can be filtered and sorted by
+var loyaltyListSpec = helpers.ListSpec{
+	SortFields:  []string{"sequence", "created_at"},
+	DefaultSort: "-sequence",
+	Filters: map[string]helpers.FilterType{
+		"kind":       helpers.FilterString,
+		"invoice_id": helpers.FilterString,
+		"created_at": helpers.FilterTime,
+	},
+}
+
+// The tier reached with the lifetime points, and the next one when there is one
+func loyaltyTier(lifetimePoints int64) (models.LoyaltyTier, *models.LoyaltyTier) {
+	tier := loyaltyTiers[0]
+	for i, candidate := range loyaltyTiers {
+		if lifetimePoints < candidate.Min_points {
+			return tier, &loyaltyTiers[i]
+		}
+		tier = candidate
+	}
+	return tier, nil
+}
+
+// The last entry of the customer's ledger, the zero entry when they have none yet
+func lastLoyaltyEntry(ctx context.Context, customerID string) (models.LoyaltyEntry, error) {
+	var last models.LoyaltyEntry
+	err := loyaltyCollection.FindOne(ctx, bson.M{"customer_id": customerID}, options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}})).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return models.LoyaltyEntry{Customer_id: customerID, Tier: loyaltyTiers[0].Name}, nil
+	}
+	return last, err
+}
+
+// When the points of the last entry expire, none when there is nothing to expire
+func loyaltyExpiry(last models.LoyaltyEntry) *time.Time {
+	if last.Balance <= 0 || last.Last_earned_at == nil {
+		return nil
+	}
+	expiresAt := last.Last_earned_at.AddDate(0, 0, loyaltyExpiryDays)
+	return &expiresAt
+}
+
+// The balance of the last entry at the moment, nothing once its points expired even if the expiry isn't written yet
+func loyaltyBalance(last models.LoyaltyEntry, at time.Time) int64 {
+	if expiresAt := loyaltyExpiry(last); expiresAt != nil && !at.Before(*expiresAt) {
+		return 0
+	}
+	return last.Balance
+}
+
+// This function appends an entry to the customer's ledger, after the expiry of their points if it is due.
+// Two entries written at once for the same customer can't both take the same sequence number (the index is unique),
+// so the balance snapshots can't go wrong. The ctx decides the transaction it belongs to.
+func appendLoyalty(ctx context.Context, actorID string, entry models.LoyaltyEntry) (models.LoyaltyEntry, error) {
+	last, err := expireLoyalty(ctx, actorID, entry.Customer_id, entry.Created_at)
+	if err != nil {
+		return entry, err
+	}
+	if entry.Kind == models.LoyaltyRedeem && last.Balance+entry.Points < 0 {
+		return entry, validationError{fmt.Sprintf("the customer only has %d points", last.Balance)}
+	}
+	// a reversal never takes back more than the balance, the earned points already redeemed stay spent
+	taken := entry.Points
+	if entry.Kind == models.LoyaltyReversal && last.Balance+entry.Points < 0 {
+		entry.Points = -last.Balance
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.Entry_id = entry.ID.Hex()
+	entry.Sequence = last.Sequence + 1
+	entry.Balance = last.Balance + entry.Points
+	entry.Lifetime_points = last.Lifetime_points
+	entry.Last_earned_at = last.Last_earned_at
+	if entry.Kind == models.LoyaltyEarn {
+		entry.Lifetime_points += entry.Points
+		entry.Last_earned_at = &entry.Created_at
+	}
+	// a reversal taking earned points back takes them off the lifetime points too
+	if entry.Kind == models.LoyaltyReversal && taken < 0 {
+		entry.Lifetime_points += taken
+	}
+	tier, _ := loyaltyTier(entry.Lifetime_points)
+	entry.Tier = tier.Name
+	entry.Created_by = actorID
+
+	_, err = loyaltyCollection.InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return entry, validationError{"the loyalty balance changed meanwhile, try again"}
+	}
+	return entry, err
+}
+
+// This function expires the points of a customer who hasn't earned any for too long, and gives their last entry.
+// It only runs before a new entry is written, the reads compute the expiry with loyaltyBalance.
+func expireLoyalty(ctx context.Context, actorID string, customerID string, at time.Time) (models.LoyaltyEntry, error) {
+	last, err := lastLoyaltyEntry(ctx, customerID)
+	if err != nil {
+		return last, err
+	}
+	expiresAt := loyaltyExpiry(last)
+	if expiresAt == nil || at.Before(*expiresAt) {
+		return last, nil
+	}
+
+	expired := models.LoyaltyEntry{
+		ID:              primitive.NewObjectID(),
+		Customer_id:     customerID,
+		Sequence:        last.Sequence + 1,
+		Kind:            models.LoyaltyExpire,
+		Points:          -last.Balance,
+		Balance:         0,
+		Lifetime_points: last.Lifetime_points,
+		Tier:            last.Tier,
+		Last_earned_at:  last.Last_earned_at,
+		Created_by:      actorID,
+		Created_at:      at,
+	}
+	expired.Entry_id = expired.ID.Hex()
+	_, err = loyaltyCollection.InsertOne(ctx, expired)
+	if mongo.IsDuplicateKeyError(err) {
+		return last, validationError{"the loyalty balance changed meanwhile, try again"}
+	}
+	return expired, err
+}
+
+// The discount line paying part of an invoice with points, the points have to be on the customer's balance.
+// Only the points needed are used when they are worth more than what is left to pay.
+func loyaltyLine(ctx context.Context, customerID *string, points int64, left float64) (models.DiscountLine, error) {
+	line := models.DiscountLine{Kind: models.DiscountLoyalty, Name: "Loyalty points"}
+	if customerID == nil {
+		return line, validationError{"only an invoice with a customer can be paid with loyalty points"}
+	}
+	last, err := lastLoyaltyEntry(ctx, *customerID)
+	if err != nil {
+		return line, err
+	}
+	balance := loyaltyBalance(last, time.Now())
+	if points > balance {
+		return line, validationError{fmt.Sprintf("the customer only has %d points", balance)}
+	}
+
+	if needed := int64(math.Ceil(Tofixed(left/loyaltyPointValue, 6))); points > needed {
+		points = needed
+	}
+	line.Points = &points
+	line.Amount = math.Min(float64(points)*loyaltyPointValue, left)
+	return line, nil
+}
+
+// This function books the points earned by the invoices paid and reverses the points of the invoices refunded, as the
+// loyalty subscriber of the event bus. The points of an invoice are only booked once, however often its event comes.
+func followInvoiceLoyalty(ctx context.Context, event events.Event) error {
+	if event.Type != models.EventInvoicePaid && event.Type != models.EventInvoiceRefunded {
+		return nil
+	}
+	var invoice models.Invoice
+	err := invoiceCollections.FindOne(ctx, bson.M{"invoice_id": event.Resource_id}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return unitOfWork.Do(ctx, func(ctx context.Context) error {
+		if event.Type == models.EventInvoiceRefunded {
+			return reverseLoyalty(ctx, event.Actor_id, invoice.Invoice_id, event.Occurred_at)
+		}
+		return earnLoyalty(ctx, event.Actor_id, invoice, event.Occurred_at)
+	})
+}
+
+// This function takes the points an invoice is paid with off the customer's balance. Like the gift cards, they go
+// with the payment, so two invoices can't spend the same points. The ctx decides the transaction it belongs to.
+func redeemLoyalty(ctx context.Context, c *gin.Context, customerID *string, invoiceID string, lines []models.DiscountLine, at time.Time) error {
+	if customerID == nil {
+		return nil
+	}
+	for _, line := range lines {
+		if line.Kind != models.DiscountLoyalty || line.Points == nil || *line.Points == 0 {
+			continue
+		}
+		if _, err := appendLoyalty(ctx, c.GetString("uid"), models.LoyaltyEntry{
+			Customer_id: *customerID,
+			Kind:        models.LoyaltyRedeem,
+			Points:      -*line.Points,
+			Invoice_id:  &invoiceID,
+			Created_at:  at,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function books the points a paid invoice earns on what was spent (before tax and tip), with the multiplier
+// of the customer's tier. The ctx decides the transaction it belongs to.
+func earnLoyalty(ctx context.Context, actorID string, invoice models.Invoice, at time.Time) error {
+	customerID, invoiceID := invoice.Customer_id, invoice.Invoice_id
+	if customerID == nil {
+		return nil
+	}
+	booked, err := loyaltyCollection.CountDocuments(ctx, bson.M{"invoice_id": invoiceID, "kind": models.LoyaltyEarn})
+	if err != nil || booked > 0 {
+		return err
+	}
+
+	last, err := lastLoyaltyEntry(ctx, *customerID)
+	if err != nil {
+		return err
+	}
+	tier, _ := loyaltyTier(last.Lifetime_points)
+	// the part paid with points doesn't earn any
+	spent := floatValue(invoice.Subtotal) - floatValue(invoice.Discount)
+	earned := int64(math.Floor(Tofixed(spent*loyaltyPointsPerUnit*tier.Multiplier, 6)))
+	if earned <= 0 {
+		return nil
+	}
+	_, err = appendLoyalty(ctx, actorID, models.LoyaltyEntry{
+		Customer_id: *customerID,
+		Kind:        models.LoyaltyEarn,
+		Points:      earned,
+		Invoice_id:  &invoiceID,
+		Created_at:  at,
+	})
+	return err
+}
+
+// This function reverses the points earned and redeemed with an invoice being refunded: the earned points are taken back
+// and the redeemed ones are given back. The ctx decides the transaction it belongs to.
+func reverseLoyalty(ctx context.Context, actorID string, invoiceID string, at time.Time) error {
+	res, err := loyaltyCollection.Find(ctx,
+		bson.M{"invoice_id": invoiceID, "kind": bson.M{"$in": bson.A{models.LoyaltyEarn, models.LoyaltyRedeem}}},
+		options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	var entries []models.LoyaltyEntry
+	if err = res.All(ctx, &entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		reversed, err := loyaltyCollection.CountDocuments(ctx, bson.M{"reverses_id": entry.Entry_id})
+		if err != nil {
+			return err
+		}
+		if reversed > 0 {
+			continue
+		}
+		_, err = appendLoyalty(ctx, actorID, models.LoyaltyEntry{
+			Customer_id: entry.Customer_id,
+			Kind:        models.LoyaltyReversal,
+			Points:      -entry.Points,
+			Invoice_id:  &invoiceID,
+			Reverses_id: &entry.Entry_id,
+			Created_at:  at,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function gives the loyalty balance of a customer, with their tier. Points which are due to expire count as
+// expired, the ledger entry is only written with the customer's next entry.
+func GetLoyaltyAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customerID := c.Param("customer_id")
+		count, err := customerCollection.CountDocuments(ctx, bson.M{"customer_id": customerID, "deleted_at": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the customer"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+
+		last, err := lastLoyaltyEntry(ctx, customerID)
+		if err != nil {
+			respondLoyaltyError(c, err)
+			return
+		}
+
+		balance := loyaltyBalance(last, time.Now())
+		tier, next := loyaltyTier(last.Lifetime_points)
+		account := models.LoyaltyAccount{
+			Customer_id:     customerID,
+			Balance:         balance,
+			Balance_value:   Tofixed(float64(balance)*loyaltyPointValue, 2),
+			Lifetime_points: last.Lifetime_points,
+			Tier:            tier,
+			Next_tier:       next,
+		}
+		if balance > 0 {
+			account.Expires_at = loyaltyExpiry(last)
+		}
+		if next != nil {
+			account.Points_to_next_tier = next.Min_points - last.Lifetime_points
+		}
+		c.JSON(http.StatusOK, account)
+	}
+}
+
+// This function lists the loyalty ledger of a customer, the latest entries first
+func GetLoyaltyTransactions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, loyaltyCollection, loyaltyListSpec, bson.M{"customer_id": c.Param("customer_id")})
+	}
+}
+
+func respondLoyaltyError(c *gin.Context, err error) {
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the loyalty balance"})
+	}
+}
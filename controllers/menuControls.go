@@ -3,9 +3,9 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"restaurantms/database"
+	"restaurantms/helpers"
 	"restaurantms/models"
 	"time"
 
@@ -13,41 +13,49 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var menuCollection *mongo.Collection = database.OpenCollection(database.Client, "menu")
 
+// What the menu listing can be filtered and sorted by
+var menuListSpec = helpers.ListSpec{
+	SortFields:  []string{"name", "category", "start_date", "created_at"},
+	DefaultSort: "name",
+	Filters: map[string]helpers.FilterType{
+		"name":       helpers.FilterString,
+		"category":   helpers.FilterString,
+		"start_date": helpers.FilterTime,
+		"end_date":   helpers.FilterTime,
+		"created_at": helpers.FilterTime,
+	},
+}
+
 func GetMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		res, err := menuCollection.Find(context.TODO(), bson.M{})
-		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while listing the items"})
-		}
-		var allMenus []bson.M
-		if err = res.All(ctx, &allMenus); err != nil {
-			log.Fatal("Error", err)
-		}
-		c.JSON(http.StatusOK, allMenus)
+		listCollection(c, menuCollection, menuListSpec, bson.M{})
 	}
 }
 
 func GetMenubyID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		menuID := c.Param("menu_id")
 		var menu models.Menu
 
-		err := foodCollection.FindOne(ctx, bson.M{"menu_id": menuID}).Decode(&menu) //converting into soemething that GOlang understands
+		err := menuCollection.FindOne(ctx, withDeleted(c, bson.M{"menu_id": menuID})).Decode(&menu) //converting into soemething that GOlang understands
 
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "menu not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"Error": "Something weird happenend while searching for your request",
 			})
+			return
 		}
-		defer cancel()
+		helpers.SetETag(c, menu.Version)
 		c.JSON(http.StatusOK, menu)
 	}
 }
@@ -74,8 +82,9 @@ func CreateMenu() gin.HandlerFunc {
 		menu.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.ID = primitive.NewObjectID()
 		menu.Menu_id = menu.ID.Hex()
+		menu.Version = 1
 
-		res, insertErr := menuCollection.InsertOne(ctx, menu)
+		res, insertErr := insertAudited(ctx, c, menuCollection, "menu", menu.Menu_id, menu)
 		if insertErr != nil {
 			msg := fmt.Sprintf("Couldn't create menu")
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -96,6 +105,7 @@ func inTimeSpan(start, end, check time.Time) bool {
 func UpdateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var menu models.Menu
 
@@ -104,55 +114,59 @@ func UpdateMenu() gin.HandlerFunc {
 			return
 		}
 		menuId := c.Param("menu_id")
-		filter := bson.M{"menu_id": menuId}
 
 		var updateObj primitive.D
 
 		if menu.Start_Date != nil && menu.End_Date != nil {
 			if !inTimeSpan(*menu.Start_Date, *menu.End_Date, time.Now()) {
 				msg := "Please Re-enter the time"
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-				defer cancel()
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 				return
 			}
-			updateObj = append(updateObj, bson.E{"Start_date", menu.Start_Date})
-			updateObj = append(updateObj, bson.E{"End_date", menu.End_Date})
-
-			if menu.Name != "" {
-				updateObj = append(updateObj, bson.E{"name", menu.Name})
-			}
-
-			if menu.Category != "" {
-				updateObj = append(updateObj, bson.E{"name", menu.Category})
-			}
-
-			menu.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			updateObj = append(updateObj, bson.E{"Updated_at", menu.Updated_at})
+			updateObj = append(updateObj, bson.E{Key: "start_date", Value: menu.Start_Date})
+			updateObj = append(updateObj, bson.E{Key: "end_date", Value: menu.End_Date})
+		}
 
-			upsert := true
+		if menu.Name != "" {
+			updateObj = append(updateObj, bson.E{"name", menu.Name})
+		}
 
-			opt := options.UpdateOptions{
-				Upsert: &upsert,
-			}
+		if menu.Category != "" {
+			updateObj = append(updateObj, bson.E{Key: "category", Value: menu.Category})
+		}
 
-			res, err := menuCollection.UpdateOne(
-				ctx,
-				filter,
-				bson.D{
-					{"$set", updateObj},
-				},
-				&opt,
-			)
-			if err != nil {
-				msg := "Updation failed"
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": msg})
-			}
+		menu.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: menu.Updated_at})
 
-			defer cancel()
-			c.JSON(http.StatusOK, res)
+		updateVersioned(ctx, c, menuCollection, "menu", "menu_id", menuId, updateObj)
+	}
+}
 
+// A menu can't be deleted while it still has foods, they have to be deleted or moved first
+var deletableMenu = deletable{
+	collection:   menuCollection,
+	resourceType: "menu",
+	idField:      "menu_id",
+	references: func(ctx context.Context, id string) (string, error) {
+		count, err := foodCollection.CountDocuments(ctx, bson.M{"menu_id": id, "deleted_at": nil})
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "the menu still has foods", nil
 		}
+		return "", nil
+	},
+}
+
+func DeleteMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableMenu, c.Param("menu_id"))
+	}
+}
 
+func RestoreMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableMenu, c.Param("menu_id"))
 	}
 }
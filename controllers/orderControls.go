@@ -2,45 +2,45 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"restaurantms/database"
+	"restaurantms/helpers"
 	"restaurantms/models"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Intializing a database instance
 var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
 
+// What the order listing can be filtered and sorted by
+var orderListSpec = helpers.ListSpec{
+	SortFields:  []string{"order_date", "promised_at", "created_at", "updated_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"order_type":  helpers.FilterString,
+		"table_id":    helpers.FilterString,
+		"customer_id": helpers.FilterString,
+		"driver_id":   helpers.FilterString,
+		"server_id":   helpers.FilterString,
+		"promised_at": helpers.FilterTime,
+		"order_date":  helpers.FilterTime,
+		"created_at":  helpers.FilterTime,
+		"updated_at":  helpers.FilterTime,
+	},
+}
+
 // This function initializes the database to get all the records
 func GetOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		res, err := orderCollection.Find(context.TODO(), bson.M{})
-
-		defer cancel()
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "occured while listing order"})
-		}
-
-		var allOrders []bson.M
-
-		if err = res.All(ctx, &allOrders); err != nil {
-			log.Fatal(err)
-		}
-
-		c.JSON(http.StatusOK, allOrders)
-
+		listCollection(c, orderCollection, orderListSpec, bson.M{})
 	}
 }
 
@@ -53,14 +53,20 @@ func GetOrderbyID() gin.HandlerFunc {
 
 		var order models.Order
 
-		err := foodCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+		err := orderCollection.FindOne(ctx, withDeleted(c, bson.M{"order_id": orderID})).Decode(&order)
 
 		defer cancel()
 
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while getting order"})
+			return
 		}
 
+		helpers.SetETag(c, order.Version)
 		c.JSON(http.StatusOK, order)
 
 	}
@@ -70,9 +76,9 @@ func GetOrderbyID() gin.HandlerFunc {
 func CreateOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var order models.Order
-		var tables models.Table
 
 		err := c.BindJSON(&order)
 		if err != nil {
@@ -86,13 +92,13 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
-		if order.Table_id != nil {
-			err := orderCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&tables)
-			defer cancel()
-
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while getting table"})
-			}
+		if err := ensureCustomer(ctx, order.Customer_id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkOrderType(ctx, &order); err != nil {
+			respondOrderTypeError(c, err)
+			return
 		}
 
 		order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -100,13 +106,28 @@ func CreateOrder() gin.HandlerFunc {
 
 		order.ID = primitive.NewObjectID()
 		order.Order_id = order.ID.Hex()
+		order.Version = 1
+		order.Driver_assigned_at = nil
+		status, source := models.OrderAccepted, models.OrderSourcePOS
+		order.Status, order.Source, order.External_id = &status, &source, nil
+		order.Status_changed_at = &order.Created_at
+		order.Server_id = serverOf(c)
+		if order.Driver_id != nil {
+			order.Driver_assigned_at = &order.Created_at
+		}
+
+		if err := ensureDayOpen(ctx, businessDay(order.Order_Date)); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 
-		res, insertErr := orderCollection.InsertOne(ctx, order)
+		res, insertErr := insertAuditedThen(ctx, c, orderCollection, "order", order.Order_id, order, func(ctx context.Context) error {
+			return publishEvent(ctx, c, models.EventOrderCreated, "order", orderCollection, "order_id", order.Order_id)
+		})
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting order"})
 			return
 		}
-		defer cancel()
 		c.JSON(http.StatusOK, res)
 	}
 }
@@ -115,9 +136,9 @@ func CreateOrder() gin.HandlerFunc {
 func UpdateOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var order models.Order
-		var table models.Table
 
 		var updateObj primitive.D
 
@@ -129,57 +150,379 @@ func UpdateOrder() gin.HandlerFunc {
 			return
 		}
 
+		// the table is checked along with the type of the order, and only an open order of an open day moves like with
+		// TransferOrder. The table the order leaves hears of it through order.transferred
+		var current models.Order
+		if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId, "deleted_at": nil}).Decode(&current); err != nil && err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the order"})
+			return
+		}
 		if order.Table_id != nil {
-			err := orderCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
-			defer cancel()
-			if err != nil {
-				msg := fmt.Sprintf("message: order not found")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			updateObj = append(updateObj, bson.E{Key: "table_id", Value: order.Table_id})
+		}
+
+		if order.Guests != nil {
+			if err := validate.Var(*order.Guests, "min=1"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "guests must be at least 1"})
 				return
 			}
-			updateObj = append(updateObj, bson.E{"order", order.Table_id})
+			updateObj = append(updateObj, bson.E{Key: "guests", Value: order.Guests})
+		}
+
+		if order.Customer_id != nil {
+			if err := ensureCustomer(ctx, order.Customer_id); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "customer_id", Value: order.Customer_id})
 		}
 
 		order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		typeFields, err := changeOrderType(ctx, orderId, order, order.Updated_at)
+		if err != nil {
+			respondOrderTypeError(c, err)
+			return
+		}
+		updateObj = append(updateObj, typeFields...)
+
 		updateObj = append(updateObj, bson.E{"updated_at", order.Updated_at})
 
-		upsert := true
+		updateVersionedThen(ctx, c, orderCollection, "order", "order_id", orderId, updateObj, func(ctx context.Context) error {
+			if order.Table_id == nil || stringValue(order.Table_id) == stringValue(current.Table_id) {
+				return nil
+			}
+			if _, err := openOrder(ctx, orderId); err != nil {
+				return err
+			}
+			return publishEventWith(ctx, c, models.EventOrderTransferred, "order", orderCollection, "order_id", orderId, bson.M{"from_table_id": current.Table_id})
+		})
+	}
+}
+
+// This function checks an order against the rules of its type, an order without a type is dine-in. A dine-in order
+// needs a table and its guests, the others need the name they are for (taken from their customer when they have one)
+// and no table, and only a delivery has an address, a fee and a driver. A broken rule is a validationError.
+func checkOrderType(ctx context.Context, order *models.Order) error {
+	if order.Order_type == nil {
+		orderType := models.OrderDineIn
+		order.Order_type = &orderType
+	}
+	orderType := *order.Order_type
+
+	if orderType == models.OrderDineIn {
+		if order.Table_id == nil {
+			return validationError{"a dine-in order needs a table_id"}
+		}
+		if order.Guests == nil {
+			return validationError{"a dine-in order needs its guests"}
+		}
+		count, err := tablesCollection.CountDocuments(ctx, bson.M{"table_id": *order.Table_id, "deleted_at": nil})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return validationError{"table not found"}
+		}
+	} else {
+		if order.Table_id != nil {
+			return validationError{"only dine-in orders have a table_id"}
+		}
+		if order.Customer_id != nil && (order.Customer_name == nil || order.Customer_phone == nil) {
+			var customer models.Customer
+			err := customerCollection.FindOne(ctx, bson.M{"customer_id": *order.Customer_id, "deleted_at": nil}).Decode(&customer)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return err
+			}
+			if order.Customer_name == nil {
+				order.Customer_name = customer.Name
+			}
+			if order.Customer_phone == nil {
+				order.Customer_phone = customer.Phone
+			}
+		}
+		if order.Customer_name == nil {
+			return validationError{fmt.Sprintf("a %s order needs a customer_name", strings.ToLower(orderType))}
+		}
+		if order.Promised_at != nil && order.Promised_at.Before(order.Order_Date) {
+			return validationError{"promised_at can't be before the order date"}
+		}
+	}
 
-		filter := bson.M{"order_id": orderId}
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+	if orderType != models.OrderDelivery {
+		if order.Delivery_address != nil || order.Delivery_fee != nil || order.Driver_id != nil {
+			return validationError{"only delivery orders have a delivery_address, a delivery_fee or a driver_id"}
 		}
+		return nil
+	}
+	if order.Delivery_address == nil {
+		return validationError{"a delivery order needs a delivery_address"}
+	}
+	if order.Customer_phone == nil {
+		return validationError{"a delivery order needs a customer_phone"}
+	}
+	if order.Driver_id != nil {
+		count, err := usersCollection.CountDocuments(ctx, bson.M{"user_id": *order.Driver_id, "role": models.RoleDriver, "deleted_at": nil})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return validationError{"driver_id must be a user with the DRIVER role"}
+		}
+	}
+	return nil
+}
 
-		res, err := orderCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{"$set", updateObj},
-			},
-			&opt,
-		)
+// The type of an order, the orders from before the order types are dine-in
+func orderTypeOf(order models.Order) string {
+	if order.Order_type == nil {
+		return models.OrderDineIn
+	}
+	return *order.Order_type
+}
 
+// This function applies the changes of a patch to the type of an order and checks the order they give.
+// Changing the type drops the fields of the former one, and assigning a driver stamps when it happened.
+// It gives the fields to update.
+func changeOrderType(ctx context.Context, orderID string, changes models.Order, now time.Time) (primitive.D, error) {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID, "deleted_at": nil}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		// updateVersioned answers with the 404
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var updateObj primitive.D
+	set := func(key string, value interface{}) {
+		updateObj = append(updateObj, bson.E{Key: key, Value: value})
+	}
+
+	if changes.Order_type != nil && *changes.Order_type != stringValue(order.Order_type) {
+		if changes.Table_id == nil && order.Table_id != nil {
+			order.Table_id = nil
+			set("table_id", nil)
+		}
+		if *changes.Order_type != models.OrderDineIn && changes.Guests == nil && order.Guests != nil {
+			order.Guests = nil
+			set("guests", nil)
+		}
+		if *changes.Order_type != models.OrderDelivery {
+			order.Delivery_address, order.Delivery_fee, order.Driver_id, order.Driver_assigned_at = nil, nil, nil, nil
+			set("delivery_address", nil)
+			set("delivery_fee", nil)
+			set("driver_id", nil)
+			set("driver_assigned_at", nil)
+		}
+		order.Order_type = changes.Order_type
+	}
+	if changes.Table_id != nil {
+		order.Table_id = changes.Table_id
+	}
+	if changes.Guests != nil {
+		order.Guests = changes.Guests
+	}
+	if changes.Customer_id != nil {
+		order.Customer_id = changes.Customer_id
+	}
+	if changes.Customer_name != nil {
+		order.Customer_name = changes.Customer_name
+	}
+	if changes.Customer_phone != nil {
+		order.Customer_phone = changes.Customer_phone
+	}
+	if changes.Promised_at != nil {
+		order.Promised_at = changes.Promised_at
+		set("promised_at", changes.Promised_at)
+	}
+	if changes.Delivery_address != nil {
+		order.Delivery_address = changes.Delivery_address
+		set("delivery_address", changes.Delivery_address)
+	}
+	if changes.Delivery_fee != nil {
+		order.Delivery_fee = changes.Delivery_fee
+		set("delivery_fee", changes.Delivery_fee)
+	}
+	if changes.Driver_id != nil && *changes.Driver_id != stringValue(order.Driver_id) {
+		order.Driver_id = changes.Driver_id
+		order.Driver_assigned_at = &now
+		set("driver_id", changes.Driver_id)
+		set("driver_assigned_at", now)
+	}
+
+	if err := validate.Struct(order); err != nil {
+		return nil, validationError{err.Error()}
+	}
+	if err := checkOrderType(ctx, &order); err != nil {
+		return nil, err
+	}
+	// the type, and who the order is for which can come from its customer
+	set("order_type", order.Order_type)
+	set("customer_name", order.Customer_name)
+	set("customer_phone", order.Customer_phone)
+	return updateObj, nil
+}
+
+func respondOrderTypeError(c *gin.Context, err error) {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.msg})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the order"})
+}
+
+// The statuses an order can go to from each status
+var orderTransitions = map[string][]string{
+	models.OrderPlaced:   {models.OrderAccepted, models.OrderCancelled},
+	models.OrderAccepted: {models.OrderReady, models.OrderCancelled},
+	models.OrderReady:    {models.OrderPickedUp},
+}
+
+// This function moves an order to the status given as {"status": "READY"}, with its version in If-Match.
+// The platform of an order coming from a delivery platform is told the new status through the event bus.
+func UpdateOrderStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var body struct {
+			Status *string `json:"status" validate:"required,eq=ACCEPTED|eq=READY|eq=PICKED_UP|eq=CANCELLED"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be ACCEPTED, READY, PICKED_UP or CANCELLED"})
+			return
+		}
+
+		var order models.Order
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID, "deleted_at": nil}).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
 		if err != nil {
-			msg := fmt.Sprintf("Error while updating order")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the order"})
 			return
 		}
 
-		defer cancel()
-		c.JSON(http.StatusOK, res)
+		// the orders from before the statuses were taken here
+		current := models.OrderAccepted
+		if order.Status != nil {
+			current = *order.Status
+		}
+		status := *body.Status
+		if !containsString(orderTransitions[current], status) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("an order can't go from %s to %s", current, status)})
+			return
+		}
+		if status == models.OrderPickedUp && orderTypeOf(order) == models.OrderDineIn {
+			c.JSON(http.StatusConflict, gin.H{"error": "a dine-in order is not picked up"})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "status", Value: status},
+			{Key: "status_changed_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersionedThen(ctx, c, orderCollection, "order", "order_id", orderID, updateObj, func(ctx context.Context) error {
+			if err := queueDeliveryCallback(ctx, order, status, now); err != nil {
+				return err
+			}
+			return publishEvent(ctx, c, models.EventOrderStatusChanged, "order", orderCollection, "order_id", orderID)
+		})
 	}
 }
 
-func OrderItemsOrderCreator(order models.Order) string {
+// This function inserts the order created along with its items, the ctx decides the transaction it belongs to
+func OrderItemsOrderCreator(ctx context.Context, c *gin.Context, order models.Order) (string, error) {
 
 	order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 	order.ID = primitive.NewObjectID()
 	order.Order_id = order.ID.Hex()
+	order.Version = 1
+
+	// the orders of the delivery platforms come with their own status and source
+	if order.Status == nil {
+		status := models.OrderAccepted
+		order.Status = &status
+	}
+	if order.Source == nil {
+		source := models.OrderSourcePOS
+		order.Source = &source
+	}
+	// the orders taken here belong to who took them, unless they already have a server
+	if *order.Source == models.OrderSourcePOS && order.Server_id == nil {
+		order.Server_id = serverOf(c)
+	}
+	order.Status_changed_at = &order.Created_at
+
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		return "", err
+	}
+	database.OnRollback(ctx, func(ctx context.Context) error {
+		_, err := orderCollection.DeleteOne(ctx, bson.M{"_id": order.ID})
+		return err
+	})
+	if err := recordAudit(ctx, c, models.AuditCreate, "order", order.Order_id, nil, order); err != nil {
+		return "", err
+	}
+	if err := publishEvent(ctx, c, models.EventOrderCreated, "order", orderCollection, "order_id", order.Order_id); err != nil {
+		return "", err
+	}
 
-	ctx := context.Background()
-	orderCollection.InsertOne(ctx, order)
+	return order.Order_id, nil
+}
 
-	return order.Order_id
+// An order can't be deleted once it has been invoiced, its items go (and come back) with it
+var deletableOrder = deletable{
+	collection:   orderCollection,
+	resourceType: "order",
+	idField:      "order_id",
+	references: func(ctx context.Context, id string) (string, error) {
+		count, err := invoiceCollections.CountDocuments(ctx, bson.M{"order_id": id, "deleted_at": nil})
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "the order has an invoice", nil
+		}
+		return "", nil
+	},
+	cascadeDelete: func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error {
+		return updateEachAudited(ctx, c, orderItemsCollection, "order_item", "order_item_id", models.AuditDelete,
+			bson.M{"order_id": id, "deleted_at": nil},
+			softDelete(deletedAt, c.GetString("uid")),
+		)
+	},
+	cascadeRestore: func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error {
+		restoredAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		return updateEachAudited(ctx, c, orderItemsCollection, "order_item", "order_item_id", models.AuditRestore,
+			bson.M{"order_id": id, "deleted_at": deletedAt},
+			softRestore(restoredAt),
+		)
+	},
+}
+
+func DeleteOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableOrder, c.Param("order_id"))
+	}
+}
+
+func RestoreOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableOrder, c.Param("order_id"))
+	}
 }
@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"restaurantms/database"
+	"restaurantms/helpers"
 	"restaurantms/models"
 	"time"
 
@@ -12,37 +14,52 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type OrderItemPack struct {
-	Table_id    *string
-	Order_items []models.OrderItem
+	Order_type       *string
+	Table_id         *string
+	Customer_id      *string
+	Customer_name    *string
+	Customer_phone   *string
+	Promised_at      *time.Time
+	Delivery_address *models.DeliveryAddress
+	Delivery_fee     *float64
+	Guests           *int
+	Order_items      []models.OrderItem
 }
 
 var orderItemsCollection *mongo.Collection = database.OpenCollection(database.Client, "orderItem")
 
-// This function gets all the records
-func GetOrderItems() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+// Used for the writes which have to happen together, in a mongo transaction
+var unitOfWork database.UnitOfWork = database.NewUnitOfWork(database.Client)
 
-		res, err := orderCollection.Find(context.TODO(), bson.M{})
-
-		defer cancel()
+// Returned from inside a unit of work when the request itself is wrong, it rolls the work back and becomes a 400
+type validationError struct {
+	msg string
+}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Error encountered while fetching records"})
-			return
-		}
-		var allOrdersItems []bson.M
+func (e validationError) Error() string {
+	return e.msg
+}
 
-		if err = res.All(ctx, &allOrdersItems); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error encountered while parsing all orderItems"})
-		}
+// What the order item listing can be filtered and sorted by
+var orderItemListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "unit_price"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"order_id":   helpers.FilterString,
+		"food_id":    helpers.FilterString,
+		"quantity":   helpers.FilterString,
+		"unit_price": helpers.FilterFloat,
+		"created_at": helpers.FilterTime,
+	},
+}
 
-		c.JSON(http.StatusOK, allOrdersItems)
+// This function gets all the records
+func GetOrderItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, orderItemsCollection, orderItemListSpec, bson.M{})
 	}
 }
 
@@ -51,19 +68,25 @@ func GetOrderItemsbyID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
-		orderItemsID := c.Param("order_item_id")
+		orderItemsID := c.Param("orderitems_id")
 
 		var orderItems models.OrderItem
 
-		err := orderItemsCollection.FindOne(ctx, bson.M{"order_item_id": orderItemsID}).Decode(&orderItems)
+		err := orderItemsCollection.FindOne(ctx, withDeleted(c, bson.M{"order_item_id": orderItemsID})).Decode(&orderItems)
 
 		defer cancel()
 
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order item not found"})
+			return
+		}
 		if err != nil {
 			msg := fmt.Sprintf("Error while getting order")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
 		}
 
+		helpers.SetETag(c, orderItems.Version)
 		c.JSON(http.StatusOK, orderItems)
 	}
 }
@@ -88,31 +111,31 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 
 	// Here we will match the records based on the key provided
 	// This will give us all the records, related to that orderId
-	matchStage := bson.D{{"$match", bson.D{{"order_id", id}}}}
+	matchStage := bson.D{{"$match", bson.D{{"order_id", id}, {"deleted_at", nil}}}}
 
 	// The lookup function is used for looking up the data, from a particular collection, here we are looking into food, from orderItemsCollection and we are using the food_id, as the localfield. and the table from which we are looking is food collection. And "as" means how the data will be represented
-	lookupStage := bson.D{{"$lookup", bson.D{{"from", "food"}, {"localField", "food_id"}, {"foreignFeild", "food_id"}, {"as ", "food"}}}}
+	lookupStage := bson.D{{"$lookup", bson.D{{"from", "food"}, {"localField", "food_id"}, {"foreignField", "food_id"}, {"as", "food"}}}}
 	// When we lookup for data, we get that in form of array, now in mongo we can't perform any operation while that data is in array form, so we need to unwind it, or decode it.
-	unwindStage := bson.D{{"$unwind", bson.D{{"path", "$food"}, {"preserveNullandEmptyArrays", true}}}}
+	unwindStage := bson.D{{"$unwind", bson.D{{"path", "$food"}, {"preserveNullAndEmptyArrays", true}}}}
 	//If we set this as false, then it removes all the null and empty arrays.
 
 	// lookup for the orders
 	lookupOrderStage := bson.D{{"$lookup", bson.D{{"from", "order"}, {"localField", "order_id"}, {"foreignField", "order_id"}, {"as", "order"}}}}
-	unwindOrderStage := bson.D{{"$unwind", bson.D{{"path", "$order"}, {"preserveNullandEmptyArrays", true}}}}
+	unwindOrderStage := bson.D{{"$unwind", bson.D{{"path", "$order"}, {"preserveNullAndEmptyArrays", true}}}}
 
 	// Since already looked up in the orders collection, now we have the whole order object with us. And since we have unwinded it, we have the access to the field inside it.
 	lookUpTableStage := bson.D{{"$lookup", bson.D{{"from", "tables"}, {"localField", "order.table_id"}, {"foreignField", "table_id"}, {"as", "table"}}}}
-	unwindTableStage := bson.D{{"$unwind", bson.D{{"path", "$table"}, {"preserveNullandEmptyArrays", true}}}}
+	unwindTableStage := bson.D{{"$unwind", bson.D{{"path", "$table"}, {"preserveNullAndEmptyArrays", true}}}}
 
 	// This stage manages the field that we will be sending to the next stage. After all these stages there will be a lot of data, and we might not use them all, so we need to sort them out
 	projectStage := bson.D{
 		{"$project", bson.D{
-			{"id", 0}, // this means that ID is not going to the next stage
+			{"_id", 0}, // this means that ID is not going to the next stage
 			{"amount", "$food.price"},
 			{"total_count", 1},
 			{"food_name", "$food.name"},
 			{"food_image", "$food.food_image"},
-			{"table_number", "$table_number"},
+			{"table_number", "$table.table_number"},
 			{"table_id", "$table.table_id"},
 			{"order_id", "$order.order_id"},
 			{"price", "$food.price"},
@@ -124,10 +147,10 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 
 	projectStage2 := bson.D{
 		{"$project", bson.D{
-			{"id", 0},
+			{"_id", 0},
 			{"payment_due", 1},
 			{"total_count", 1},
-			{"table_number", "$id.table_number"},
+			{"table_number", "$_id.table_number"},
 			{"order_items", 1},
 		}}}
 
@@ -153,54 +176,116 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 	return OrderItems, err
 }
 
-// This function creates a new entity of the orderItemCollection
+// This function creates a new order along with all of its items.
+// Everything happens inside one transaction, if any item is invalid or can't be inserted, the order is not created either.
 func CreateOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var orderItemsPack OrderItemPack
 		var order models.Order
 
 		if err := c.BindJSON(&orderItemsPack); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while binding the data"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "error while binding the data"})
 			return
 		}
+		if len(orderItemsPack.Order_items) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one order item is required"})
+			return
+		}
+
 		// creating the order date with its, timestamp
 		order.Order_Date, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 		// we will be using the table id for creating our order
-		orderItemsTobeInserted := []interface{}{}
+		order.Order_type = orderItemsPack.Order_type
 		order.Table_id = orderItemsPack.Table_id
-		order_id := OrderItemsOrderCreator(order)
-
-		for _, orderItem := range orderItemsPack.Order_items {
-			orderItem.Order_id = order_id
-			validationErr := validate.Struct(orderItem)
+		order.Customer_id = orderItemsPack.Customer_id
+		order.Customer_name = orderItemsPack.Customer_name
+		order.Customer_phone = orderItemsPack.Customer_phone
+		order.Promised_at = orderItemsPack.Promised_at
+		order.Delivery_address = orderItemsPack.Delivery_address
+		order.Delivery_fee = orderItemsPack.Delivery_fee
+		order.Guests = orderItemsPack.Guests
+
+		var orderID string
+		var insertedOrders *mongo.InsertManyResult
+
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			if err := validate.Struct(order); err != nil {
+				return validationError{err.Error()}
+			}
+			if err := ensureDayOpen(ctx, businessDay(order.Order_Date)); err != nil {
+				return err
+			}
+			if err := ensureCustomer(ctx, order.Customer_id); err != nil {
+				return err
+			}
+			if err := checkOrderType(ctx, &order); err != nil {
+				return err
+			}
 
-			if validationErr != nil {
-				msg := fmt.Sprintf("Validation falied")
-				c.JSON(http.StatusBadRequest, gin.H{"Error": msg})
-				return
+			var err error
+			orderID, err = OrderItemsOrderCreator(ctx, c, order)
+			if err != nil {
+				return err
 			}
 
-			orderItem.ID = primitive.NewObjectID()
-			orderItem.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.Order_item_id = orderItem.ID.Hex()
-			var num = Tofixed(*orderItem.Unit_price, 2)
-			orderItem.Unit_price = &num
+			orderItemsTobeInserted := []interface{}{}
+			for i, orderItem := range orderItemsPack.Order_items {
+				orderItem.Order_id = orderID
+				if err := validate.Struct(orderItem); err != nil {
+					return validationError{fmt.Sprintf("order item %d: %s", i, err.Error())}
+				}
+				var food models.Food
+				if err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItem.Food_id, "deleted_at": nil}).Decode(&food); err != nil {
+					return validationError{fmt.Sprintf("order item %d: food not found", i)}
+				}
+
+				orderItem.ID = primitive.NewObjectID()
+				orderItem.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+				orderItem.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+				orderItem.Order_item_id = orderItem.ID.Hex()
+				orderItem.Version = 1
+				var num = Tofixed(*orderItem.Unit_price, 2)
+				orderItem.Unit_price = &num
+
+				orderItemsTobeInserted = append(orderItemsTobeInserted, orderItem)
+			}
 
-			orderItemsTobeInserted = append(orderItemsTobeInserted, orderItem)
+			insertedOrders, err = orderItemsCollection.InsertMany(ctx, orderItemsTobeInserted)
+			if err != nil {
+				return err
+			}
+			database.OnRollback(ctx, func(ctx context.Context) error {
+				_, err := orderItemsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": insertedOrders.InsertedIDs}})
+				return err
+			})
+			for _, orderItem := range orderItemsTobeInserted {
+				if err := recordAudit(ctx, c, models.AuditCreate, "order_item", orderItem.(models.OrderItem).Order_item_id, nil, orderItem); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 
+		var invalid validationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalid.msg})
+			return
+		}
+		var locked dayLockedError
+		if errors.As(err, &locked) {
+			c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+			return
 		}
-		insertedOrders, err := orderItemsCollection.InsertMany(ctx, orderItemsTobeInserted)
 		if err != nil {
-			msg := fmt.Sprintf("Error:Failed to insert records")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error:Failed to insert records, nothing was created"})
+			return
 		}
 
-		defer cancel()
-		c.JSON(http.StatusOK, insertedOrders)
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "InsertedIDs": insertedOrders.InsertedIDs})
 	}
 }
 
@@ -208,22 +293,37 @@ func CreateOrderItems() gin.HandlerFunc {
 func UpdateOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var orderItems models.OrderItem
-		orderItemsID := c.Param("order_item_id")
-		filter := bson.M{"order_item_id": orderItemsID}
+		orderItemsID := c.Param("orderitems_id")
+
+		if err := c.BindJSON(&orderItems); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		var updateObj primitive.D
 
 		if orderItems.Unit_price != nil {
-			updateObj = append(updateObj, bson.E{"unit_price", *&orderItems.Unit_price})
+			var num = Tofixed(*orderItems.Unit_price, 2)
+			updateObj = append(updateObj, bson.E{"unit_price", num})
 		}
 
 		if orderItems.Quantity != nil {
+			if err := validate.Var(*orderItems.Quantity, "eq=S|eq=M|eq=L"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be S, M or L"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{"quantity", *&orderItems.Quantity})
 		}
 
 		if orderItems.Food_id != nil {
+			var food models.Food
+			if err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItems.Food_id, "deleted_at": nil}).Decode(&food); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "food not found"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{"food_id", *&orderItems.Food_id})
 		}
 
@@ -231,26 +331,43 @@ func UpdateOrderItems() gin.HandlerFunc {
 
 		updateObj = append(updateObj, bson.E{"updated_at", orderItems.Updated_at})
 
-		defer cancel()
+		updateVersioned(ctx, c, orderItemsCollection, "order_item", "order_item_id", orderItemsID, updateObj)
+	}
+}
 
-		upsert := true
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+// An order item can't be deleted once its order is paid (or refunded)
+var deletableOrderItem = deletable{
+	collection:   orderItemsCollection,
+	resourceType: "order_item",
+	idField:      "order_item_id",
+	references: func(ctx context.Context, id string) (string, error) {
+		var orderItem models.OrderItem
+		err := orderItemsCollection.FindOne(ctx, bson.M{"order_item_id": id}).Decode(&orderItem)
+		if err == mongo.ErrNoDocuments {
+			return "", nil
 		}
-
-		res, err := orderItemsCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{"$set", updateObj},
-			},
-			&opt,
-		)
-
 		if err != nil {
-			msg := fmt.Sprintf("Error while updation")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return "", err
+		}
+		count, err := invoiceCollections.CountDocuments(ctx, bson.M{"order_id": orderItem.Order_id, "payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}}, "deleted_at": nil})
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "the order of this item is paid", nil
 		}
-		c.JSON(http.StatusOK, res)
+		return "", nil
+	},
+}
+
+func DeleteOrderItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableOrderItem, c.Param("orderitems_id"))
+	}
+}
+
+func RestoreOrderItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableOrderItem, c.Param("orderitems_id"))
 	}
 }
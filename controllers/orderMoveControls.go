@@ -0,0 +1,545 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// This function reads an order which can still change hands: not cancelled, merged or paid.
+// A missing order is mongo.ErrNoDocuments, a closed one a validationError.
+func openOrder(ctx context.Context, orderID string) (models.Order, error) {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID, "deleted_at": nil}).Decode(&order); err != nil {
+		return order, err
+	}
+	status := stringValue(order.Status)
+	if status == models.OrderCancelled || status == models.OrderMerged {
+		return order, validationError{fmt.Sprintf("the order %s is %s", orderID, status)}
+	}
+	open, err := openOrderIDs(ctx, []string{orderID})
+	if err != nil {
+		return order, err
+	}
+	if len(open) == 0 {
+		return order, validationError{fmt.Sprintf("the order %s is paid", orderID)}
+	}
+	if err := ensureDayOpen(ctx, businessDay(order.Order_Date)); err != nil {
+		return order, err
+	}
+	return order, nil
+}
+
+// This function computes again the pending invoice of an order whose items changed, the ctx decides the transaction
+func refreshPendingInvoice(ctx context.Context, c *gin.Context, orderID string, now time.Time) error {
+	var invoice models.Invoice
+	err := invoiceCollections.FindOne(ctx, bson.M{"order_id": orderID, "payment_status": "PENDING", "deleted_at": nil}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	totals, err := computeInvoiceTotals(ctx, invoice)
+	if err != nil {
+		return err
+	}
+	fields := append(totals.fields(), bson.E{Key: "updated_at", Value: now})
+	return updateEachAudited(ctx, c, invoiceCollections, "invoice", "invoice_id", models.AuditUpdate,
+		bson.M{"invoice_id": invoice.Invoice_id},
+		bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
+	)
+}
+
+// This function moves order items to another order, the ctx decides the transaction
+func moveOrderItems(ctx context.Context, c *gin.Context, fromOrderID string, itemIDs []string, toOrderID string, now time.Time) error {
+	filter := bson.M{"order_id": fromOrderID, "deleted_at": nil}
+	if itemIDs != nil {
+		filter["order_item_id"] = bson.M{"$in": itemIDs}
+	}
+	return updateEachAudited(ctx, c, orderItemsCollection, "order_item", "order_item_id", models.AuditUpdate, filter,
+		bson.M{"$set": bson.M{"order_id": toOrderID, "updated_at": now}, "$inc": bson.M{"version": 1}},
+	)
+}
+
+func respondOrderMoveError(c *gin.Context, err error) {
+	var locked dayLockedError
+	var invalid validationError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+	case errors.As(err, &locked):
+		c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while moving the order"})
+	}
+}
+
+// This function moves a dine-in order to the table given as {"table_id": "..."}, with its version in If-Match.
+// Its invoice stays with it, and both tables follow.
+func TransferOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var body struct {
+			Table_id *string `json:"table_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "table_id is required"})
+			return
+		}
+
+		order, err := openOrder(ctx, orderID)
+		if err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+		if orderTypeOf(order) != models.OrderDineIn {
+			c.JSON(http.StatusConflict, gin.H{"error": "only dine-in orders are at a table"})
+			return
+		}
+		if stringValue(order.Table_id) == *body.Table_id {
+			c.JSON(http.StatusConflict, gin.H{"error": "the order is already at this table"})
+			return
+		}
+		count, err := tablesCollection.CountDocuments(ctx, bson.M{"table_id": *body.Table_id, "deleted_at": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the table"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "table not found"})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "table_id", Value: *body.Table_id},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersionedThen(ctx, c, orderCollection, "order", "order_id", orderID, updateObj, func(ctx context.Context) error {
+			return publishEventWith(ctx, c, models.EventOrderTransferred, "order", orderCollection, "order_id", orderID, bson.M{"from_table_id": order.Table_id})
+		})
+	}
+}
+
+// This function joins the party of another table, given as {"from_table_id": "..."}, to this table.
+// The open orders of both tables become one order at this table: the oldest one at this table (or the oldest one
+// moved from the other table), which takes the items of the others. The others are MERGED into it, and their
+// pending invoices are deleted, while the invoice of the order left is computed again.
+func MergeTables() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		tableID := c.Param("table_id")
+
+		var body struct {
+			From_table_id *string `json:"from_table_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from_table_id is required"})
+			return
+		}
+		fromTableID := *body.From_table_id
+		if fromTableID == tableID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a table can't be merged with itself"})
+			return
+		}
+		count, err := tablesCollection.CountDocuments(ctx, bson.M{"table_id": bson.M{"$in": bson.A{tableID, fromTableID}}, "deleted_at": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the tables"})
+			return
+		}
+		if count < 2 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
+			return
+		}
+
+		var mergedID string
+		err = unitOfWork.Do(ctx, func(ctx context.Context) error {
+			now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+			orders := []models.Order{}
+			for _, id := range []string{tableID, fromTableID} {
+				open, err := tableOpenOrders(ctx, id)
+				if err != nil {
+					return err
+				}
+				res, err := orderCollection.Find(ctx, bson.M{"order_id": bson.M{"$in": open}}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+				if err != nil {
+					return err
+				}
+				var tableOrders []models.Order
+				if err = res.All(ctx, &tableOrders); err != nil {
+					return err
+				}
+				if id == fromTableID && len(tableOrders) == 0 {
+					return validationError{"the table has no open orders to merge"}
+				}
+				orders = append(orders, tableOrders...)
+			}
+			for _, order := range orders {
+				if err := ensureDayOpen(ctx, businessDay(order.Order_Date)); err != nil {
+					return err
+				}
+			}
+
+			into := orders[0]
+			mergedID = into.Order_id
+			if stringValue(into.Table_id) != tableID {
+				err := updateEachAudited(ctx, c, orderCollection, "order", "order_id", models.AuditUpdate,
+					bson.M{"order_id": into.Order_id},
+					bson.M{"$set": bson.M{"table_id": tableID, "updated_at": now}, "$inc": bson.M{"version": 1}},
+				)
+				if err != nil {
+					return err
+				}
+				if err := publishEventWith(ctx, c, models.EventOrderTransferred, "order", orderCollection, "order_id", into.Order_id, bson.M{"from_table_id": into.Table_id}); err != nil {
+					return err
+				}
+			}
+
+			for _, order := range orders[1:] {
+				if err := mergeOrderInto(ctx, c, order, into.Order_id, now); err != nil {
+					return err
+				}
+			}
+			return refreshPendingInvoice(ctx, c, into.Order_id, now)
+		})
+		if err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+
+		var merged models.Order
+		if err := orderCollection.FindOne(ctx, bson.M{"order_id": mergedID}).Decode(&merged); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the order"})
+			return
+		}
+		helpers.SetETag(c, merged.Version)
+		c.JSON(http.StatusOK, merged)
+	}
+}
+
+// This function gives the items and the guests of an order to another one and marks it MERGED, deleting its pending
+// invoice. The ctx decides the transaction.
+func mergeOrderInto(ctx context.Context, c *gin.Context, order models.Order, intoID string, now time.Time) error {
+	if err := moveOrderItems(ctx, c, order.Order_id, nil, intoID, now); err != nil {
+		return err
+	}
+	if order.Guests != nil {
+		var into models.Order
+		if err := orderCollection.FindOne(ctx, bson.M{"order_id": intoID}).Decode(&into); err != nil {
+			return err
+		}
+		guests := *order.Guests
+		if into.Guests != nil {
+			guests += *into.Guests
+		}
+		err := updateEachAudited(ctx, c, orderCollection, "order", "order_id", models.AuditUpdate,
+			bson.M{"order_id": intoID},
+			bson.M{"$set": bson.M{"guests": guests, "updated_at": now}, "$inc": bson.M{"version": 1}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	err := updateEachAudited(ctx, c, orderCollection, "order", "order_id", models.AuditUpdate,
+		bson.M{"order_id": order.Order_id},
+		bson.M{"$set": bson.M{
+			"status":            models.OrderMerged,
+			"status_changed_at": now,
+			"merged_into":       intoID,
+			"updated_at":        now,
+		}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	err = updateEachAudited(ctx, c, invoiceCollections, "invoice", "invoice_id", models.AuditDelete,
+		bson.M{"order_id": order.Order_id, "payment_status": "PENDING", "deleted_at": nil},
+		softDelete(now, c.GetString("uid")),
+	)
+	if err != nil {
+		return err
+	}
+	return publishEvent(ctx, c, models.EventOrderMerged, "order", orderCollection, "order_id", order.Order_id)
+}
+
+// This function moves the items given as {"order_item_ids": [...], "to_order_id": "..."} from this order to another
+// open order. Without a to_order_id they go to a new order of the same kind, at the same table, to split the check.
+// The pending invoices of both orders are computed again.
+func SplitOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var body struct {
+			Order_item_ids []string `json:"order_item_ids" validate:"required,min=1"`
+			To_order_id    *string  `json:"to_order_id"`
+			Guests         *int     `json:"guests" validate:"omitempty,min=1"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order_item_ids needs at least one order item and guests must be at least 1"})
+			return
+		}
+		if body.To_order_id != nil && body.Guests != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "guests only goes with a new order"})
+			return
+		}
+		if body.To_order_id != nil && *body.To_order_id == orderID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the items are already in this order"})
+			return
+		}
+
+		var toOrderID string
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+			from, err := openOrder(ctx, orderID)
+			if err != nil {
+				return err
+			}
+			if from.External_id != nil {
+				return validationError{"the orders of the delivery platforms can't be split"}
+			}
+			count, err := orderItemsCollection.CountDocuments(ctx, bson.M{
+				"order_item_id": bson.M{"$in": body.Order_item_ids},
+				"order_id":      orderID,
+				"deleted_at":    nil,
+			})
+			if err != nil {
+				return err
+			}
+			if int(count) != len(body.Order_item_ids) {
+				return validationError{"some of the order items are not in this order"}
+			}
+
+			if body.To_order_id != nil {
+				to, err := openOrder(ctx, *body.To_order_id)
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return validationError{"the order to move the items to is not found"}
+				}
+				if err != nil {
+					return err
+				}
+				if to.External_id != nil {
+					return validationError{"the items can't be moved to an order of a delivery platform"}
+				}
+				toOrderID = to.Order_id
+			} else {
+				// the new order is for the same party as the one it is split from
+				split := models.Order{
+					Order_Date:       now,
+					Order_type:       from.Order_type,
+					Table_id:         from.Table_id,
+					Customer_id:      from.Customer_id,
+					Customer_name:    from.Customer_name,
+					Customer_phone:   from.Customer_phone,
+					Promised_at:      from.Promised_at,
+					Delivery_address: from.Delivery_address,
+					Server_id:        from.Server_id,
+				}
+				if err := ensureDayOpen(ctx, businessDay(now)); err != nil {
+					return err
+				}
+				if from.Guests != nil {
+					if split.Guests, err = splitGuests(ctx, c, from, body.Guests, now); err != nil {
+						return err
+					}
+				}
+				if toOrderID, err = OrderItemsOrderCreator(ctx, c, split); err != nil {
+					return err
+				}
+			}
+
+			if err := moveOrderItems(ctx, c, orderID, body.Order_item_ids, toOrderID, now); err != nil {
+				return err
+			}
+			if err := refreshPendingInvoice(ctx, c, orderID, now); err != nil {
+				return err
+			}
+			if err := refreshPendingInvoice(ctx, c, toOrderID, now); err != nil {
+				return err
+			}
+			return publishEventWith(ctx, c, models.EventOrderSplit, "order", orderCollection, "order_id", orderID, bson.M{"to_order_id": toOrderID})
+		})
+		if err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "to_order_id": toOrderID, "order_item_ids": body.Order_item_ids})
+	}
+}
+
+// This function takes the guests of a new order split from another one (one by default) from the guests of that
+// order, which keeps one at least. It gives the guests of the new order.
+func splitGuests(ctx context.Context, c *gin.Context, from models.Order, guests *int, now time.Time) (*int, error) {
+	moved := 1
+	if guests != nil {
+		moved = *guests
+	}
+	if moved > *from.Guests {
+		return nil, validationError{fmt.Sprintf("the order only has %d guests", *from.Guests)}
+	}
+	left := *from.Guests - moved
+	if left < 1 {
+		left = 1
+	}
+	err := updateEachAudited(ctx, c, orderCollection, "order", "order_id", models.AuditUpdate,
+		bson.M{"order_id": from.Order_id},
+		bson.M{"$set": bson.M{"guests": left, "updated_at": now}, "$inc": bson.M{"version": 1}},
+	)
+	return &moved, err
+}
+
+// This function hands an open order over to another server, given as {"server_id": "..."}, with its version in If-Match.
+// Only the server of the order or a manager can hand it over.
+func TransferCheck() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var body struct {
+			Server_id *string `json:"server_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "server_id is required"})
+			return
+		}
+
+		order, err := openOrder(ctx, orderID)
+		if err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+		if c.GetString("role") != models.RoleManager && stringValue(order.Server_id) != c.GetString("uid") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the server of the order or a manager can hand it over"})
+			return
+		}
+		if stringValue(order.Server_id) == *body.Server_id {
+			c.JSON(http.StatusConflict, gin.H{"error": "the order already belongs to this server"})
+			return
+		}
+		if err := ensureServer(ctx, *body.Server_id); err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "server_id", Value: *body.Server_id},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersionedThen(ctx, c, orderCollection, "order", "order_id", orderID, updateObj, func(ctx context.Context) error {
+			return publishEventWith(ctx, c, models.EventOrderServerChanged, "order", orderCollection, "order_id", orderID, bson.M{"from_server_id": order.Server_id})
+		})
+	}
+}
+
+// This function hands every open order of a server over to another one given as {"to_server_id": "..."},
+// like at the end of a shift. It answers with the orders handed over.
+func TransferServerChecks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		serverID := c.Param("server_id")
+
+		var body struct {
+			To_server_id *string `json:"to_server_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to_server_id is required"})
+			return
+		}
+		if *body.To_server_id == serverID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the checks can't be handed over to their own server"})
+			return
+		}
+		if err := ensureServer(ctx, *body.To_server_id); err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+
+		var transferred []string
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+			orderIDs, err := distinctStrings(ctx, orderCollection, "order_id", bson.M{
+				"server_id":  serverID,
+				"status":     bson.M{"$nin": bson.A{models.OrderCancelled, models.OrderMerged}},
+				"deleted_at": nil,
+			})
+			if err != nil {
+				return err
+			}
+			if transferred, err = openOrderIDs(ctx, orderIDs); err != nil {
+				return err
+			}
+			for _, orderID := range transferred {
+				order, err := openOrder(ctx, orderID)
+				if err != nil {
+					return err
+				}
+				err = updateEachAudited(ctx, c, orderCollection, "order", "order_id", models.AuditUpdate,
+					bson.M{"order_id": orderID},
+					bson.M{"$set": bson.M{"server_id": *body.To_server_id, "updated_at": now}, "$inc": bson.M{"version": 1}},
+				)
+				if err != nil {
+					return err
+				}
+				if err := publishEventWith(ctx, c, models.EventOrderServerChanged, "order", orderCollection, "order_id", orderID, bson.M{"from_server_id": order.Server_id}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			respondOrderMoveError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"server_id": *body.To_server_id, "order_ids": transferred})
+	}
+}
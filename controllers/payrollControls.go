@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"restaurantms/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The overtime rules, in hours (0 turns a rule off): the hours worked in a business day over OVERTIME_DAILY_HOURS
+// (off by default) are overtime and the ones over DOUBLE_TIME_DAILY_HOURS (off by default) double time, then the
+// regular hours of a work week over OVERTIME_WEEKLY_HOURS (40 by default) are overtime too
+var (
+	overtimeDailyHours   float64 = readHours("OVERTIME_DAILY_HOURS", 0)
+	doubleTimeDailyHours float64 = readHours("DOUBLE_TIME_DAILY_HOURS", 0)
+	overtimeWeeklyHours  float64 = readHours("OVERTIME_WEEKLY_HOURS", 40)
+)
+
+// The pay periods last PAY_PERIOD_DAYS (14 by default, whole weeks) one after the other from PAY_PERIOD_START
+// (2026-01-05 by default, a Monday). The work weeks of the overtime start with them.
+var (
+	payPeriodDays  int       = readPayPeriodDays()
+	payPeriodStart time.Time = readPayPeriodStart()
+)
+
+// What the payroll export is made of, one row per user
+var payrollColumns = []string{"user_id", "name", "scheduled_hours", "worked_hours", "break_hours", "regular_hours", "overtime_hours", "double_time_hours", "variance_hours", "open_entries"}
+
+func readHours(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	hours, err := strconv.ParseFloat(value, 64)
+	if err != nil || hours < 0 {
+		log.Fatal(name, " must be a number of hours, got ", value)
+	}
+	return hours
+}
+
+func readPayPeriodDays() int {
+	value := os.Getenv("PAY_PERIOD_DAYS")
+	if value == "" {
+		return 14
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days <= 0 || days%7 != 0 {
+		log.Fatal("PAY_PERIOD_DAYS must be a number of whole weeks in days, like 14, got ", value)
+	}
+	return days
+}
+
+func readPayPeriodStart() time.Time {
+	value := os.Getenv("PAY_PERIOD_START")
+	if value == "" {
+		value = "2026-01-05"
+	}
+	start, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		log.Fatal("PAY_PERIOD_START must be a date like 2026-01-05, got ", value)
+	}
+	return start
+}
+
+// The business days from a day, for some days
+func daysFrom(day string, count int) []string {
+	start, _ := time.Parse("2006-01-02", day)
+	days := make([]string, count)
+	for i := range days {
+		days[i] = start.AddDate(0, 0, i).Format("2006-01-02")
+	}
+	return days
+}
+
+// The first business day of the pay period a business day belongs to
+func payPeriodOf(day string) (string, error) {
+	date, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return "", err
+	}
+	days := int(date.Sub(payPeriodStart).Hours() / 24)
+	offset := ((days % payPeriodDays) + payPeriodDays) % payPeriodDays
+	return date.AddDate(0, 0, -offset).Format("2006-01-02"), nil
+}
+
+// The hours of a user in a business day
+type laborDay struct {
+	scheduled float64
+	worked    float64
+	breaks    float64
+	open      int
+}
+
+// This function gives the hours scheduled and worked by each user in each business day from the first day to the
+// day before the last one. The entries still open count until now.
+func laborHours(ctx context.Context, fromDay string, toDay string, now time.Time) (map[string]map[string]*laborDay, error) {
+	hours := map[string]map[string]*laborDay{}
+	dayOf := func(userID string, day string) *laborDay {
+		if hours[userID] == nil {
+			hours[userID] = map[string]*laborDay{}
+		}
+		if hours[userID][day] == nil {
+			hours[userID][day] = &laborDay{}
+		}
+		return hours[userID][day]
+	}
+	filter := bson.M{"business_day": bson.M{"$gte": fromDay, "$lt": toDay}, "deleted_at": nil}
+
+	res, err := scheduledShiftCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var shifts []models.ScheduledShift
+	if err = res.All(ctx, &shifts); err != nil {
+		return nil, err
+	}
+	for _, shift := range shifts {
+		dayOf(*shift.User_id, shift.Business_day).scheduled += shift.Ends_at.Sub(*shift.Starts_at).Hours()
+	}
+
+	res, err = timeEntryCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.TimeEntry
+	if err = res.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		day := dayOf(entry.User_id, entry.Business_day)
+		worked, breaks := entryHours(entry, now)
+		day.worked += worked
+		day.breaks += breaks
+		if entry.Clock_out == nil {
+			day.open++
+		}
+	}
+	return hours, nil
+}
+
+// The hours worked in some days split by how they are paid
+type payHours struct {
+	regular    float64
+	overtime   float64
+	doubleTime float64
+}
+
+// This function applies the overtime rules to the days worked by a user, the days being whole work weeks in order
+func splitOvertime(worked map[string]*laborDay, days []string) payHours {
+	var pay payHours
+	weekRegular := 0.0
+	for i, day := range days {
+		if i%7 == 0 {
+			weekRegular = 0
+		}
+		if worked[day] == nil {
+			continue
+		}
+		hours := worked[day].worked
+		doubleTime, overtime := 0.0, 0.0
+		if doubleTimeDailyHours > 0 && hours > doubleTimeDailyHours {
+			doubleTime = hours - doubleTimeDailyHours
+		}
+		if overtimeDailyHours > 0 && hours-doubleTime > overtimeDailyHours {
+			overtime = hours - doubleTime - overtimeDailyHours
+		}
+		regular := hours - doubleTime - overtime
+		if overtimeWeeklyHours > 0 && weekRegular+regular > overtimeWeeklyHours {
+			extra := weekRegular + regular - overtimeWeeklyHours
+			if extra > regular {
+				extra = regular
+			}
+			overtime += extra
+			regular -= extra
+		}
+		weekRegular += regular
+		pay.regular += regular
+		pay.overtime += overtime
+		pay.doubleTime += doubleTime
+	}
+	return pay
+}
+
+// The names of some users, by their user_id
+func userNames(ctx context.Context, userIDs []string) (map[string]string, error) {
+	res, err := usersCollection.Find(ctx, bson.M{"user_id": bson.M{"$in": userIDs}}, options.Find().SetProjection(bson.M{"user_id": 1, "first_name": 1, "last_name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err = res.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, user := range users {
+		names[user.User_id] = strings.TrimSpace(stringValue(user.First_name) + " " + stringValue(user.Last_name))
+	}
+	return names, nil
+}
+
+// This function gives the payroll of the pay period holding ?day=2026-01-31 (the current one by default): for every
+// user, the hours scheduled and worked, the unpaid breaks, the hours worked split into regular, overtime and double
+// time by the overtime rules, and how many of their entries are still open. With ?format=csv it is the export.
+func GetPayroll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		start, err := payPeriodOf(c.DefaultQuery("day", businessDay(time.Now())))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "day must be a date like 2026-01-31"})
+			return
+		}
+		days := daysFrom(start, payPeriodDays+1)
+		end := days[payPeriodDays]
+		days = days[:payPeriodDays]
+
+		hours, err := laborHours(ctx, start, end, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while computing the payroll"})
+			return
+		}
+		userIDs := []string{}
+		for userID := range hours {
+			userIDs = append(userIDs, userID)
+		}
+		names, err := userNames(ctx, userIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while computing the payroll"})
+			return
+		}
+
+		rows := []bson.M{}
+		for _, userID := range userIDs {
+			var total laborDay
+			for _, day := range hours[userID] {
+				total.scheduled += day.scheduled
+				total.worked += day.worked
+				total.breaks += day.breaks
+				total.open += day.open
+			}
+			pay := splitOvertime(hours[userID], days)
+			rows = append(rows, bson.M{
+				"user_id":           userID,
+				"name":              names[userID],
+				"scheduled_hours":   Tofixed(total.scheduled, 2),
+				"worked_hours":      Tofixed(total.worked, 2),
+				"break_hours":       Tofixed(total.breaks, 2),
+				"regular_hours":     Tofixed(pay.regular, 2),
+				"overtime_hours":    Tofixed(pay.overtime, 2),
+				"double_time_hours": Tofixed(pay.doubleTime, 2),
+				"variance_hours":    Tofixed(total.worked-total.scheduled, 2),
+				"open_entries":      total.open,
+			})
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i]["name"] != rows[j]["name"] {
+				return rows[i]["name"].(string) < rows[j]["name"].(string)
+			}
+			return rows[i]["user_id"].(string) < rows[j]["user_id"].(string)
+		})
+
+		if c.Query("format") == "csv" {
+			writeRowsCSV(c, "payroll_"+start, payrollColumns, rows)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"period_start": start,
+			"period_end":   days[len(days)-1],
+			"columns":      payrollColumns,
+			"rows":         rows,
+		})
+	}
+}
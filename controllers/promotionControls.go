@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var promotionCollection *mongo.Collection = database.OpenCollection(database.Client, "promotions")
+
+// What the promotion listing can be filtered and sorted by
+var promotionListSpec = helpers.ListSpec{
+	SortFields:  []string{"name", "starts_at", "created_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"name":        helpers.FilterString,
+		"kind":        helpers.FilterString,
+		"scope":       helpers.FilterString,
+		"coupon_code": helpers.FilterString,
+		"is_active":   helpers.FilterBool,
+		"starts_at":   helpers.FilterTime,
+		"ends_at":     helpers.FilterTime,
+		"created_at":  helpers.FilterTime,
+	},
+}
+
+func GetPromotions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, promotionCollection, promotionListSpec, bson.M{})
+	}
+}
+
+func GetPromotionbyID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var promotion models.Promotion
+		err := promotionCollection.FindOne(ctx, withDeleted(c, bson.M{"promotion_id": c.Param("promotion_id")})).Decode(&promotion)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "promotion not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the promotion"})
+			return
+		}
+		helpers.SetETag(c, promotion.Version)
+		c.JSON(http.StatusOK, promotion)
+	}
+}
+
+// This function creates a promotion, it applies to the invoices computed from now on
+func CreatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var promotion models.Promotion
+		if err := c.BindJSON(&promotion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(promotion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if promotion.Coupon_code != nil {
+			code := strings.ToUpper(*promotion.Coupon_code)
+			promotion.Coupon_code = &code
+		}
+		if msg := checkPromotion(promotion); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if msg, err := couponTaken(ctx, promotion.Coupon_code, ""); err != nil || msg != "" {
+			respondCouponTaken(c, msg, err)
+			return
+		}
+
+		promotion.ID = primitive.NewObjectID()
+		promotion.Promotion_id = promotion.ID.Hex()
+		promotion.Times_used = 0
+		promotion.Created_by = c.GetString("uid")
+		promotion.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		promotion.Updated_at = promotion.Created_at
+		promotion.Version = 1
+		promotion.Deleted_at, promotion.Deleted_by = nil, nil
+		if promotion.Is_active == nil {
+			active := true
+			promotion.Is_active = &active
+		}
+
+		res, err := insertAudited(ctx, c, promotionCollection, "promotion", promotion.Promotion_id, promotion)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the coupon code is already used by another promotion"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the promotion"})
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// This function updates a promotion. The invoices already computed keep their discount lines until computed again.
+func UpdatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		promotionID := c.Param("promotion_id")
+
+		var changes models.Promotion
+		if err := c.BindJSON(&changes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var promotion models.Promotion
+		err := promotionCollection.FindOne(ctx, bson.M{"promotion_id": promotionID, "deleted_at": nil}).Decode(&promotion)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "record not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating the record"})
+			return
+		}
+
+		var updateObj primitive.D
+		set := func(key string, value interface{}) {
+			updateObj = append(updateObj, bson.E{Key: key, Value: value})
+		}
+
+		if changes.Name != nil {
+			promotion.Name = changes.Name
+			set("name", changes.Name)
+		}
+		if changes.Kind != nil {
+			promotion.Kind = changes.Kind
+			set("kind", changes.Kind)
+		}
+		if changes.Value != nil {
+			promotion.Value = changes.Value
+			set("value", changes.Value)
+		}
+		if changes.Buy_quantity != nil {
+			promotion.Buy_quantity = changes.Buy_quantity
+			set("buy_quantity", changes.Buy_quantity)
+		}
+		if changes.Get_quantity != nil {
+			promotion.Get_quantity = changes.Get_quantity
+			set("get_quantity", changes.Get_quantity)
+		}
+		if changes.Scope != nil {
+			promotion.Scope = changes.Scope
+			set("scope", changes.Scope)
+		}
+		if changes.Food_ids != nil {
+			promotion.Food_ids = changes.Food_ids
+			set("food_ids", changes.Food_ids)
+		}
+		if changes.Menu_ids != nil {
+			promotion.Menu_ids = changes.Menu_ids
+			set("menu_ids", changes.Menu_ids)
+		}
+		if changes.Coupon_code != nil {
+			code := strings.ToUpper(*changes.Coupon_code)
+			promotion.Coupon_code = &code
+			set("coupon_code", code)
+		}
+		if changes.Usage_limit != nil {
+			promotion.Usage_limit = changes.Usage_limit
+			set("usage_limit", changes.Usage_limit)
+		}
+		if changes.Starts_at != nil {
+			promotion.Starts_at = changes.Starts_at
+			set("starts_at", changes.Starts_at)
+		}
+		if changes.Ends_at != nil {
+			promotion.Ends_at = changes.Ends_at
+			set("ends_at", changes.Ends_at)
+		}
+		if changes.Days != nil {
+			promotion.Days = changes.Days
+			set("days", changes.Days)
+		}
+		if changes.Start_time != nil {
+			promotion.Start_time = changes.Start_time
+			set("start_time", changes.Start_time)
+		}
+		if changes.End_time != nil {
+			promotion.End_time = changes.End_time
+			set("end_time", changes.End_time)
+		}
+		if changes.Is_active != nil {
+			promotion.Is_active = changes.Is_active
+			set("is_active", changes.Is_active)
+		}
+
+		if err := validate.Struct(promotion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg := checkPromotion(promotion); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if msg, err := couponTaken(ctx, changes.Coupon_code, promotionID); err != nil || msg != "" {
+			respondCouponTaken(c, msg, err)
+			return
+		}
+
+		updated, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		set("updated_at", updated)
+
+		updateVersioned(ctx, c, promotionCollection, "promotion", "promotion_id", promotionID, updateObj)
+	}
+}
+
+// The rules between the fields of a promotion, tells what is wrong or "" when it is fine
+func checkPromotion(promotion models.Promotion) string {
+	switch *promotion.Kind {
+	case models.PromotionPercentage:
+		if promotion.Value == nil || *promotion.Value > 100 {
+			return "a percentage promotion needs a value between 0 and 100"
+		}
+	case models.PromotionFixed:
+		if promotion.Value == nil {
+			return "a fixed promotion needs the amount it takes off as value"
+		}
+	case models.PromotionBuyXGetY:
+		if promotion.Buy_quantity == nil || promotion.Get_quantity == nil {
+			return "a buy X get Y promotion needs buy_quantity and get_quantity"
+		}
+		if promotion.Value != nil && *promotion.Value > 100 {
+			return "the value of a buy X get Y promotion is the percentage taken off, at most 100"
+		}
+	}
+	if *promotion.Scope == models.PromotionScopeFoods && len(promotion.Food_ids) == 0 {
+		return "a promotion on foods needs food_ids"
+	}
+	if *promotion.Scope == models.PromotionScopeMenus && len(promotion.Menu_ids) == 0 {
+		return "a promotion on menus needs menu_ids"
+	}
+	if promotion.Starts_at != nil && promotion.Ends_at != nil && !promotion.Ends_at.After(*promotion.Starts_at) {
+		return "ends_at must be after starts_at"
+	}
+	// a happy hour ending before it starts goes past midnight
+	if promotion.Start_time != nil && promotion.End_time != nil && *promotion.End_time == *promotion.Start_time {
+		return "end_time must differ from start_time"
+	}
+	return ""
+}
+
+// Tells if another promotion already has the coupon code
+func couponTaken(ctx context.Context, code *string, promotionID string) (string, error) {
+	if code == nil {
+		return "", nil
+	}
+	count, err := promotionCollection.CountDocuments(ctx, bson.M{
+		"coupon_code":  strings.ToUpper(*code),
+		"promotion_id": bson.M{"$ne": promotionID},
+	})
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "the coupon code is already used by another promotion", nil
+	}
+	return "", nil
+}
+
+func respondCouponTaken(c *gin.Context, msg string, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the coupon code"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": msg})
+}
+
+// The invoices keep their discount lines, so a promotion can always be deleted
+var deletablePromotion = deletable{
+	collection:   promotionCollection,
+	resourceType: "promotion",
+	idField:      "promotion_id",
+}
+
+func DeletePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletablePromotion, c.Param("promotion_id"))
+	}
+}
+
+func RestorePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletablePromotion, c.Param("promotion_id"))
+	}
+}
@@ -0,0 +1,572 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"restaurantms/models"
+	"sort"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The longest period a report can cover
+const maxReportDays = 366
+
+// The services of the day, by the local hour at which they end
+var services = []struct {
+	name  string
+	until int
+}{
+	{"BREAKFAST", 11},
+	{"LUNCH", 16},
+	{"DINNER", 24},
+}
+
+// The period a report covers: from the start of the first day to the end of the last one, in the time zone
+type reportRange struct {
+	From     time.Time
+	To       time.Time
+	Timezone string
+}
+
+// A report is an aggregation giving rows with the same columns, so it can be sent as JSON or as CSV
+type report struct {
+	name    string
+	columns []string
+	run     func(ctx context.Context, r reportRange) ([]bson.M, error)
+}
+
+// This function reads the period of a report: ?from=YYYY-MM-DD&to=YYYY-MM-DD&tz=Europe/Paris, both days included.
+// Without dates, the last 7 days are reported; without a time zone, the one of the restaurant is used.
+func parseReportRange(c *gin.Context) (reportRange, error) {
+	var r reportRange
+
+	r.Timezone = c.DefaultQuery("tz", businessTimezone)
+	location, err := time.LoadLocation(r.Timezone)
+	if err != nil || r.Timezone == "Local" {
+		return r, fmt.Errorf("unknown time zone %s", r.Timezone)
+	}
+
+	now := time.Now().In(location)
+	lastDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	if value := c.Query("to"); value != "" {
+		if lastDay, err = time.ParseInLocation("2006-01-02", value, location); err != nil {
+			return r, fmt.Errorf("to must be a date like 2026-01-31")
+		}
+	}
+	firstDay := lastDay.AddDate(0, 0, -6)
+	if value := c.Query("from"); value != "" {
+		if firstDay, err = time.ParseInLocation("2006-01-02", value, location); err != nil {
+			return r, fmt.Errorf("from must be a date like 2026-01-01")
+		}
+	}
+
+	if lastDay.Before(firstDay) {
+		return r, fmt.Errorf("from must not be after to")
+	}
+	r.From = firstDay
+	r.To = lastDay.AddDate(0, 0, 1)
+	if r.To.Sub(r.From) > maxReportDays*24*time.Hour+time.Hour {
+		return r, fmt.Errorf("a report covers at most %d days", maxReportDays)
+	}
+	return r, nil
+}
+
+// This function answers a report request, as JSON or as CSV with ?format=csv
+func reportHandler(rep report) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		r, err := parseReportRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rows, err := rep.run(ctx, r)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while computing the report"})
+			return
+		}
+		for _, row := range rows {
+			for column, value := range row {
+				if amount, ok := value.(float64); ok {
+					row[column] = Tofixed(amount, 2)
+				}
+			}
+		}
+
+		if c.Query("format") != "csv" {
+			c.JSON(http.StatusOK, gin.H{
+				"report":  rep.name,
+				"from":    r.From,
+				"to":      r.To,
+				"tz":      r.Timezone,
+				"columns": rep.columns,
+				"rows":    rows,
+			})
+			return
+		}
+
+		writeRowsCSV(c, rep.name, rep.columns, rows)
+	}
+}
+
+// This function answers rows as a CSV file with a header line, the columns in the order given
+func writeRowsCSV(c *gin.Context, name string, columns []string, rows []bson.M) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			if value, ok := row[column]; ok && value != nil {
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+}
+
+func aggregateRows(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) ([]bson.M, error) {
+	res, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	rows := []bson.M{}
+	if err = res.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// The orders of the period which are paid (refunded ones are not sales anymore)
+func paidOrderStages(r reportRange) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"order_date": bson.M{"$gte": r.From, "$lt": r.To}, "deleted_at": nil}}},
+		{{Key: "$lookup", Value: bson.M{"from": "Invoice", "localField": "order_id", "foreignField": "order_id", "as": "invoices"}}},
+		{{Key: "$match", Value: bson.M{"invoices": bson.M{"$elemMatch": bson.M{"payment_status": "PAID", "deleted_at": nil}}}}},
+	}
+}
+
+// One document per item sold in the period, the item being under "item"
+func paidItemStages(r reportRange) mongo.Pipeline {
+	return append(paidOrderStages(r),
+		bson.D{{Key: "$lookup", Value: bson.M{"from": "orderItem", "localField": "order_id", "foreignField": "order_id", "as": "item"}}},
+		bson.D{{Key: "$unwind", Value: "$item"}},
+		bson.D{{Key: "$match", Value: bson.M{"item.deleted_at": nil}}},
+	)
+}
+
+// The sum of the prices of the items which are not deleted, from an array of items looked up
+func itemsAmount(items string) bson.M {
+	return bson.M{"$sum": bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{
+			"input": items,
+			"cond":  bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$$this.deleted_at", nil}}, nil}},
+		}},
+		"in": "$$this.unit_price",
+	}}}
+}
+
+// The paid invoices (not deleted) of an array of invoices looked up
+func paidInvoices(invoices string) bson.M {
+	return bson.M{"$filter": bson.M{
+		"input": invoices,
+		"cond": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$$this.payment_status", "PAID"}},
+			bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$$this.deleted_at", nil}}, nil}},
+		}},
+	}}
+}
+
+// The first element of an array looked up
+func firstOf(array string) bson.M {
+	return bson.M{"$arrayElemAt": bson.A{array, 0}}
+}
+
+// The local day of a date, like 2026-01-31
+func localDay(date string, r reportRange) bson.M {
+	return bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": date, "timezone": r.Timezone}}
+}
+
+// The service (breakfast, lunch, dinner) a date belongs to, by its local hour
+func localService(date string, r reportRange) bson.M {
+	hour := bson.M{"$hour": bson.M{"date": date, "timezone": r.Timezone}}
+	branches := bson.A{}
+	for _, service := range services {
+		branches = append(branches, bson.M{"case": bson.M{"$lt": bson.A{hour, service.until}}, "then": service.name})
+	}
+	return bson.M{"$switch": bson.M{"branches": branches, "default": services[len(services)-1].name}}
+}
+
+// Sales grouped by a key computed from the sold items
+func salesBy(items mongo.Pipeline, key interface{}, column string, sort bson.D) mongo.Pipeline {
+	return append(items,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":     key,
+			"orders":  bson.M{"$addToSet": "$order_id"},
+			"items":   bson.M{"$sum": 1},
+			"revenue": bson.M{"$sum": "$item.unit_price"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":     0,
+			column:    "$_id",
+			"orders":  bson.M{"$size": "$orders"},
+			"items":   1,
+			"revenue": 1,
+		}}},
+		bson.D{{Key: "$sort", Value: sort}},
+	)
+}
+
+var salesByDayReport = report{
+	name:    "sales_by_day",
+	columns: []string{"day", "orders", "items", "revenue"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		return aggregateRows(ctx, orderCollection, salesBy(paidItemStages(r), localDay("$order_date", r), "day", bson.D{{Key: "day", Value: 1}}))
+	},
+}
+
+var salesByHourReport = report{
+	name:    "sales_by_hour",
+	columns: []string{"hour", "orders", "items", "revenue"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		hour := bson.M{"$hour": bson.M{"date": "$order_date", "timezone": r.Timezone}}
+		return aggregateRows(ctx, orderCollection, salesBy(paidItemStages(r), hour, "hour", bson.D{{Key: "hour", Value: 1}}))
+	},
+}
+
+var salesByFoodReport = report{
+	name:    "sales_by_food",
+	columns: []string{"food_id", "name", "orders", "items", "revenue"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		pipeline := append(salesBy(paidItemStages(r), "$item.food_id", "food_id", bson.D{{Key: "revenue", Value: -1}, {Key: "food_id", Value: 1}}),
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "food", "localField": "food_id", "foreignField": "food_id", "as": "food"}}},
+			bson.D{{Key: "$addFields", Value: bson.M{"name": firstOf("$food.name")}}},
+			bson.D{{Key: "$project", Value: bson.M{"food": 0}}},
+		)
+		return aggregateRows(ctx, orderCollection, pipeline)
+	},
+}
+
+var salesByCategoryReport = report{
+	name:    "sales_by_category",
+	columns: []string{"category", "orders", "items", "revenue"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		items := append(paidItemStages(r),
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "food", "localField": "item.food_id", "foreignField": "food_id", "as": "food"}}},
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "menu", "localField": "food.menu_id", "foreignField": "menu_id", "as": "menu"}}},
+		)
+		category := bson.M{"$ifNull": bson.A{firstOf("$menu.category"), "UNCATEGORIZED"}}
+		return aggregateRows(ctx, orderCollection, salesBy(items, category, "category", bson.D{{Key: "revenue", Value: -1}, {Key: "category", Value: 1}}))
+	},
+}
+
+var averageCheckReport = report{
+	name:    "average_check",
+	columns: []string{"checks", "revenue", "average_check"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		pipeline := append(paidItemStages(r),
+			bson.D{{Key: "$group", Value: bson.M{"_id": "$order_id", "total": bson.M{"$sum": "$item.unit_price"}}}},
+			bson.D{{Key: "$group", Value: bson.M{
+				"_id":           nil,
+				"checks":        bson.M{"$sum": 1},
+				"revenue":       bson.M{"$sum": "$total"},
+				"average_check": bson.M{"$avg": "$total"},
+			}}},
+			bson.D{{Key: "$project", Value: bson.M{"_id": 0}}},
+		)
+		return aggregateRows(ctx, orderCollection, pipeline)
+	},
+}
+
+// The guests seated, from the guests of every order. The orders without guests count none.
+var coversReport = report{
+	name:    "covers",
+	columns: []string{"day", "service", "orders", "covers"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"order_date": bson.M{"$gte": r.From, "$lt": r.To}, "deleted_at": nil}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":    bson.M{"day": localDay("$order_date", r), "service": localService("$order_date", r)},
+				"orders": bson.M{"$sum": 1},
+				"covers": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$guests", 0}}},
+			}}},
+			{{Key: "$project", Value: bson.M{"_id": 0, "day": "$_id.day", "service": "$_id.service", "orders": 1, "covers": 1}}},
+			{{Key: "$sort", Value: bson.D{{Key: "day", Value: 1}, {Key: "service", Value: 1}}}},
+		}
+		return aggregateRows(ctx, orderCollection, pipeline)
+	},
+}
+
+// The checks paid with each method and what they come to. An order paid with several invoices counts once for each
+// method it was paid with, its revenue being split evenly between its paid invoices, so the revenue adds up to the
+// one of the other reports.
+var paymentMethodsReport = report{
+	name:    "payment_methods",
+	columns: []string{"payment_method", "checks", "revenue", "share"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		pipeline := append(paidOrderStages(r),
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "orderItem", "localField": "order_id", "foreignField": "order_id", "as": "items"}}},
+			bson.D{{Key: "$addFields", Value: bson.M{"paid": paidInvoices("$invoices"), "amount": itemsAmount("$items")}}},
+			bson.D{{Key: "$addFields", Value: bson.M{"invoice_amount": bson.M{"$divide": bson.A{"$amount", bson.M{"$size": "$paid"}}}}}},
+			bson.D{{Key: "$unwind", Value: "$paid"}},
+			bson.D{{Key: "$group", Value: bson.M{
+				"_id":     bson.M{"$ifNull": bson.A{"$paid.payment_method", ""}},
+				"orders":  bson.M{"$addToSet": "$order_id"},
+				"revenue": bson.M{"$sum": "$invoice_amount"},
+			}}},
+			bson.D{{Key: "$project", Value: bson.M{"_id": 0, "payment_method": "$_id", "checks": bson.M{"$size": "$orders"}, "revenue": 1}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "revenue", Value: -1}}}},
+		)
+		rows, err := aggregateRows(ctx, orderCollection, pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		// the share of the revenue taken with each method
+		total := 0.0
+		for _, row := range rows {
+			revenue, _ := row["revenue"].(float64)
+			total += revenue
+		}
+		for _, row := range rows {
+			revenue, _ := row["revenue"].(float64)
+			row["share"] = 0.0
+			if total > 0 {
+				row["share"] = revenue / total
+			}
+			if row["payment_method"] == "" {
+				row["payment_method"] = "UNKNOWN"
+			}
+		}
+		return rows, nil
+	},
+}
+
+// The paid checks of each server, with the guests they seated (the guests of the orders), what the checks come to
+// and the tips left on them. An order paid with several invoices is one check, with the tips of all of them.
+// The orders from before the servers were recorded are under UNASSIGNED.
+var salesByServerReport = report{
+	name:    "sales_by_server",
+	columns: []string{"server_id", "name", "checks", "covers", "revenue", "average_check", "tips"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		pipeline := append(paidOrderStages(r),
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "orderItem", "localField": "order_id", "foreignField": "order_id", "as": "items"}}},
+			bson.D{{Key: "$addFields", Value: bson.M{"paid": paidInvoices("$invoices")}}},
+			bson.D{{Key: "$group", Value: bson.M{
+				"_id":     bson.M{"$ifNull": bson.A{"$server_id", ""}},
+				"checks":  bson.M{"$sum": 1},
+				"covers":  bson.M{"$sum": bson.M{"$ifNull": bson.A{"$guests", 0}}},
+				"revenue": bson.M{"$sum": itemsAmount("$items")},
+				"tips":    bson.M{"$sum": bson.M{"$sum": "$paid.tip"}},
+			}}},
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "user", "localField": "_id", "foreignField": "user_id", "as": "server"}}},
+			bson.D{{Key: "$project", Value: bson.M{
+				"_id":       0,
+				"server_id": "$_id",
+				"name": bson.M{"$trim": bson.M{"input": bson.M{"$concat": bson.A{
+					bson.M{"$ifNull": bson.A{firstOf("$server.first_name"), ""}}, " ",
+					bson.M{"$ifNull": bson.A{firstOf("$server.last_name"), ""}},
+				}}}},
+				"checks":  1,
+				"covers":  1,
+				"revenue": 1,
+				"tips":    1,
+			}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "revenue", Value: -1}, {Key: "server_id", Value: 1}}}},
+		)
+		rows, err := aggregateRows(ctx, orderCollection, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			revenue, _ := row["revenue"].(float64)
+			checks, _ := row["checks"].(int32)
+			row["average_check"] = 0.0
+			if checks > 0 {
+				row["average_check"] = revenue / float64(checks)
+			}
+			if row["server_id"] == "" {
+				row["server_id"] = "UNASSIGNED"
+			}
+		}
+		return rows, nil
+	},
+}
+
+// The hours scheduled and worked by each user in each business day, from the schedule and the time clock.
+// The entries still open count until now.
+var laborReport = report{
+	name:    "labor",
+	columns: []string{"business_day", "user_id", "name", "scheduled_hours", "worked_hours", "break_hours", "variance_hours", "open_entries"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		hours, err := laborHours(ctx, r.From.Format("2006-01-02"), r.To.Format("2006-01-02"), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		userIDs := []string{}
+		for userID := range hours {
+			userIDs = append(userIDs, userID)
+		}
+		names, err := userNames(ctx, userIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		rows := []bson.M{}
+		for userID, days := range hours {
+			for day, labor := range days {
+				rows = append(rows, bson.M{
+					"business_day":    day,
+					"user_id":         userID,
+					"name":            names[userID],
+					"scheduled_hours": labor.scheduled,
+					"worked_hours":    labor.worked,
+					"break_hours":     labor.breaks,
+					"variance_hours":  labor.worked - labor.scheduled,
+					"open_entries":    labor.open,
+				})
+			}
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i]["business_day"] != rows[j]["business_day"] {
+				return rows[i]["business_day"].(string) < rows[j]["business_day"].(string)
+			}
+			if rows[i]["name"] != rows[j]["name"] {
+				return rows[i]["name"].(string) < rows[j]["name"].(string)
+			}
+			return rows[i]["user_id"].(string) < rows[j]["user_id"].(string)
+		})
+		return rows, nil
+	},
+}
+
+// What each employee took from the tips of the locked days of the period, with the adjustments made by the managers
+var tipsReport = report{
+	name:    "tips",
+	columns: []string{"user_id", "name", "days", "hours", "calculated", "adjustments", "tips"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		return aggregateRows(ctx, tipDistributionCollection, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"business_day": bson.M{"$gte": r.From.Format("2006-01-02"), "$lt": r.To.Format("2006-01-02")},
+				"status":       models.TipDistributionLocked,
+				"deleted_at":   nil,
+			}}},
+			{{Key: "$unwind", Value: "$shares"}},
+			{{Key: "$group", Value: bson.M{
+				"_id":         "$shares.user_id",
+				"name":        bson.M{"$last": "$shares.name"},
+				"days":        bson.M{"$addToSet": "$business_day"},
+				"hours":       bson.M{"$sum": "$shares.hours"},
+				"calculated":  bson.M{"$sum": "$shares.calculated"},
+				"adjustments": bson.M{"$sum": "$shares.adjustment"},
+				"tips":        bson.M{"$sum": "$shares.amount"},
+			}}},
+			{{Key: "$project", Value: bson.M{
+				"_id":         0,
+				"user_id":     "$_id",
+				"name":        1,
+				"days":        bson.M{"$size": "$days"},
+				"hours":       1,
+				"calculated":  1,
+				"adjustments": 1,
+				"tips":        1,
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "user_id", Value: 1}}}},
+		})
+	},
+}
+
+// Voids are the order items deleted (whether alone or with their order), refunds the refunded invoices
+var voidsRefundsReport = report{
+	name:    "voids_refunds",
+	columns: []string{"kind", "day", "count", "amount"},
+	run: func(ctx context.Context, r reportRange) ([]bson.M, error) {
+		voids, err := aggregateRows(ctx, orderItemsCollection, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"deleted_at": bson.M{"$gte": r.From, "$lt": r.To}}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":    localDay("$deleted_at", r),
+				"count":  bson.M{"$sum": 1},
+				"amount": bson.M{"$sum": "$unit_price"},
+			}}},
+			{{Key: "$project", Value: bson.M{"_id": 0, "kind": "VOID", "day": "$_id", "count": 1, "amount": 1}}},
+			{{Key: "$sort", Value: bson.D{{Key: "day", Value: 1}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		refunds, err := aggregateRows(ctx, invoiceCollections, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"payment_status": "REFUNDED", "refunded_at": bson.M{"$gte": r.From, "$lt": r.To}, "deleted_at": nil}}},
+			{{Key: "$lookup", Value: bson.M{"from": "orderItem", "localField": "order_id", "foreignField": "order_id", "as": "items"}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":    localDay("$refunded_at", r),
+				"count":  bson.M{"$sum": 1},
+				"amount": bson.M{"$sum": itemsAmount("$items")},
+			}}},
+			{{Key: "$project", Value: bson.M{"_id": 0, "kind": "REFUND", "day": "$_id", "count": 1, "amount": 1}}},
+			{{Key: "$sort", Value: bson.D{{Key: "day", Value: 1}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(voids, refunds...), nil
+	},
+}
+
+func SalesByDay() gin.HandlerFunc {
+	return reportHandler(salesByDayReport)
+}
+
+func SalesByHour() gin.HandlerFunc {
+	return reportHandler(salesByHourReport)
+}
+
+func SalesByFood() gin.HandlerFunc {
+	return reportHandler(salesByFoodReport)
+}
+
+func SalesByCategory() gin.HandlerFunc {
+	return reportHandler(salesByCategoryReport)
+}
+
+func AverageCheck() gin.HandlerFunc {
+	return reportHandler(averageCheckReport)
+}
+
+func Covers() gin.HandlerFunc {
+	return reportHandler(coversReport)
+}
+
+func PaymentMethods() gin.HandlerFunc {
+	return reportHandler(paymentMethodsReport)
+}
+
+func SalesByServer() gin.HandlerFunc {
+	return reportHandler(salesByServerReport)
+}
+
+func Labor() gin.HandlerFunc {
+	return reportHandler(laborReport)
+}
+
+func Tips() gin.HandlerFunc {
+	return reportHandler(tipsReport)
+}
+
+func VoidsRefunds() gin.HandlerFunc {
+	return reportHandler(voidsRefundsReport)
+}
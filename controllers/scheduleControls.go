@@ -0,0 +1,370 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var scheduledShiftCollection *mongo.Collection = database.OpenCollection(database.Client, "schedule_shifts")
+
+// The longest shift which can be scheduled
+const maxShiftHours = 16
+
+// What the shifts of the schedule can be filtered and sorted by
+var scheduledShiftListSpec = helpers.ListSpec{
+	SortFields:  []string{"starts_at", "business_day", "created_at"},
+	DefaultSort: "starts_at",
+	Filters: map[string]helpers.FilterType{
+		"user_id":      helpers.FilterString,
+		"role":         helpers.FilterString,
+		"business_day": helpers.FilterString,
+		"starts_at":    helpers.FilterTime,
+	},
+}
+
+// The Monday starting the week of the schedule a day belongs to, and the Monday after
+func scheduleWeek(day string) (time.Time, time.Time, error) {
+	start, _, err := businessDayRange(day)
+	if err != nil {
+		return start, start, err
+	}
+	start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	return start, start.AddDate(0, 0, 7), nil
+}
+
+// This function checks a shift: it ends after it starts, isn't too long, is for a user, and doesn't overlap
+// another shift of the same user. A broken rule is a validationError.
+func checkScheduledShift(ctx context.Context, shift models.ScheduledShift) error {
+	if !shift.Ends_at.After(*shift.Starts_at) {
+		return validationError{"ends_at must be after starts_at"}
+	}
+	if shift.Ends_at.Sub(*shift.Starts_at) > maxShiftHours*time.Hour {
+		return validationError{fmt.Sprintf("a shift lasts at most %d hours", maxShiftHours)}
+	}
+	count, err := usersCollection.CountDocuments(ctx, bson.M{"user_id": *shift.User_id, "deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return validationError{"user not found"}
+	}
+	count, err = scheduledShiftCollection.CountDocuments(ctx, bson.M{
+		"shift_id":   bson.M{"$ne": shift.Shift_id},
+		"user_id":    *shift.User_id,
+		"starts_at":  bson.M{"$lt": *shift.Ends_at},
+		"ends_at":    bson.M{"$gt": *shift.Starts_at},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return validationError{"the user already has a shift at that time"}
+	}
+	return nil
+}
+
+func respondScheduleError(c *gin.Context, err error) {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the shift"})
+}
+
+func GetScheduledShifts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, scheduledShiftCollection, scheduledShiftListSpec, bson.M{})
+	}
+}
+
+func GetScheduledShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var shift models.ScheduledShift
+		err := scheduledShiftCollection.FindOne(ctx, withDeleted(c, bson.M{"shift_id": c.Param("shift_id")})).Decode(&shift)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "shift not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the shift"})
+			return
+		}
+		helpers.SetETag(c, shift.Version)
+		c.JSON(http.StatusOK, shift)
+	}
+}
+
+// This function schedules a shift, as {"user_id": "...", "role": "SERVER", "starts_at": "...", "ends_at": "..."}
+func CreateScheduledShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var shift models.ScheduledShift
+		if err := c.BindJSON(&shift); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(shift); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		shift.ID = primitive.NewObjectID()
+		shift.Shift_id = shift.ID.Hex()
+		shift.Business_day = businessDay(*shift.Starts_at)
+		shift.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		shift.Updated_at = shift.Created_at
+		shift.Version = 1
+		shift.Deleted_at, shift.Deleted_by = nil, nil
+
+		_, err := insertAuditedThen(ctx, c, scheduledShiftCollection, "scheduled_shift", shift.Shift_id, shift, func(ctx context.Context) error {
+			return checkScheduledShift(ctx, shift)
+		})
+		if err != nil {
+			respondScheduleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, shift)
+	}
+}
+
+// This function changes who works a shift, in which role, when or its notes, with its version in If-Match
+func UpdateScheduledShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		shiftID := c.Param("shift_id")
+
+		var changes models.ScheduledShift
+		if err := c.BindJSON(&changes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var shift models.ScheduledShift
+		err := scheduledShiftCollection.FindOne(ctx, bson.M{"shift_id": shiftID, "deleted_at": nil}).Decode(&shift)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "shift not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the shift"})
+			return
+		}
+
+		var updateObj primitive.D
+		if changes.User_id != nil {
+			shift.User_id = changes.User_id
+			updateObj = append(updateObj, bson.E{Key: "user_id", Value: changes.User_id})
+		}
+		if changes.Role != nil {
+			shift.Role = changes.Role
+			updateObj = append(updateObj, bson.E{Key: "role", Value: changes.Role})
+		}
+		if changes.Starts_at != nil {
+			shift.Starts_at = changes.Starts_at
+			shift.Business_day = businessDay(*changes.Starts_at)
+			updateObj = append(updateObj, bson.E{Key: "starts_at", Value: changes.Starts_at}, bson.E{Key: "business_day", Value: shift.Business_day})
+		}
+		if changes.Ends_at != nil {
+			shift.Ends_at = changes.Ends_at
+			updateObj = append(updateObj, bson.E{Key: "ends_at", Value: changes.Ends_at})
+		}
+		if changes.Notes != nil {
+			shift.Notes = changes.Notes
+			updateObj = append(updateObj, bson.E{Key: "notes", Value: changes.Notes})
+		}
+		if err := validate.Struct(shift); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+		updateVersionedThen(ctx, c, scheduledShiftCollection, "scheduled_shift", "shift_id", shiftID, updateObj, func(ctx context.Context) error {
+			return checkScheduledShift(ctx, shift)
+		})
+	}
+}
+
+// The time entries keep the shift they worked even once it is deleted. Restoring it checks it still fits the schedule.
+var deletableScheduledShift = deletable{
+	collection:   scheduledShiftCollection,
+	resourceType: "scheduled_shift",
+	idField:      "shift_id",
+	cascadeRestore: func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error {
+		var shift models.ScheduledShift
+		if err := scheduledShiftCollection.FindOne(ctx, bson.M{"shift_id": id}).Decode(&shift); err != nil {
+			return err
+		}
+		if err := checkScheduledShift(ctx, shift); err != nil {
+			var invalid validationError
+			if errors.As(err, &invalid) {
+				return referencedError{invalid.msg}
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+func DeleteScheduledShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableScheduledShift, c.Param("shift_id"))
+	}
+}
+
+func RestoreScheduledShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableScheduledShift, c.Param("shift_id"))
+	}
+}
+
+// The shifts starting in a period, ?user_id= only keeps the ones of a user
+func shiftsBetween(ctx context.Context, from time.Time, to time.Time, userID string) ([]models.ScheduledShift, error) {
+	filter := bson.M{"starts_at": bson.M{"$gte": from, "$lt": to}, "deleted_at": nil}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+	res, err := scheduledShiftCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "starts_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	shifts := []models.ScheduledShift{}
+	if err = res.All(ctx, &shifts); err != nil {
+		return nil, err
+	}
+	return shifts, nil
+}
+
+// This function shows the schedule of the week of ?day=2026-01-28 (this week by default), from Monday,
+// the shifts grouped by role with the hours scheduled for each. ?user_id= only shows the shifts of one user.
+func GetScheduleWeek() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		from, to, err := scheduleWeek(c.DefaultQuery("day", businessDay(time.Now())))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "day must be a date like 2026-01-31"})
+			return
+		}
+		shifts, err := shiftsBetween(ctx, from, to, c.Query("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the schedule"})
+			return
+		}
+
+		byRole := map[string]*models.ScheduleRole{}
+		roles := []string{}
+		for _, shift := range shifts {
+			role := *shift.Role
+			if byRole[role] == nil {
+				byRole[role] = &models.ScheduleRole{Role: role, Shifts: []models.ScheduledShift{}}
+				roles = append(roles, role)
+			}
+			byRole[role].Shifts = append(byRole[role].Shifts, shift)
+			byRole[role].Hours += shift.Ends_at.Sub(*shift.Starts_at).Hours()
+		}
+		sort.Strings(roles)
+		schedule := []models.ScheduleRole{}
+		for _, role := range roles {
+			byRole[role].Hours = Tofixed(byRole[role].Hours, 2)
+			schedule = append(schedule, *byRole[role])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"week_start": businessDay(from), "week_end": businessDay(to.AddDate(0, 0, -1)), "roles": schedule})
+	}
+}
+
+// This function copies the shifts of a week to another one, as {"from_day": "2026-01-26", "to_day": "2026-02-02"}
+// (any day of each week). The shifts which would overlap one already scheduled are left out, the answer lists them.
+func CopyScheduleWeek() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			From_day *string `json:"from_day" validate:"required,datetime=2006-01-02"`
+			To_day   *string `json:"to_day" validate:"required,datetime=2006-01-02"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from_day and to_day must be dates like 2026-01-31"})
+			return
+		}
+		from, to, _ := scheduleWeek(*body.From_day)
+		target, _, _ := scheduleWeek(*body.To_day)
+		if target.Equal(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a week can't be copied onto itself"})
+			return
+		}
+		weeks := int(target.Sub(from).Hours()/24+0.5) / 7
+
+		copied := []models.ScheduledShift{}
+		skipped := []string{}
+		err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+			shifts, err := shiftsBetween(ctx, from, to, "")
+			if err != nil {
+				return err
+			}
+			now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			for _, shift := range shifts {
+				startsAt := shift.Starts_at.In(businessLocation).AddDate(0, 0, 7*weeks)
+				endsAt := shift.Ends_at.In(businessLocation).AddDate(0, 0, 7*weeks)
+				copiedFrom := shift.Shift_id
+				shift.ID = primitive.NewObjectID()
+				shift.Shift_id = shift.ID.Hex()
+				shift.Starts_at, shift.Ends_at = &startsAt, &endsAt
+				shift.Business_day = businessDay(startsAt)
+				shift.Created_at, shift.Updated_at, shift.Version = now, now, 1
+
+				err := checkScheduledShift(ctx, shift)
+				var invalid validationError
+				if errors.As(err, &invalid) {
+					skipped = append(skipped, copiedFrom)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if _, err := scheduledShiftCollection.InsertOne(ctx, shift); err != nil {
+					return err
+				}
+				if err := recordAudit(ctx, c, models.AuditCreate, "scheduled_shift", shift.Shift_id, nil, shift); err != nil {
+					return err
+				}
+				copied = append(copied, shift)
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while copying the schedule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"shifts": copied, "skipped_shift_ids": skipped})
+	}
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The price ranges used for the price facet, the last bucket takes everything above
+var priceFacetBoundaries = []interface{}{0, 5, 10, 20, 50}
+
+// The sorting options of the search, relevance is only available when searching with q
+var searchSorts = map[string]bson.D{
+	"price":      {{Key: "price", Value: 1}, {Key: "_id", Value: 1}},
+	"-price":     {{Key: "price", Value: -1}, {Key: "_id", Value: 1}},
+	"name":       {{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+	"-name":      {{Key: "name", Value: -1}, {Key: "_id", Value: 1}},
+	"popularity": {{Key: "popularity", Value: -1}, {Key: "_id", Value: 1}},
+	"relevance":  {{Key: "score", Value: -1}, {Key: "_id", Value: 1}},
+}
+
+// This function searches the foods, and the menus matching the same text
+// Query params:
+// q: the text to search in name and description
+// menu_id, category: only foods of this menu, or of menus in this category
+// min_price, max_price: the price range
+// allergen_free: comma separated allergens, the foods containing any of them are left out
+// available: true or false
+// sort: price, -price, name, -name, popularity or relevance (default when q is given)
+// page, recordsPerPage: same as the other listings
+func SearchFoods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		q := strings.TrimSpace(c.Query("q"))
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordsPerPage"))
+		if err != nil || recordPerPage < 1 || recordPerPage > 100 {
+			recordPerPage = 10
+		}
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		startIndex := (page - 1) * recordPerPage
+
+		sortKey := c.Query("sort")
+		if sortKey == "" {
+			sortKey = "name"
+			if q != "" {
+				sortKey = "relevance"
+			}
+		}
+		sortStage, ok := searchSorts[sortKey]
+		if !ok || (sortKey == "relevance" && q == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of price, -price, name, -name, popularity or relevance (with q)"})
+			return
+		}
+
+		// the $text match has to be the very first stage of the pipeline
+		textMatch := bson.M{}
+		if q != "" {
+			textMatch["$text"] = bson.M{"$search": q}
+		}
+
+		foodFilter := bson.M{"deleted_at": nil}
+		if menuID := c.Query("menu_id"); menuID != "" {
+			foodFilter["menu_id"] = menuID
+		}
+
+		priceFilter := bson.M{}
+		for param, op := range map[string]string{"min_price": "$gte", "max_price": "$lte"} {
+			if value := c.Query(param); value != "" {
+				price, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a number"})
+					return
+				}
+				priceFilter[op] = price
+			}
+		}
+		if len(priceFilter) > 0 {
+			foodFilter["price"] = priceFilter
+		}
+
+		if allergenFree := c.Query("allergen_free"); allergenFree != "" {
+			foodFilter["allergens"] = bson.M{"$nin": normalizeAllergens(strings.Split(allergenFree, ","))}
+		}
+
+		if available := c.Query("available"); available != "" {
+			isAvailable, err := strconv.ParseBool(available)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "available must be true or false"})
+				return
+			}
+			// foods created before the flag existed count as available
+			if isAvailable {
+				foodFilter["is_available"] = bson.M{"$ne": false}
+			} else {
+				foodFilter["is_available"] = false
+			}
+		}
+
+		// the category lives on the menu, so the menu is looked up for every food
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: textMatch}},
+			{{Key: "$match", Value: foodFilter}},
+			{{Key: "$lookup", Value: bson.M{"from": "menu", "localField": "menu_id", "foreignField": "menu_id", "as": "menu"}}},
+			{{Key: "$unwind", Value: bson.M{"path": "$menu", "preserveNullAndEmptyArrays": true}}},
+		}
+		if q != "" {
+			pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}})
+		}
+		if category := c.Query("category"); category != "" {
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"menu.category": category}}})
+		}
+
+		// popularity is the number of times the food has been ordered
+		itemsStages := bson.A{}
+		if sortKey == "popularity" {
+			itemsStages = append(itemsStages,
+				bson.M{"$lookup": bson.M{"from": "orderItem", "localField": "food_id", "foreignField": "food_id", "as": "ordered"}},
+				bson.M{"$addFields": bson.M{"popularity": bson.M{"$size": "$ordered"}}},
+				bson.M{"$project": bson.M{"ordered": 0}},
+			)
+		}
+		itemsStages = append(itemsStages,
+			bson.M{"$sort": sortStage},
+			bson.M{"$skip": startIndex},
+			bson.M{"$limit": recordPerPage},
+			bson.M{"$addFields": bson.M{"category": "$menu.category", "menu_name": "$menu.name"}},
+			bson.M{"$project": bson.M{"_id": 0, "menu": 0}},
+		)
+
+		facetStage := bson.D{{Key: "$facet", Value: bson.M{
+			"items": itemsStages,
+			"total": bson.A{bson.M{"$count": "count"}},
+			"categories": bson.A{
+				bson.M{"$group": bson.M{"_id": "$menu.category", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.M{"count": -1}},
+			},
+			"menus": bson.A{
+				bson.M{"$group": bson.M{"_id": "$menu_id", "name": bson.M{"$first": "$menu.name"}, "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.M{"count": -1}},
+			},
+			"allergens": bson.A{
+				bson.M{"$unwind": "$allergens"},
+				bson.M{"$group": bson.M{"_id": "$allergens", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.M{"count": -1}},
+			},
+			"availability": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$ne": bson.A{"$is_available", false}}, "count": bson.M{"$sum": 1}}},
+			},
+			"price_ranges": bson.A{
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$price",
+					"boundaries": priceFacetBoundaries,
+					"default":    "other",
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+		}}}
+		pipeline = append(pipeline, facetStage)
+
+		res, err := foodCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while searching the foods"})
+			return
+		}
+		var result []bson.M
+		if err = res.All(ctx, &result); err != nil || len(result) == 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while reading the search results"})
+			return
+		}
+		facets := result[0]
+
+		var total int32
+		if counts, ok := facets["total"].(bson.A); ok && len(counts) > 0 {
+			total, _ = counts[0].(bson.M)["count"].(int32)
+		}
+
+		// menus matching the same text, so "desserts" also finds the dessert menu itself
+		menus := []bson.M{}
+		if q != "" {
+			menuRes, err := menuCollection.Find(ctx,
+				bson.M{"$text": bson.M{"$search": q}, "deleted_at": nil},
+				options.Find().
+					SetProjection(bson.M{"_id": 0, "score": bson.M{"$meta": "textScore"}}).
+					SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}).
+					SetLimit(10),
+			)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while searching the menus"})
+				return
+			}
+			if err = menuRes.All(ctx, &menus); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while reading the menus"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count": total,
+			"page":        page,
+			"food_items":  facets["items"],
+			"menus":       menus,
+			"facets": gin.H{
+				"categories":   facets["categories"],
+				"menus":        facets["menus"],
+				"allergens":    facets["allergens"],
+				"availability": facets["availability"],
+				"price_ranges": facets["price_ranges"],
+			},
+		})
+	}
+}
+
+// Allergens are kept in lower case, so "Nuts" and "nuts" are the same thing
+func normalizeAllergens(allergens []string) []string {
+	normalized := []string{}
+	for _, allergen := range allergens {
+		allergen = strings.ToLower(strings.TrimSpace(allergen))
+		if allergen != "" {
+			normalized = append(normalized, allergen)
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var sectionAssignmentCollection *mongo.Collection = database.OpenCollection(database.Client, "section_assignments")
+
+// What the section assignments can be filtered and sorted by
+var sectionAssignmentListSpec = helpers.ListSpec{
+	SortFields:  []string{"business_day", "section", "created_at"},
+	DefaultSort: "-business_day",
+	Filters: map[string]helpers.FilterType{
+		"business_day": helpers.FilterString,
+		"shift":        helpers.FilterString,
+		"section":      helpers.FilterString,
+		"server_id":    helpers.FilterString,
+	},
+}
+
+// The shift a moment belongs to, by its local hour like the services of the reports
+func shiftAt(t time.Time) string {
+	hour := t.In(businessLocation).Hour()
+	for _, service := range services {
+		if hour < service.until {
+			return service.name
+		}
+	}
+	return services[len(services)-1].name
+}
+
+// The server an order taken through this request belongs to, nobody when the request isn't a user's
+func serverOf(c *gin.Context) *string {
+	if c == nil {
+		return nil
+	}
+	uid := c.GetString("uid")
+	if uid == "" {
+		return nil
+	}
+	return &uid
+}
+
+// This function checks that a user can serve tables: a server or a manager. A broken rule is a validationError.
+func ensureServer(ctx context.Context, userID string) error {
+	count, err := usersCollection.CountDocuments(ctx, bson.M{
+		"user_id":    userID,
+		"role":       bson.M{"$in": bson.A{models.RoleServer, models.RoleManager}},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return validationError{fmt.Sprintf("the user %s is not a server", userID)}
+	}
+	return nil
+}
+
+// This function checks that a section has tables and isn't given to someone else for the same shift.
+// A broken rule is a validationError.
+func ensureSectionFree(ctx context.Context, assignment models.SectionAssignment) error {
+	count, err := tablesCollection.CountDocuments(ctx, bson.M{"section": *assignment.Section, "deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return validationError{fmt.Sprintf("the section %s has no tables", *assignment.Section)}
+	}
+	count, err = sectionAssignmentCollection.CountDocuments(ctx, bson.M{
+		"assignment_id": bson.M{"$ne": assignment.Assignment_id},
+		"section":       *assignment.Section,
+		"business_day":  *assignment.Business_day,
+		"shift":         *assignment.Shift,
+		"deleted_at":    nil,
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return validationError{fmt.Sprintf("the section %s already has a server for the %s shift of %s", *assignment.Section, *assignment.Shift, *assignment.Business_day)}
+	}
+	return nil
+}
+
+func respondAssignmentError(c *gin.Context, err error) {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+		return
+	}
+	// two assignments of the same section made at once, the unique index kept the first one
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the section already has a server for this shift"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the assignment"})
+}
+
+func GetSectionAssignments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, sectionAssignmentCollection, sectionAssignmentListSpec, bson.M{})
+	}
+}
+
+func GetSectionAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var assignment models.SectionAssignment
+		err := sectionAssignmentCollection.FindOne(ctx, withDeleted(c, bson.M{"assignment_id": c.Param("assignment_id")})).Decode(&assignment)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the assignment"})
+			return
+		}
+		helpers.SetETag(c, assignment.Version)
+		c.JSON(http.StatusOK, assignment)
+	}
+}
+
+// This function gives a section to a server for a shift, as {"section": "Terrace", "server_id": "...",
+// "business_day": "2026-01-31", "shift": "DINNER"}
+func CreateSectionAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var assignment models.SectionAssignment
+		if err := c.BindJSON(&assignment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(assignment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		assignment.ID = primitive.NewObjectID()
+		assignment.Assignment_id = assignment.ID.Hex()
+		assignment.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		assignment.Updated_at = assignment.Created_at
+		assignment.Version = 1
+		assignment.Deleted_at, assignment.Deleted_by = nil, nil
+
+		if err := ensureServer(ctx, *assignment.Server_id); err != nil {
+			respondAssignmentError(c, err)
+			return
+		}
+		_, err := insertAuditedThen(ctx, c, sectionAssignmentCollection, "section_assignment", assignment.Assignment_id, assignment, func(ctx context.Context) error {
+			return ensureSectionFree(ctx, assignment)
+		})
+		if err != nil {
+			respondAssignmentError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, assignment)
+	}
+}
+
+// This function gives an assigned section to another server, as {"server_id": "..."} with its version in If-Match
+func UpdateSectionAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Server_id *string `json:"server_id" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "server_id is required"})
+			return
+		}
+		if err := ensureServer(ctx, *body.Server_id); err != nil {
+			respondAssignmentError(c, err)
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "server_id", Value: body.Server_id},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersioned(ctx, c, sectionAssignmentCollection, "section_assignment", "assignment_id", c.Param("assignment_id"), updateObj)
+	}
+}
+
+// Nothing points at an assignment, it can always be deleted. Restoring it checks the section is still free.
+var deletableSectionAssignment = deletable{
+	collection:   sectionAssignmentCollection,
+	resourceType: "section_assignment",
+	idField:      "assignment_id",
+	cascadeRestore: func(ctx context.Context, c *gin.Context, id string, deletedAt time.Time) error {
+		var assignment models.SectionAssignment
+		if err := sectionAssignmentCollection.FindOne(ctx, bson.M{"assignment_id": id}).Decode(&assignment); err != nil {
+			return err
+		}
+		if err := ensureSectionFree(ctx, assignment); err != nil {
+			var invalid validationError
+			if errors.As(err, &invalid) {
+				return referencedError{invalid.msg}
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+func DeleteSectionAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableSectionAssignment, c.Param("assignment_id"))
+	}
+}
+
+func RestoreSectionAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableSectionAssignment, c.Param("assignment_id"))
+	}
+}
+
+// The servers given a section for a shift
+func sectionServers(ctx context.Context, section string, day string, shift string) ([]string, error) {
+	return distinctStrings(ctx, sectionAssignmentCollection, "server_id", bson.M{
+		"section":      section,
+		"business_day": day,
+		"shift":        shift,
+		"deleted_at":   nil,
+	})
+}
+
+// This function shows the sections of the floor with their tables and who serves them, for the shift given as
+// ?business_day=2026-01-31&shift=DINNER or the current one. ?server_id= only keeps the sections of one server.
+func GetSections() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		now := time.Now()
+		day := c.DefaultQuery("business_day", businessDay(now))
+		if err := validate.Var(day, "datetime=2006-01-02"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "business_day must be a date like 2026-01-31"})
+			return
+		}
+		shift := c.DefaultQuery("shift", shiftAt(now))
+		if err := validate.Var(shift, "eq=BREAKFAST|eq=LUNCH|eq=DINNER"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "shift must be BREAKFAST, LUNCH or DINNER"})
+			return
+		}
+		serverID := c.Query("server_id")
+
+		names, err := distinctStrings(ctx, tablesCollection, "section", bson.M{"deleted_at": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the sections"})
+			return
+		}
+		sort.Strings(names)
+
+		sections := []models.FloorSection{}
+		for _, name := range names {
+			section := models.FloorSection{Section: name}
+			if section.Server_ids, err = sectionServers(ctx, name, day, shift); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the sections"})
+				return
+			}
+			if serverID != "" && !containsString(section.Server_ids, serverID) {
+				continue
+			}
+			if section.Table_ids, err = distinctStrings(ctx, tablesCollection, "table_id", bson.M{"section": name, "deleted_at": nil}); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the sections"})
+				return
+			}
+			sections = append(sections, section)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"business_day": day, "shift": shift, "sections": sections})
+	}
+}
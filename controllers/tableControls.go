@@ -3,9 +3,10 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"restaurantms/database"
+	"restaurantms/events"
+	"restaurantms/helpers"
 	"restaurantms/models"
 	"time"
 
@@ -13,33 +14,26 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var tablesCollection *mongo.Collection = database.OpenCollection(database.Client, "tables")
 
+// What the table listing can be filtered and sorted by
+var tableListSpec = helpers.ListSpec{
+	SortFields:  []string{"table_number", "number_of_guests", "section", "status", "created_at"},
+	DefaultSort: "table_number",
+	Filters: map[string]helpers.FilterType{
+		"table_number":     helpers.FilterInt,
+		"number_of_guests": helpers.FilterInt,
+		"section":          helpers.FilterString,
+		"status":           helpers.FilterString,
+		"created_at":       helpers.FilterTime,
+	},
+}
+
 func GetTable() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		res, err := tablesCollection.Find(context.TODO(), bson.M{})
-
-		defer cancel()
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Couldn't find the data"})
-			return
-
-		}
-
-		var allTables []bson.M
-
-		if err = res.All(ctx, &allTables); err != nil {
-			log.Fatal(err)
-		}
-
-		c.JSON(http.StatusOK, allTables)
+		listCollection(c, tablesCollection, tableListSpec, bson.M{})
 	}
 }
 
@@ -52,13 +46,19 @@ func GetTablebyID() gin.HandlerFunc {
 		defer cancel()
 		var tables models.Table
 
-		err := tablesCollection.FindOne(ctx, bson.M{"table_id": tableID}).Decode(&tables)
+		err := tablesCollection.FindOne(ctx, withDeleted(c, bson.M{"table_id": tableID})).Decode(&tables)
 
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
+			return
+		}
 		if err != nil {
 			msg := fmt.Sprintf("Error while fetching the data, tables")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
 		}
 
+		helpers.SetETag(c, tables.Version)
 		c.JSON(http.StatusOK, tables)
 
 	}
@@ -85,8 +85,15 @@ func CreateTable() gin.HandlerFunc {
 
 		tables.ID = primitive.NewObjectID()
 		tables.Table_id = tables.ID.Hex()
+		tables.Version = 1
 
-		res, insertErr := tablesCollection.InsertOne(ctx, tables)
+		// a new table is free, its status changes through POST /table/:table_id/status and its orders
+		status := models.TableAvailable
+		tables.Status = &status
+		tables.Status_changed_at = &tables.Created_at
+		tables.Seated_at = nil
+
+		res, insertErr := insertAudited(ctx, c, tablesCollection, "table", tables.Table_id, tables)
 		if insertErr != nil {
 			msg := fmt.Sprintf("ERROR: Failed to create")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
@@ -100,6 +107,7 @@ func CreateTable() gin.HandlerFunc {
 func UpdateTable() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		var tables models.Table
 
@@ -107,6 +115,7 @@ func UpdateTable() gin.HandlerFunc {
 
 		if err := c.BindJSON(&tables); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
 		var updateObj primitive.D
@@ -118,31 +127,284 @@ func UpdateTable() gin.HandlerFunc {
 			updateObj = append(updateObj, bson.E{"table_number", tables.Table_number})
 		}
 
+		// the place of the table on the floor plan
+		if tables.Section != nil {
+			updateObj = append(updateObj, bson.E{Key: "section", Value: tables.Section})
+		}
+		if tables.Position_x != nil {
+			if *tables.Position_x < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "position_x can't be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "position_x", Value: tables.Position_x})
+		}
+		if tables.Position_y != nil {
+			if *tables.Position_y < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "position_y can't be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "position_y", Value: tables.Position_y})
+		}
+		if tables.Shape != nil {
+			if err := validate.Var(*tables.Shape, "eq=ROUND|eq=SQUARE|eq=RECTANGLE|eq=BOOTH"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "shape must be ROUND, SQUARE, RECTANGLE or BOOTH"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "shape", Value: tables.Shape})
+		}
+
 		tables.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: tables.Updated_at})
+
+		updateVersioned(ctx, c, tablesCollection, "table", "table_id", tablesID, updateObj)
+	}
+}
+
+// A table can't be deleted while it has open orders
+var deletableTable = deletable{
+	collection:   tablesCollection,
+	resourceType: "table",
+	idField:      "table_id",
+	references: func(ctx context.Context, id string) (string, error) {
+		open, err := tableOpenOrders(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if len(open) > 0 {
+			return "the table has open orders", nil
+		}
+		return "", nil
+	},
+}
 
-		upsert := true
+func DeleteTable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableTable, c.Param("table_id"))
+	}
+}
+
+func RestoreTable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableTable, c.Param("table_id"))
+	}
+}
 
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+// The statuses the staff can move a table to by hand, ORDERED and CHECK_DROPPED only come from its orders.
+// A table stuck with its orders, like a party leaving without paying, can still be cleared by hand.
+var tableTransitions = map[string][]string{
+	models.TableAvailable:    {models.TableReserved, models.TableSeated, models.TableDirty},
+	models.TableReserved:     {models.TableAvailable, models.TableSeated},
+	models.TableSeated:       {models.TableAvailable, models.TableDirty},
+	models.TableOrdered:      {models.TableAvailable, models.TableDirty},
+	models.TableCheckDropped: {models.TableAvailable, models.TableDirty},
+	models.TableDirty:        {models.TableAvailable},
+}
+
+// The tables from before the statuses are available
+func tableStatusOf(table models.Table) string {
+	if table.Status == nil {
+		return models.TableAvailable
+	}
+	return *table.Status
+}
+
+// The fields of a table going into a status. A party is seated from the moment the table leaves
+// AVAILABLE, RESERVED or DIRTY until it gets back to one of them.
+func tableStatusFields(table models.Table, status string, now time.Time) primitive.D {
+	seatedAt := table.Seated_at
+	switch status {
+	case models.TableSeated, models.TableOrdered, models.TableCheckDropped:
+		if seatedAt == nil {
+			seatedAt = &now
 		}
+	default:
+		seatedAt = nil
+	}
+	return primitive.D{
+		{Key: "status", Value: status},
+		{Key: "status_changed_at", Value: now},
+		{Key: "seated_at", Value: seatedAt},
+		{Key: "updated_at", Value: now},
+	}
+}
+
+// This function moves a table to the status given as {"status": "SEATED"}, with its version in If-Match
+func UpdateTableStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
-		filter := bson.M{"table_id": tablesID}
+		tableID := c.Param("table_id")
 
-		res, err := tablesCollection.UpdateOne(
-			ctx,
-			filter,
+		var body struct {
+			Status *string `json:"status" validate:"required,eq=AVAILABLE|eq=RESERVED|eq=SEATED|eq=DIRTY"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be AVAILABLE, RESERVED, SEATED or DIRTY"})
+			return
+		}
+
+		var table models.Table
+		err := tablesCollection.FindOne(ctx, bson.M{"table_id": tableID, "deleted_at": nil}).Decode(&table)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the table"})
+			return
+		}
+
+		current := tableStatusOf(table)
+		status := *body.Status
+		if !containsString(tableTransitions[current], status) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("a table can't go from %s to %s", current, status)})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateVersionedThen(ctx, c, tablesCollection, "table", "table_id", tableID, tableStatusFields(table, status, now), func(ctx context.Context) error {
+			return publishEvent(ctx, c, models.EventTableStatusChanged, "table", tablesCollection, "table_id", tableID)
+		})
+	}
+}
+
+// The orders of a table which are neither cancelled, merged into another nor paid
+func tableOpenOrders(ctx context.Context, tableID string) ([]string, error) {
+	orderIDs, err := distinctStrings(ctx, orderCollection, "order_id", bson.M{
+		"table_id":   tableID,
+		"status":     bson.M{"$nin": bson.A{models.OrderCancelled, models.OrderMerged}},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openOrderIDs(ctx, orderIDs)
+}
+
+// This function brings the status of a table in line with its orders: ORDERED while it has open orders,
+// CHECK_DROPPED once they all have an invoice waiting to be paid, then DIRTY once they are paid or moved
+// to another table (or back to SEATED when they were all cancelled). A table without orders keeps the status
+// the staff gave it.
+// It is run for every event about the orders of the table, so it only looks at how things are now.
+func refreshTableStatus(ctx context.Context, tableID string, now time.Time) error {
+	var table models.Table
+	err := tablesCollection.FindOne(ctx, bson.M{"table_id": tableID, "deleted_at": nil}).Decode(&table)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current := tableStatusOf(table)
+
+	open, err := tableOpenOrders(ctx, tableID)
+	if err != nil {
+		return err
+	}
+	var status string
+	switch {
+	case len(open) > 0:
+		dropped, err := distinctStrings(ctx, invoiceCollections, "order_id", bson.M{
+			"order_id":       bson.M{"$in": open},
+			"payment_status": "PENDING",
+			"deleted_at":     nil,
+		})
+		if err != nil {
+			return err
+		}
+		status = models.TableOrdered
+		if len(dropped) == len(open) {
+			status = models.TableCheckDropped
+		}
+	case current == models.TableOrdered || current == models.TableCheckDropped:
+		// the party is still seated when its orders were all cancelled, it left otherwise
+		seatedOrders := bson.M{"table_id": tableID, "deleted_at": nil}
+		if table.Seated_at != nil {
+			seatedOrders["created_at"] = bson.M{"$gte": *table.Seated_at}
+		}
+		orders, err := orderCollection.CountDocuments(ctx, seatedOrders)
+		if err != nil {
+			return err
+		}
+		seatedOrders["status"] = models.OrderCancelled
+		cancelled, err := orderCollection.CountDocuments(ctx, seatedOrders)
+		if err != nil {
+			return err
+		}
+		status = models.TableDirty
+		if orders > 0 && cancelled == orders {
+			status = models.TableSeated
+		}
+	default:
+		return nil
+	}
+	if status == current {
+		return nil
+	}
+
+	// the table is only changed if nobody changed it since it was read, the event is handled again otherwise
+	return unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var before bson.M
+		err := tablesCollection.FindOneAndUpdate(ctx,
+			versionFilter("table_id", tableID, table.Version),
 			bson.D{
-				{"$set", updateObj},
+				{Key: "$set", Value: tableStatusFields(table, status, now)},
+				{Key: "$inc", Value: bson.M{"version": 1}},
 			},
-			&opt,
-		)
-
+		).Decode(&before)
 		if err != nil {
-			msg := fmt.Sprintf("Error, while updating records")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return err
 		}
+		var updated bson.M
+		if err = tablesCollection.FindOne(ctx, bson.M{"table_id": tableID}).Decode(&updated); err != nil {
+			return err
+		}
+		if err = recordAudit(ctx, nil, models.AuditUpdate, "table", tableID, before, updated); err != nil {
+			return err
+		}
+		return publishEvent(ctx, nil, models.EventTableStatusChanged, "table", tablesCollection, "table_id", tableID)
+	})
+}
 
-		defer cancel()
-		c.JSON(http.StatusOK, res)
+// This function keeps the statuses of the tables in line with the events about their orders and invoices.
+// An order moved to another table changes the table it left as well.
+func followTableOrders(ctx context.Context, event events.Event) error {
+	var orderID string
+	switch event.Type {
+	case models.EventOrderCreated, models.EventOrderStatusChanged, models.EventOrderTransferred, models.EventOrderMerged, models.EventOrderSplit:
+		orderID = event.Resource_id
+	case models.EventInvoiceCreated, models.EventInvoicePaid, models.EventInvoiceRefunded:
+		orderID, _ = event.Data["order_id"].(string)
+	default:
+		return nil
+	}
+
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tableIDs := []string{}
+	if order.Table_id != nil && *order.Table_id != "" {
+		tableIDs = append(tableIDs, *order.Table_id)
+	}
+	if from, ok := event.Data["from_table_id"].(string); ok && from != "" && !containsString(tableIDs, from) {
+		tableIDs = append(tableIDs, from)
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	for _, tableID := range tableIDs {
+		if err := refreshTableStatus(ctx, tableID, now); err != nil {
+			return err
+		}
 	}
+	return nil
 }
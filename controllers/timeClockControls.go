@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var timeEntryCollection *mongo.Collection = database.OpenCollection(database.Client, "time_entries")
+
+// How early before a scheduled shift clocking in counts as working that shift
+const clockInEarly = time.Hour
+
+// What the time entries can be filtered and sorted by
+var timeEntryListSpec = helpers.ListSpec{
+	SortFields:  []string{"clock_in", "business_day", "created_at"},
+	DefaultSort: "-clock_in",
+	Filters: map[string]helpers.FilterType{
+		"user_id":      helpers.FilterString,
+		"role":         helpers.FilterString,
+		"shift_id":     helpers.FilterString,
+		"business_day": helpers.FilterString,
+		"clock_in":     helpers.FilterTime,
+	},
+}
+
+// The time entry a user is clocked in on, mongo.ErrNoDocuments when they are not clocked in
+func openTimeEntry(ctx context.Context, userID string) (models.TimeEntry, error) {
+	var entry models.TimeEntry
+	err := timeEntryCollection.FindOne(ctx, bson.M{"user_id": userID, "clock_out": nil, "deleted_at": nil}).Decode(&entry)
+	return entry, err
+}
+
+// The break going on during a time entry, -1 when there is none
+func openBreak(entry models.TimeEntry) int {
+	for i, pause := range entry.Breaks {
+		if pause.Ended_at == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// The hours worked during a time entry and the hours of its unpaid breaks, until now while it is open
+func entryHours(entry models.TimeEntry, now time.Time) (float64, float64) {
+	end := now
+	if entry.Clock_out != nil {
+		end = *entry.Clock_out
+	}
+	unpaid := time.Duration(0)
+	for _, pause := range entry.Breaks {
+		if pause.Paid || pause.Started_at == nil {
+			continue
+		}
+		pauseEnd := end
+		if pause.Ended_at != nil {
+			pauseEnd = *pause.Ended_at
+		}
+		unpaid += pauseEnd.Sub(*pause.Started_at)
+	}
+	return (end.Sub(entry.Clock_in) - unpaid).Hours(), unpaid.Hours()
+}
+
+// This function checks the times of a time entry: it ends after it starts, and its breaks are inside it
+// and don't overlap. A broken rule is a validationError.
+func checkTimeEntry(entry models.TimeEntry) error {
+	if entry.Clock_out != nil && !entry.Clock_out.After(entry.Clock_in) {
+		return validationError{"clock_out must be after clock_in"}
+	}
+	var lastEnd *time.Time
+	for i, pause := range entry.Breaks {
+		if pause.Started_at.Before(entry.Clock_in) {
+			return validationError{"a break can't start before clock_in"}
+		}
+		if lastEnd != nil && pause.Started_at.Before(*lastEnd) {
+			return validationError{"the breaks must follow each other without overlapping"}
+		}
+		if pause.Ended_at == nil {
+			if i != len(entry.Breaks)-1 || entry.Clock_out != nil {
+				return validationError{"only the last break of an open entry can be going on"}
+			}
+			continue
+		}
+		if !pause.Ended_at.After(*pause.Started_at) {
+			return validationError{"a break must end after it starts"}
+		}
+		if entry.Clock_out != nil && pause.Ended_at.After(*entry.Clock_out) {
+			return validationError{"a break can't end after clock_out"}
+		}
+		lastEnd = pause.Ended_at
+	}
+	return nil
+}
+
+func respondTimeClockError(c *gin.Context, err error) {
+	var invalid validationError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusConflict, gin.H{"error": "you are not clocked in"})
+	// the unique index of the open entries refused a second one
+	case mongo.IsDuplicateKeyError(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "you are already clocked in"})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while using the time clock"})
+	}
+}
+
+// This function tells whether the user is clocked in and on a break, with their time entry
+func GetTimeClock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entry, err := openTimeEntry(ctx, c.GetString("uid"))
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusOK, gin.H{"clocked_in": false, "on_break": false, "entry": nil})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the time clock"})
+			return
+		}
+		worked, _ := entryHours(entry, time.Now())
+		c.JSON(http.StatusOK, gin.H{"clocked_in": true, "on_break": openBreak(entry) >= 0, "hours": Tofixed(worked, 2), "entry": entry})
+	}
+}
+
+// This function clocks the user in. The entry works the shift of the schedule the user is starting (or is in),
+// in its role, or in the role of the user without a shift. The tips are shared by these roles, so only the managers
+// change them, by correcting the entry.
+func ClockIn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID := c.GetString("uid")
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		entry := models.TimeEntry{
+			ID:           primitive.NewObjectID(),
+			User_id:      userID,
+			Clock_in:     now,
+			Breaks:       []models.TimeBreak{},
+			Business_day: businessDay(now),
+			Created_at:   now,
+			Updated_at:   now,
+			Version:      1,
+		}
+		entry.Entry_id = entry.ID.Hex()
+
+		var shift models.ScheduledShift
+		err := scheduledShiftCollection.FindOne(ctx, bson.M{
+			"user_id":    userID,
+			"starts_at":  bson.M{"$lte": now.Add(clockInEarly)},
+			"ends_at":    bson.M{"$gt": now},
+			"deleted_at": nil,
+		}, options.FindOne().SetSort(bson.D{{Key: "starts_at", Value: 1}})).Decode(&shift)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the schedule"})
+			return
+		}
+		if err == nil {
+			entry.Shift_id = &shift.Shift_id
+			entry.Role = shift.Role
+		}
+		if entry.Role == nil {
+			if role := c.GetString("role"); role != "" {
+				entry.Role = &role
+			}
+		}
+
+		if _, err = insertAudited(ctx, c, timeEntryCollection, "time_entry", entry.Entry_id, entry); err != nil {
+			respondTimeClockError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// This function checks a time entry doesn't overlap another entry of its user. An open entry goes on until now
+// and after, a broken rule is a validationError.
+func checkTimeEntryOverlap(ctx context.Context, entry models.TimeEntry) error {
+	filter := bson.M{
+		"user_id":    entry.User_id,
+		"entry_id":   bson.M{"$ne": entry.Entry_id},
+		"deleted_at": nil,
+		"$or":        bson.A{bson.M{"clock_out": nil}, bson.M{"clock_out": bson.M{"$gt": entry.Clock_in}}},
+	}
+	if entry.Clock_out != nil {
+		filter["clock_in"] = bson.M{"$lt": *entry.Clock_out}
+	}
+	count, err := timeEntryCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return validationError{"the entry overlaps another entry of the user"}
+	}
+	return nil
+}
+
+// This function changes the open time entry of the user, the change gets the entry as it is and the moment
+func changeOpenTimeEntry(c *gin.Context, change func(entry *models.TimeEntry, now time.Time) error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var entry models.TimeEntry
+	err := unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if entry, err = openTimeEntry(ctx, c.GetString("uid")); err != nil {
+			return err
+		}
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		if err := change(&entry, now); err != nil {
+			return err
+		}
+		entry.Updated_at = now
+		entry.Version++
+		return updateEachAudited(ctx, c, timeEntryCollection, "time_entry", "entry_id", models.AuditUpdate,
+			bson.M{"entry_id": entry.Entry_id, "clock_out": nil},
+			bson.M{"$set": bson.M{"clock_out": entry.Clock_out, "breaks": entry.Breaks, "updated_at": now}, "$inc": bson.M{"version": 1}},
+		)
+	})
+	if err != nil {
+		respondTimeClockError(c, err)
+		return
+	}
+	helpers.SetETag(c, entry.Version)
+	c.JSON(http.StatusOK, entry)
+}
+
+// This function clocks the user out, ending the break they are on
+func ClockOut() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changeOpenTimeEntry(c, func(entry *models.TimeEntry, now time.Time) error {
+			if i := openBreak(*entry); i >= 0 {
+				entry.Breaks[i].Ended_at = &now
+			}
+			entry.Clock_out = &now
+			return nil
+		})
+	}
+}
+
+// This function starts a break, unpaid unless {"paid": true}
+func StartBreak() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Paid bool `json:"paid"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		changeOpenTimeEntry(c, func(entry *models.TimeEntry, now time.Time) error {
+			if openBreak(*entry) >= 0 {
+				return validationError{"you are already on a break"}
+			}
+			entry.Breaks = append(entry.Breaks, models.TimeBreak{Started_at: &now, Paid: body.Paid})
+			return nil
+		})
+	}
+}
+
+// This function ends the break the user is on
+func EndBreak() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changeOpenTimeEntry(c, func(entry *models.TimeEntry, now time.Time) error {
+			i := openBreak(*entry)
+			if i < 0 {
+				return validationError{"you are not on a break"}
+			}
+			entry.Breaks[i].Ended_at = &now
+			return nil
+		})
+	}
+}
+
+func GetTimeEntries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, timeEntryCollection, timeEntryListSpec, bson.M{})
+	}
+}
+
+func GetTimeEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.TimeEntry
+		err := timeEntryCollection.FindOne(ctx, withDeleted(c, bson.M{"entry_id": c.Param("entry_id")})).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "time entry not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the time entry"})
+			return
+		}
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// This function corrects a time entry: its clock_in, clock_out, role or breaks, with its version in If-Match.
+// The breaks given replace all of them, and the entry can't overlap another one of the user.
+func UpdateTimeEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entryID := c.Param("entry_id")
+
+		var body struct {
+			Role      *string            `json:"role" validate:"omitempty,min=2,max=50"`
+			Clock_in  *time.Time         `json:"clock_in"`
+			Clock_out *time.Time         `json:"clock_out"`
+			Breaks    []models.TimeBreak `json:"breaks" validate:"dive"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var entry models.TimeEntry
+		err := timeEntryCollection.FindOne(ctx, bson.M{"entry_id": entryID, "deleted_at": nil}).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "time entry not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the time entry"})
+			return
+		}
+
+		var updateObj primitive.D
+		if body.Role != nil {
+			updateObj = append(updateObj, bson.E{Key: "role", Value: body.Role})
+		}
+		if body.Clock_in != nil {
+			entry.Clock_in = *body.Clock_in
+			entry.Business_day = businessDay(entry.Clock_in)
+			updateObj = append(updateObj, bson.E{Key: "clock_in", Value: entry.Clock_in}, bson.E{Key: "business_day", Value: entry.Business_day})
+		}
+		if body.Clock_out != nil {
+			entry.Clock_out = body.Clock_out
+			updateObj = append(updateObj, bson.E{Key: "clock_out", Value: body.Clock_out})
+		}
+		if body.Breaks != nil {
+			entry.Breaks = body.Breaks
+			updateObj = append(updateObj, bson.E{Key: "breaks", Value: body.Breaks})
+		}
+		if err := checkTimeEntry(entry); err != nil {
+			respondTimeClockError(c, err)
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+		updateVersionedThen(ctx, c, timeEntryCollection, "time_entry", "entry_id", entryID, updateObj, func(ctx context.Context) error {
+			return checkTimeEntryOverlap(ctx, entry)
+		})
+	}
+}
+
+// Nothing points at a time entry, a wrong one can always be deleted
+var deletableTimeEntry = deletable{
+	collection:   timeEntryCollection,
+	resourceType: "time_entry",
+	idField:      "entry_id",
+}
+
+func DeleteTimeEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableTimeEntry, c.Param("entry_id"))
+	}
+}
+
+func RestoreTimeEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableTimeEntry, c.Param("entry_id"))
+	}
+}
@@ -0,0 +1,689 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var tipPoolRuleCollection *mongo.Collection = database.OpenCollection(database.Client, "tip_pool_rules")
+var tipDistributionCollection *mongo.Collection = database.OpenCollection(database.Client, "tip_distributions")
+
+// What the tip pool rules can be filtered and sorted by
+var tipPoolRuleListSpec = helpers.ListSpec{
+	SortFields:  []string{"name", "created_at", "updated_at"},
+	DefaultSort: "-updated_at",
+	Filters: map[string]helpers.FilterType{
+		"method":    helpers.FilterString,
+		"is_active": helpers.FilterBool,
+	},
+}
+
+// What the tip distributions can be filtered and sorted by
+var tipDistributionListSpec = helpers.ListSpec{
+	SortFields:  []string{"business_day", "calculated_at"},
+	DefaultSort: "-business_day",
+	Filters: map[string]helpers.FilterType{
+		"business_day": helpers.FilterString,
+		"status":       helpers.FilterString,
+		"rule_id":      helpers.FilterString,
+	},
+}
+
+// This function checks that the shares of a rule fit its method: the roles appear once, sharing by role weight
+// lists roles, and sharing by percentage gives every role a percentage, at most 100 in all.
+// A broken rule is a validationError.
+func checkTipPoolRule(rule models.TipPoolRule) error {
+	roles := []string{}
+	total := 0.0
+	for _, share := range rule.Shares {
+		role := strings.ToUpper(*share.Role)
+		if containsString(roles, role) {
+			return validationError{fmt.Sprintf("the role %s is listed twice", *share.Role)}
+		}
+		roles = append(roles, role)
+		if *rule.Method == models.TipPoolPercentage {
+			if share.Percentage == nil {
+				return validationError{fmt.Sprintf("the role %s needs a percentage", *share.Role)}
+			}
+			total += *share.Percentage
+		} else if share.Percentage != nil {
+			return validationError{"only the rules sharing by percentage give percentages"}
+		}
+	}
+	if *rule.Method != models.TipPoolHours && len(rule.Shares) == 0 {
+		return validationError{"the rule needs the roles taking part in the pool"}
+	}
+	if total > 100 {
+		return validationError{"the percentages come to more than 100"}
+	}
+	return nil
+}
+
+func respondTipError(c *gin.Context, err error) {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+		return
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the tips of this day were already calculated"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while sharing the tips"})
+}
+
+func GetTipPoolRules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, tipPoolRuleCollection, tipPoolRuleListSpec, bson.M{})
+	}
+}
+
+func GetTipPoolRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var rule models.TipPoolRule
+		err := tipPoolRuleCollection.FindOne(ctx, withDeleted(c, bson.M{"rule_id": c.Param("rule_id")})).Decode(&rule)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the rule"})
+			return
+		}
+		helpers.SetETag(c, rule.Version)
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// This function creates a tip pool rule, like {"name": "Floor", "method": "HOURS", "shares": [{"role": "SERVER",
+// "weight": 1}, {"role": "BUSSER", "weight": 0.5}]}
+func CreateTipPoolRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var rule models.TipPoolRule
+		if err := c.BindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkTipPoolRule(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule.ID = primitive.NewObjectID()
+		rule.Rule_id = rule.ID.Hex()
+		rule.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		rule.Updated_at = rule.Created_at
+		rule.Version = 1
+		rule.Deleted_at, rule.Deleted_by = nil, nil
+		if rule.Shares == nil {
+			rule.Shares = []models.TipPoolShare{}
+		}
+		if rule.Is_active == nil {
+			active := true
+			rule.Is_active = &active
+		}
+
+		if _, err := insertAudited(ctx, c, tipPoolRuleCollection, "tip_pool_rule", rule.Rule_id, rule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the rule"})
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// This function changes the name, the method, the shares of a rule or turns it off with is_active, with its version
+// in If-Match. The shares given replace all of them.
+func UpdateTipPoolRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		ruleID := c.Param("rule_id")
+
+		var changes models.TipPoolRule
+		if err := c.BindJSON(&changes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var rule models.TipPoolRule
+		err := tipPoolRuleCollection.FindOne(ctx, bson.M{"rule_id": ruleID, "deleted_at": nil}).Decode(&rule)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the rule"})
+			return
+		}
+
+		var updateObj primitive.D
+		if changes.Name != nil {
+			rule.Name = changes.Name
+			updateObj = append(updateObj, bson.E{Key: "name", Value: changes.Name})
+		}
+		if changes.Method != nil {
+			rule.Method = changes.Method
+			updateObj = append(updateObj, bson.E{Key: "method", Value: changes.Method})
+		}
+		if changes.Shares != nil {
+			rule.Shares = changes.Shares
+			updateObj = append(updateObj, bson.E{Key: "shares", Value: changes.Shares})
+		}
+		if changes.Is_active != nil {
+			updateObj = append(updateObj, bson.E{Key: "is_active", Value: changes.Is_active})
+		}
+		if err := validate.Struct(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkTipPoolRule(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+		updateVersioned(ctx, c, tipPoolRuleCollection, "tip_pool_rule", "rule_id", ruleID, updateObj)
+	}
+}
+
+// The distributions keep the method of their rule, so a rule can always be deleted
+var deletableTipPoolRule = deletable{
+	collection:   tipPoolRuleCollection,
+	resourceType: "tip_pool_rule",
+	idField:      "rule_id",
+}
+
+func DeleteTipPoolRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableTipPoolRule, c.Param("rule_id"))
+	}
+}
+
+func RestoreTipPoolRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableTipPoolRule, c.Param("rule_id"))
+	}
+}
+
+// The tips of the invoices paid in a business day
+func tipPool(ctx context.Context, day string) (float64, error) {
+	rows, err := aggregateRows(ctx, invoiceCollections, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"payment_status": "PAID", "business_day": day, "deleted_at": nil}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "tips": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$tip", 0}}}}}},
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	tips, _ := rows[0]["tips"].(float64)
+	return Tofixed(tips, 2), nil
+}
+
+// This function gives the employees who worked a business day, one share per role they worked in with the hours
+// they worked in it, and how many of their entries are still open (counted until now)
+func tipParticipants(ctx context.Context, day string, now time.Time) ([]models.TipShare, int, error) {
+	res, err := timeEntryCollection.Find(ctx, bson.M{"business_day": day, "deleted_at": nil})
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries []models.TimeEntry
+	if err = res.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+
+	shares := []models.TipShare{}
+	index := map[string]int{}
+	userIDs := []string{}
+	open := 0
+	for _, entry := range entries {
+		role := strings.ToUpper(stringValue(entry.Role))
+		key := entry.User_id + "\n" + role
+		if _, ok := index[key]; !ok {
+			index[key] = len(shares)
+			shares = append(shares, models.TipShare{User_id: entry.User_id, Role: role})
+		}
+		if !containsString(userIDs, entry.User_id) {
+			userIDs = append(userIDs, entry.User_id)
+		}
+		worked, _ := entryHours(entry, now)
+		shares[index[key]].Hours += worked
+		if entry.Clock_out == nil {
+			open++
+		}
+	}
+
+	names, err := userNames(ctx, userIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range shares {
+		shares[i].Name = names[shares[i].User_id]
+		shares[i].Hours = Tofixed(shares[i].Hours, 2)
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Name != shares[j].Name {
+			return shares[i].Name < shares[j].Name
+		}
+		return shares[i].Role < shares[j].Role
+	})
+	return shares, open, nil
+}
+
+// What a rule gives a role, nil when the role doesn't take part
+func ruleShareFor(rule models.TipPoolRule, role string) *models.TipPoolShare {
+	if len(rule.Shares) == 0 && *rule.Method == models.TipPoolHours {
+		return &models.TipPoolShare{}
+	}
+	for _, share := range rule.Shares {
+		if strings.EqualFold(*share.Role, role) {
+			return &share
+		}
+	}
+	return nil
+}
+
+// This function shares a pool between the employees who worked by a rule, it gives their shares and what no one
+// could take. The amounts are in cents, the cents left by the rounding go to the largest share.
+func shareTips(rule models.TipPoolRule, pool float64, participants []models.TipShare) ([]models.TipShare, float64) {
+	shares := []models.TipShare{}
+	points := []float64{}
+	for _, participant := range participants {
+		share := ruleShareFor(rule, participant.Role)
+		if share == nil {
+			continue
+		}
+		weight := 1.0
+		if share.Weight != nil {
+			weight = *share.Weight
+		}
+		switch *rule.Method {
+		case models.TipPoolRoleWeight:
+			points = append(points, weight)
+		case models.TipPoolHours:
+			points = append(points, participant.Hours*weight)
+		case models.TipPoolPercentage:
+			points = append(points, participant.Hours)
+		}
+		shares = append(shares, participant)
+	}
+
+	// the part of the pool each share is drawn from, with the points it is split by
+	amounts := make([]float64, len(shares))
+	if *rule.Method == models.TipPoolPercentage {
+		for _, roleShare := range rule.Shares {
+			total, members := 0.0, 0
+			for i := range shares {
+				if strings.EqualFold(shares[i].Role, *roleShare.Role) {
+					total += points[i]
+					members++
+				}
+			}
+			part := pool * *roleShare.Percentage / 100
+			for i := range shares {
+				if !strings.EqualFold(shares[i].Role, *roleShare.Role) {
+					continue
+				}
+				if total > 0 {
+					amounts[i] = part * points[i] / total
+				} else {
+					amounts[i] = part / float64(members)
+				}
+			}
+		}
+	} else {
+		total := 0.0
+		for _, point := range points {
+			total += point
+		}
+		for i := range shares {
+			if total > 0 {
+				amounts[i] = pool * points[i] / total
+			}
+		}
+	}
+
+	// what the shares come to before the rounding, the cents the rounding down left go to the largest share
+	wanted, distributed, largest := 0.0, 0.0, -1
+	for i := range shares {
+		wanted += amounts[i]
+		amounts[i] = math.Floor(amounts[i]*100) / 100
+		distributed += amounts[i]
+		if largest < 0 || amounts[i] > amounts[largest] {
+			largest = i
+		}
+	}
+	if largest >= 0 {
+		amounts[largest] += Tofixed(wanted, 2) - Tofixed(distributed, 2)
+	}
+
+	distributed = 0
+	for i := range shares {
+		shares[i].Calculated = Tofixed(amounts[i], 2)
+		shares[i].Amount = shares[i].Calculated
+		distributed += shares[i].Calculated
+	}
+	return shares, Tofixed(pool-distributed, 2)
+}
+
+// The sum of what the shares of a distribution come to
+func distributedAmount(shares []models.TipShare) float64 {
+	total := 0.0
+	for _, share := range shares {
+		total += share.Amount
+	}
+	return Tofixed(total, 2)
+}
+
+// This function calculates how the tips of a business day are shared, as {"business_day": "2026-01-31", "rule_id": "..."}.
+// Without a rule_id, the active rule changed last is used. The tips are those of the invoices paid that day,
+// shared between the employees who clocked in that day. Calculating a draft again keeps the adjustments of the
+// shares still there; a locked distribution can't be calculated again.
+func CalculateTipDistribution() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			Business_day *string `json:"business_day" validate:"required,datetime=2006-01-02"`
+			Rule_id      *string `json:"rule_id"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "business_day must be a date like 2026-01-31"})
+			return
+		}
+		day := *body.Business_day
+
+		var rule models.TipPoolRule
+		filter := bson.M{"is_active": true, "deleted_at": nil}
+		if body.Rule_id != nil {
+			filter = bson.M{"rule_id": *body.Rule_id, "deleted_at": nil}
+		}
+		err := tipPoolRuleCollection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}})).Decode(&rule)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no tip pool rule to share the tips with"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the rule"})
+			return
+		}
+
+		var distribution models.TipDistribution
+		calculate := func(ctx context.Context) error {
+			now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+			var current models.TipDistribution
+			err := tipDistributionCollection.FindOne(ctx, bson.M{"business_day": day, "deleted_at": nil}).Decode(&current)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return err
+			}
+			exists := err == nil
+			if exists && current.Status == models.TipDistributionLocked {
+				return validationError{fmt.Sprintf("the tips of %s are locked", day)}
+			}
+
+			pool, err := tipPool(ctx, day)
+			if err != nil {
+				return err
+			}
+			participants, open, err := tipParticipants(ctx, day, now)
+			if err != nil {
+				return err
+			}
+			shares, undistributed := shareTips(rule, pool, participants)
+			for i := range shares {
+				for _, before := range current.Shares {
+					if before.User_id == shares[i].User_id && before.Role == shares[i].Role {
+						shares[i].Adjustment, shares[i].Note = before.Adjustment, before.Note
+						shares[i].Amount = Tofixed(shares[i].Calculated+before.Adjustment, 2)
+					}
+				}
+			}
+
+			fields := bson.M{
+				"rule_id":       rule.Rule_id,
+				"method":        *rule.Method,
+				"pool":          pool,
+				"distributed":   distributedAmount(shares),
+				"undistributed": undistributed,
+				"open_entries":  open,
+				"shares":        shares,
+				"calculated_at": now,
+				"updated_at":    now,
+			}
+			if exists {
+				err = updateEachAudited(ctx, c, tipDistributionCollection, "tip_distribution", "distribution_id", models.AuditUpdate,
+					bson.M{"distribution_id": current.Distribution_id, "status": models.TipDistributionDraft},
+					bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
+				)
+				if err != nil {
+					return err
+				}
+				return tipDistributionCollection.FindOne(ctx, bson.M{"distribution_id": current.Distribution_id}).Decode(&distribution)
+			}
+
+			distribution = models.TipDistribution{
+				ID:            primitive.NewObjectID(),
+				Business_day:  day,
+				Rule_id:       rule.Rule_id,
+				Method:        *rule.Method,
+				Pool:          pool,
+				Distributed:   distributedAmount(shares),
+				Undistributed: undistributed,
+				Open_entries:  open,
+				Shares:        shares,
+				Status:        models.TipDistributionDraft,
+				Calculated_at: now,
+				Created_at:    now,
+				Updated_at:    now,
+				Version:       1,
+			}
+			distribution.Distribution_id = distribution.ID.Hex()
+			if _, err := tipDistributionCollection.InsertOne(ctx, distribution); err != nil {
+				return err
+			}
+			return recordAudit(ctx, c, models.AuditCreate, "tip_distribution", distribution.Distribution_id, nil, distribution)
+		}
+		err = unitOfWork.Do(ctx, calculate)
+		// another request calculated the day at the same time: it is already calculated, so this calculates it again
+		if mongo.IsDuplicateKeyError(err) {
+			err = unitOfWork.Do(ctx, calculate)
+		}
+		if err != nil {
+			respondTipError(c, err)
+			return
+		}
+		helpers.SetETag(c, distribution.Version)
+		c.JSON(http.StatusOK, distribution)
+	}
+}
+
+func GetTipDistributions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, tipDistributionCollection, tipDistributionListSpec, bson.M{})
+	}
+}
+
+// The distribution of the tips of a business day, answering the 404 or the 500 itself when there is none
+func findTipDistribution(ctx context.Context, c *gin.Context, day string) (models.TipDistribution, bool) {
+	var distribution models.TipDistribution
+	err := tipDistributionCollection.FindOne(ctx, bson.M{"business_day": day, "deleted_at": nil}).Decode(&distribution)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "the tips of this day were not calculated"})
+		return distribution, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the tips"})
+		return distribution, false
+	}
+	return distribution, true
+}
+
+func GetTipDistribution() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		distribution, ok := findTipDistribution(ctx, c, c.Param("business_day"))
+		if !ok {
+			return
+		}
+		helpers.SetETag(c, distribution.Version)
+		c.JSON(http.StatusOK, distribution)
+	}
+}
+
+// This function adjusts what an employee takes from the tips of a day, as {"user_id": "...", "role": "SERVER",
+// "adjustment": -5, "note": "..."} with the version of the distribution in If-Match. The adjustment is added to what
+// the rule gave them; an employee missing from the shares is added with nothing calculated.
+func AdjustTipShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			User_id    *string  `json:"user_id" validate:"required"`
+			Role       *string  `json:"role" validate:"omitempty,max=50"`
+			Adjustment *float64 `json:"adjustment" validate:"required"`
+			Note       *string  `json:"note" validate:"omitempty,max=500"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		distribution, ok := findTipDistribution(ctx, c, c.Param("business_day"))
+		if !ok {
+			return
+		}
+		if distribution.Status == models.TipDistributionLocked {
+			c.JSON(http.StatusConflict, gin.H{"error": "the tips of this day are locked"})
+			return
+		}
+
+		role := strings.ToUpper(stringValue(body.Role))
+		found := false
+		for i, share := range distribution.Shares {
+			if share.User_id == *body.User_id && share.Role == role {
+				distribution.Shares[i].Adjustment = Tofixed(*body.Adjustment, 2)
+				distribution.Shares[i].Amount = Tofixed(share.Calculated+*body.Adjustment, 2)
+				distribution.Shares[i].Note = body.Note
+				if distribution.Shares[i].Amount < 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "the adjustment would leave the employee less than nothing"})
+					return
+				}
+				found = true
+			}
+		}
+		if !found {
+			if *body.Adjustment < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the adjustment would leave the employee less than nothing"})
+				return
+			}
+			names, err := userNames(ctx, []string{*body.User_id})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the user"})
+				return
+			}
+			if _, ok := names[*body.User_id]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "user not found"})
+				return
+			}
+			distribution.Shares = append(distribution.Shares, models.TipShare{
+				User_id:    *body.User_id,
+				Name:       names[*body.User_id],
+				Role:       role,
+				Adjustment: Tofixed(*body.Adjustment, 2),
+				Amount:     Tofixed(*body.Adjustment, 2),
+				Note:       body.Note,
+			})
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "shares", Value: distribution.Shares},
+			{Key: "distributed", Value: distributedAmount(distribution.Shares)},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersioned(ctx, c, tipDistributionCollection, "tip_distribution", "distribution_id", distribution.Distribution_id, updateObj)
+	}
+}
+
+// This function locks the tips of a day once they are right, with the version of the distribution in If-Match.
+// It has to be calculated with everyone clocked out and every tip of the day, and the shares have to come to the pool.
+func LockTipDistribution() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		distribution, ok := findTipDistribution(ctx, c, c.Param("business_day"))
+		if !ok {
+			return
+		}
+		if distribution.Status == models.TipDistributionLocked {
+			c.JSON(http.StatusConflict, gin.H{"error": "the tips of this day are already locked"})
+			return
+		}
+		if distribution.Open_entries > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "some employees were still clocked in, calculate the tips again once they clocked out"})
+			return
+		}
+		if Tofixed(distribution.Distributed+distribution.Undistributed, 2) != distribution.Pool {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("the shares come to %.2f while the pool leaves %.2f to share", distribution.Distributed, Tofixed(distribution.Pool-distribution.Undistributed, 2))})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "status", Value: models.TipDistributionLocked},
+			{Key: "locked_at", Value: now},
+			{Key: "locked_by", Value: c.GetString("uid")},
+			{Key: "updated_at", Value: now},
+		}
+		// the invoices paid since the tips were calculated would be left out of the pool
+		updateVersionedThen(ctx, c, tipDistributionCollection, "tip_distribution", "distribution_id", distribution.Distribution_id, updateObj, func(ctx context.Context) error {
+			pool, err := tipPool(ctx, distribution.Business_day)
+			if err != nil {
+				return err
+			}
+			if pool != distribution.Pool {
+				return validationError{fmt.Sprintf("the pool is %.2f since the tips were calculated with %.2f, calculate them again", pool, distribution.Pool)}
+			}
+			return nil
+		})
+	}
+}
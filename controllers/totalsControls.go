@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"restaurantms/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The sales tax, as a fraction of what is charged before the tip (TAX_RATE=0.08 for 8%), by order type:
+// TAX_RATE_TAKEOUT, TAX_RATE_PICKUP... replace TAX_RATE for their type
+var taxRates map[string]float64 = readRates("TAX_RATE")
+
+// The service charge, as a fraction of the amount after discounts (SERVICE_CHARGE_RATE=0.1 for 10%, none by default),
+// by order type the same way, like SERVICE_CHARGE_RATE_DINE_IN
+var serviceChargeRates map[string]float64 = readRates("SERVICE_CHARGE_RATE")
+
+func readRates(name string) map[string]float64 {
+	base := readRate(name, 0)
+	rates := map[string]float64{}
+	for _, orderType := range models.OrderTypes {
+		rates[orderType] = readRate(name+"_"+orderType, base)
+	}
+	return rates
+}
+
+func readRate(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate >= 1 {
+		log.Fatal(name, " must be a fraction like 0.08, got ", value)
+	}
+	return rate
+}
+
+// The amounts of an invoice, with the discount lines making up its discount
+type invoiceTotals struct {
+	Subtotal       float64
+	Discount       float64
+	Service_charge float64
+	Delivery_fee   float64
+	Tax            float64
+	Tip            float64
+	Total          float64
+	Lines          []models.DiscountLine
+}
+
+// An order item as the promotions see it
+type pricedItem struct {
+	foodID string
+	menuID string
+	price  float64
+}
+
+// This function computes what is due for an invoice: the items of its order which are not deleted, minus the
+// promotions running when the order was placed, its coupons, its manual discount once approved and the loyalty points
+// it is paid with, plus the service charge and the delivery fee of its order, tax and tip.
+// The ctx decides the transaction it belongs to.
+func computeInvoiceTotals(ctx context.Context, invoice models.Invoice) (invoiceTotals, error) {
+	totals := invoiceTotals{Lines: []models.DiscountLine{}}
+
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id}).Decode(&order)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return totals, err
+	}
+	orderType := orderTypeOf(order)
+
+	// a happy hour order keeps its discount when it is paid after the happy hour
+	at := order.Order_Date
+	if at.IsZero() {
+		at = invoice.Created_at
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	items, err := pricedItems(ctx, invoice.Order_id)
+	if err != nil {
+		return totals, err
+	}
+	for _, item := range items {
+		totals.Subtotal += item.price
+	}
+	totals.Subtotal = Tofixed(totals.Subtotal, 2)
+
+	promotions, err := applicablePromotions(ctx, invoice.Coupon_codes, at)
+	if err != nil {
+		return totals, err
+	}
+	for _, promotion := range promotions {
+		amount := promotionDiscount(promotion, items)
+		totals.addLine(models.DiscountLine{
+			Kind:         *promotion.Kind,
+			Promotion_id: &promotion.Promotion_id,
+			Name:         *promotion.Name,
+			Coupon_code:  promotion.Coupon_code,
+			Amount:       amount,
+		})
+	}
+
+	if manual := invoice.Manual_discount; manual != nil && manual.Approved_by != nil {
+		amount := 0.0
+		if manual.Amount != nil {
+			amount = *manual.Amount
+		} else if manual.Percentage != nil {
+			amount = (totals.Subtotal - totals.Discount) * *manual.Percentage / 100
+		}
+		totals.addLine(models.DiscountLine{
+			Kind:        models.DiscountManual,
+			Name:        *manual.Reason,
+			Amount:      amount,
+			Approved_by: manual.Approved_by,
+		})
+	}
+
+	if invoice.Loyalty_points != nil && *invoice.Loyalty_points > 0 {
+		line, err := loyaltyLine(ctx, invoice.Customer_id, *invoice.Loyalty_points, totals.Subtotal-totals.Discount)
+		if err != nil {
+			return totals, err
+		}
+		totals.addLine(line)
+	}
+
+	tip := 0.0
+	if invoice.Tip != nil {
+		tip = *invoice.Tip
+	}
+	totals.Discount = Tofixed(totals.Discount, 2)
+	totals.Service_charge = Tofixed((totals.Subtotal-totals.Discount)*serviceChargeRates[orderType], 2)
+	if order.Delivery_fee != nil {
+		totals.Delivery_fee = Tofixed(*order.Delivery_fee, 2)
+	}
+	charged := totals.Subtotal - totals.Discount + totals.Service_charge + totals.Delivery_fee
+	totals.Tax = Tofixed(charged*taxRates[orderType], 2)
+	totals.Tip = Tofixed(tip, 2)
+	totals.Total = Tofixed(charged+totals.Tax+totals.Tip, 2)
+	return totals, nil
+}
+
+// This function adds a discount line, the discounts never take more than the subtotal
+func (totals *invoiceTotals) addLine(line models.DiscountLine) {
+	line.Amount = Tofixed(math.Min(line.Amount, totals.Subtotal-totals.Discount), 2)
+	if line.Amount <= 0 {
+		return
+	}
+	totals.Discount += line.Amount
+	totals.Lines = append(totals.Lines, line)
+}
+
+// The items of an order which are not deleted, with the menu of their food
+func pricedItems(ctx context.Context, orderID string) ([]pricedItem, error) {
+	res, err := orderItemsCollection.Find(ctx, bson.M{"order_id": orderID, "deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+	var orderItems []models.OrderItem
+	if err = res.All(ctx, &orderItems); err != nil {
+		return nil, err
+	}
+
+	foodIDs := bson.A{}
+	for _, orderItem := range orderItems {
+		if orderItem.Food_id != nil {
+			foodIDs = append(foodIDs, *orderItem.Food_id)
+		}
+	}
+	res, err = foodCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": foodIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var foods []models.Food
+	if err = res.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+	menus := map[string]string{}
+	for _, food := range foods {
+		if food.Menu_id != nil {
+			menus[food.Food_id] = *food.Menu_id
+		}
+	}
+
+	items := []pricedItem{}
+	for _, orderItem := range orderItems {
+		var item pricedItem
+		if orderItem.Food_id != nil {
+			item.foodID = *orderItem.Food_id
+			item.menuID = menus[item.foodID]
+		}
+		if orderItem.Unit_price != nil {
+			item.price = *orderItem.Unit_price
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// The promotions an invoice gets at a given moment: the active ones without a coupon code, and the ones of its coupons.
+// An unknown, expired or used up coupon is a validationError.
+func applicablePromotions(ctx context.Context, couponCodes []string, at time.Time) ([]models.Promotion, error) {
+	codes := bson.A{}
+	for _, code := range couponCodes {
+		codes = append(codes, strings.ToUpper(code))
+	}
+
+	res, err := promotionCollection.Find(ctx, bson.M{
+		"deleted_at": nil,
+		"is_active":  bson.M{"$ne": false},
+		"$or": bson.A{
+			bson.M{"coupon_code": nil},
+			bson.M{"coupon_code": bson.M{"$in": codes}},
+		},
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var promotions []models.Promotion
+	if err = res.All(ctx, &promotions); err != nil {
+		return nil, err
+	}
+
+	applicable := []models.Promotion{}
+	redeemable := map[string]bool{}
+	for _, promotion := range promotions {
+		if !promotionRunning(promotion, at) || promotionUsedUp(promotion) {
+			continue
+		}
+		if promotion.Coupon_code != nil {
+			redeemable[*promotion.Coupon_code] = true
+		}
+		applicable = append(applicable, promotion)
+	}
+	for _, code := range codes {
+		if !redeemable[code.(string)] {
+			return nil, validationError{fmt.Sprintf("the coupon %s is unknown, expired or used up", code)}
+		}
+	}
+	return applicable, nil
+}
+
+// Tells if the promotion runs at the moment: between its start and end, and during its happy hour if it has one.
+// A happy hour ending before it starts (22:00 to 02:00) goes past midnight, its hours after midnight belong to the
+// day it started.
+func promotionRunning(promotion models.Promotion, at time.Time) bool {
+	if promotion.Starts_at != nil && at.Before(*promotion.Starts_at) {
+		return false
+	}
+	if promotion.Ends_at != nil && !at.Before(*promotion.Ends_at) {
+		return false
+	}
+
+	local := at.In(businessLocation)
+	clock := local.Format("15:04")
+	wraps := promotion.Start_time != nil && promotion.End_time != nil && *promotion.End_time < *promotion.Start_time
+	if wraps {
+		if clock >= *promotion.End_time && clock < *promotion.Start_time {
+			return false
+		}
+		if clock < *promotion.End_time {
+			local = local.AddDate(0, 0, -1)
+		}
+	} else {
+		if promotion.Start_time != nil && clock < *promotion.Start_time {
+			return false
+		}
+		if promotion.End_time != nil && clock >= *promotion.End_time {
+			return false
+		}
+	}
+
+	if len(promotion.Days) > 0 {
+		today := false
+		for _, day := range promotion.Days {
+			if time.Weekday(day) == local.Weekday() {
+				today = true
+			}
+		}
+		if !today {
+			return false
+		}
+	}
+	return true
+}
+
+func promotionUsedUp(promotion models.Promotion) bool {
+	return promotion.Usage_limit != nil && promotion.Times_used >= *promotion.Usage_limit
+}
+
+// The amount a promotion takes off the items it applies to
+func promotionDiscount(promotion models.Promotion, items []pricedItem) float64 {
+	prices := []float64{}
+	for _, item := range items {
+		switch *promotion.Scope {
+		case models.PromotionScopeFoods:
+			if !containsString(promotion.Food_ids, item.foodID) {
+				continue
+			}
+		case models.PromotionScopeMenus:
+			if !containsString(promotion.Menu_ids, item.menuID) {
+				continue
+			}
+		}
+		prices = append(prices, item.price)
+	}
+
+	eligible := 0.0
+	for _, price := range prices {
+		eligible += price
+	}
+
+	value := 0.0
+	if promotion.Value != nil {
+		value = *promotion.Value
+	}
+	switch *promotion.Kind {
+	case models.PromotionPercentage:
+		return eligible * value / 100
+	case models.PromotionFixed:
+		return math.Min(value, eligible)
+	case models.PromotionBuyXGetY:
+		// the items go by groups of buy+get from the most expensive, the cheapest get items of each group are discounted
+		if promotion.Value == nil {
+			value = 100
+		}
+		buy, get := *promotion.Buy_quantity, *promotion.Get_quantity
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+		discount := 0.0
+		for start := 0; start+buy+get <= len(prices); start += buy + get {
+			for _, price := range prices[start+buy : start+buy+get] {
+				discount += price * value / 100
+			}
+		}
+		return discount
+	}
+	return 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// This function counts a use of the promotions of the discount lines, when the invoice gets paid.
+// A promotion used up in the meantime is a validationError. The ctx decides the transaction it belongs to.
+func redeemPromotions(ctx context.Context, lines []models.DiscountLine) error {
+	for _, line := range lines {
+		if line.Promotion_id == nil {
+			continue
+		}
+		res, err := promotionCollection.UpdateOne(ctx, bson.M{
+			"promotion_id": *line.Promotion_id,
+			"$or": bson.A{
+				bson.M{"usage_limit": nil},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$times_used", "$usage_limit"}}},
+			},
+		}, bson.M{"$inc": bson.M{"times_used": 1}})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return validationError{fmt.Sprintf("the promotion %s is used up", line.Name)}
+		}
+	}
+	return nil
+}
+
+// This function gives back the use of the promotions of the discount lines, when the invoice is refunded.
+// The ctx decides the transaction it belongs to.
+func releasePromotions(ctx context.Context, lines []models.DiscountLine) error {
+	for _, line := range lines {
+		if line.Promotion_id == nil {
+			continue
+		}
+		_, err := promotionCollection.UpdateOne(ctx,
+			bson.M{"promotion_id": *line.Promotion_id, "times_used": bson.M{"$gt": 0}},
+			bson.M{"$inc": bson.M{"times_used": -1}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The fields of an invoice holding its totals
+func (totals invoiceTotals) fields() bson.D {
+	return bson.D{
+		{Key: "subtotal", Value: totals.Subtotal},
+		{Key: "discount", Value: totals.Discount},
+		{Key: "discount_lines", Value: totals.Lines},
+		{Key: "service_charge", Value: totals.Service_charge},
+		{Key: "delivery_fee", Value: totals.Delivery_fee},
+		{Key: "tax", Value: totals.Tax},
+		{Key: "tip", Value: totals.Tip},
+		{Key: "total", Value: totals.Total},
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"restaurantms/database"
 	"restaurantms/helpers"
 	"restaurantms/models"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,59 +18,44 @@ import (
 
 var usersCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
+// What the user listing can be filtered and sorted by, the password and the tokens are never listed
+var userListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "first_name", "last_name", "email"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"email":      helpers.FilterString,
+		"phone":      helpers.FilterString,
+		"first_name": helpers.FilterString,
+		"last_name":  helpers.FilterString,
+		"created_at": helpers.FilterTime,
+	},
+	Projection: bson.M{"password": 0, "token": 0, "refresh_token": 0},
+}
+
 func GetUserbyID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
 		var user models.User
 		userID := c.Param("user_id")
-		err := usersCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+		err := usersCollection.FindOne(ctx, withDeleted(c, bson.M{"user_id": userID})).Decode(&user)
 		defer cancel()
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching user records"})
 			return
 		}
+		helpers.SetETag(c, user.Version)
 		c.JSON(http.StatusOK, user)
 	}
 }
 
 func GetUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		recordsPerPage, err := strconv.Atoi(c.Query("recordsPerPage"))
-		if err != nil || recordsPerPage < 1 {
-			recordsPerPage = 10
-		}
-
-		pages, err1 := strconv.Atoi(c.Query("page"))
-		if err1 != nil || pages < 1 {
-			pages = 1
-		}
-
-		startIndexes := (pages - 1) * recordsPerPage
-		startIndexes, err = strconv.Atoi(c.Query("startIndex"))
-
-		matchStage := bson.D{{"$match", bson.D{{}}}}
-		projectStage := bson.D{
-			{Key: "$project", Value: bson.D{
-				{"_id", 0},
-				{"total_count", 1},
-				{"user_items", bson.D{{"$slice", []interface{}{"$data", startIndexes, recordsPerPage}}}},
-			}}}
-
-		res, err := usersCollection.Aggregate(ctx, mongo.Pipeline{
-			matchStage, projectStage})
-
-		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while listing user items"})
-		}
-		var allUsers []bson.M
-		if err = res.All(ctx, &allUsers); err != nil {
-			log.Fatal(err)
-		}
-		c.JSON(http.StatusOK, allUsers)
+		listCollection(c, usersCollection, userListSpec, bson.M{})
 	}
 }
 
@@ -122,15 +106,21 @@ func Signup() gin.HandlerFunc {
 		user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_id = user.ID.Hex()
+		user.Version = 1
+
+		// the role can't be chosen at signup: everyone starts as a server and is promoted by a manager, the first
+		// manager being made with "restaurantms manager -email ..."
+		role := models.RoleServer
+		user.Role = &role
 
 		// generate token and refresh token(generate all token function)
-		token, refreshToken, _ := helpers.GenerateAllToken(*user.Email, *user.First_name, *user.Last_name, *&user.User_id)
+		token, refreshToken, _ := helpers.GenerateAllToken(*user.Email, *user.First_name, *user.Last_name, *&user.User_id, role)
 		user.Token = &token
 		user.Refresh_Token = &refreshToken
 
 		//if all ok, we insert user in the database
 
-		res, insertErr := usersCollection.InsertOne(ctx, user)
+		res, insertErr := insertAudited(ctx, c, usersCollection, "user", user.User_id, user)
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Can't create the user"})
 			return
@@ -153,7 +143,7 @@ func Login() gin.HandlerFunc {
 			return
 		}
 		// find a user with relevant email and check if user exists
-		err := usersCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		err := usersCollection.FindOne(ctx, bson.M{"email": user.Email, "deleted_at": nil}).Decode(&foundUser)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"Error": "User not found"})
@@ -166,7 +156,7 @@ func Login() gin.HandlerFunc {
 			return
 		}
 		// 	if ok, then generate tokens
-		token, refreshToken, _ := helpers.GenerateAllToken(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *&foundUser.User_id)
+		token, refreshToken, _ := helpers.GenerateAllToken(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *&foundUser.User_id, roleOf(foundUser))
 
 		// update token - token and refresh token
 		helpers.UpdateAllTokens(token, refreshToken, foundUser.User_id)
@@ -176,6 +166,71 @@ func Login() gin.HandlerFunc {
 	}
 }
 
+// The users created before the roles existed are servers
+func roleOf(user models.User) string {
+	if user.Role == nil {
+		return models.RoleServer
+	}
+	return *user.Role
+}
+
+// This function changes the role of a user, only managers can do it.
+// The new role counts from the user's next request, the role in their token is not used.
+func UpdateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		userID := c.Param("user_id")
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if user.Role == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role is required"})
+			return
+		}
+		if err := validate.Var(*user.Role, "eq=MANAGER|eq=SERVER|eq=DRIVER"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be MANAGER, SERVER or DRIVER"})
+			return
+		}
+
+		var updateObj primitive.D
+		updateObj = append(updateObj, bson.E{Key: "role", Value: user.Role})
+
+		user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: user.Updated_at})
+
+		updateVersioned(ctx, c, usersCollection, "user", "user_id", userID, updateObj)
+	}
+}
+
+// This function makes the user having the email a manager, for the manager command run on the server: the first
+// manager of a new deployment is made this way. The change is audited as the service's own doing.
+func PromoteManager(ctx context.Context, email string) error {
+	return unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var before bson.M
+		err := usersCollection.FindOneAndUpdate(ctx,
+			bson.M{"email": email, "deleted_at": nil},
+			bson.M{
+				"$set": bson.M{"role": models.RoleManager, "updated_at": time.Now().UTC().Truncate(time.Second)},
+				"$inc": bson.M{"version": 1},
+			},
+		).Decode(&before)
+		if err != nil {
+			return err
+		}
+		var after bson.M
+		if err = usersCollection.FindOne(ctx, bson.M{"_id": before["_id"]}).Decode(&after); err != nil {
+			return err
+		}
+		userID, _ := after["user_id"].(string)
+		return recordAudit(ctx, nil, models.AuditUpdate, "user", userID, before, after)
+	})
+}
+
 func HashPass(password string) string {
 	// This function will be used in the signup while creating user
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
@@ -196,3 +251,22 @@ func VerifyPassword(userPassword string, providePass string) (bool, string) {
 	}
 	return check, msg
 }
+
+// A deleted user can't log in anymore, nor use the tokens it already has
+var deletableUser = deletable{
+	collection:   usersCollection,
+	resourceType: "user",
+	idField:      "user_id",
+}
+
+func DeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableUser, c.Param("user_id"))
+	}
+}
+
+func RestoreUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableUser, c.Param("user_id"))
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"restaurantms/helpers"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The filter matching a (not deleted) record at the given version.
+// Records created before the version field existed are at version 0.
+func versionFilter(idField string, id string, version int64) bson.M {
+	if version == 0 {
+		return bson.M{idField: id, "deleted_at": nil, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{idField: id, "deleted_at": nil, "version": version}
+}
+
+// This function applies a PATCH with optimistic concurrency.
+// The client must send the ETag it got with If-Match: the update only happens if nobody changed the record since,
+// otherwise it gets a 412 and has to fetch the record again. Missing (or deleted) records get a 404, nothing is ever upserted.
+// On success the change is audited and the updated record is sent back with its new ETag.
+func updateVersioned(ctx context.Context, c *gin.Context, collection *mongo.Collection, resourceType string, idField string, id string, updateObj primitive.D) {
+	updateVersionedThen(ctx, c, collection, resourceType, idField, id, updateObj, nil)
+}
+
+// Same as updateVersioned, with more work done in the same transaction once the record is updated.
+// A validationError returned by the work becomes a 409.
+func updateVersionedThen(ctx context.Context, c *gin.Context, collection *mongo.Collection, resourceType string, idField string, id string, updateObj primitive.D, then func(ctx context.Context) error) {
+	updateVersionedAfter(ctx, c, collection, resourceType, idField, id, updateObj, then, nil)
+}
+
+// Same as updateVersionedThen, with afterSave run once the change is saved, for what can't be undone like a message
+// sent out: it only runs for the request which changed the record. It answers by itself when it returns false.
+func updateVersionedAfter(ctx context.Context, c *gin.Context, collection *mongo.Collection, resourceType string, idField string, id string, updateObj primitive.D, then func(ctx context.Context) error, afterSave func(updated bson.M) bool) {
+	version, err := helpers.IfMatchVersion(c)
+	if errors.Is(err, helpers.ErrMissingIfMatch) {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updated bson.M
+	err = unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var before bson.M
+		err := collection.FindOneAndUpdate(ctx,
+			versionFilter(idField, id, version),
+			bson.D{
+				{Key: "$set", Value: updateObj},
+				{Key: "$inc", Value: bson.M{"version": 1}},
+			},
+		).Decode(&before)
+		if err != nil {
+			return err
+		}
+		undoReplace(ctx, collection, before)
+		if err = ensureRecordDayOpen(ctx, resourceType, before); err != nil {
+			return err
+		}
+		if then != nil {
+			if err = then(ctx); err != nil {
+				return err
+			}
+		}
+		if err = collection.FindOne(ctx, bson.M{idField: id}).Decode(&updated); err != nil {
+			return err
+		}
+		return recordAudit(ctx, c, models.AuditUpdate, resourceType, id, before, updated)
+	})
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, countErr := collection.CountDocuments(ctx, bson.M{idField: id, "deleted_at": nil})
+		if countErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating the record"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "record not found"})
+			return
+		}
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "the record was changed by someone else, fetch it again before updating"})
+		return
+	}
+	var locked dayLockedError
+	if errors.As(err, &locked) {
+		c.JSON(http.StatusConflict, gin.H{"error": locked.Error()})
+		return
+	}
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating the record"})
+		return
+	}
+	if afterSave != nil && !afterSave(updated) {
+		return
+	}
+
+	helpers.SetETag(c, versionOf(updated))
+	c.JSON(http.StatusOK, withoutSecrets(updated))
+}
+
+// Fields which are never sent back nor copied into the audit log
+var secretFields = []string{"password", "token", "refresh_token", "secret"}
+
+func withoutSecrets(record bson.M) bson.M {
+	for _, field := range secretFields {
+		delete(record, field)
+	}
+	return record
+}
+
+// The version stored in a record read as a bson.M, 0 when it doesn't have one
+func versionOf(record bson.M) int64 {
+	switch version := record["version"].(type) {
+	case int32:
+		return int64(version)
+	case int64:
+		return version
+	}
+	return 0
+}
@@ -0,0 +1,608 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"restaurantms/database"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"restaurantms/sms"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var waitlistCollection *mongo.Collection = database.OpenCollection(database.Client, "waitlist")
+
+// The parties are told their table is ready through it
+var smsSender sms.Sender = openSMSSender()
+
+func openSMSSender() sms.Sender {
+	sender, err := sms.OpenSender()
+	if err != nil {
+		log.Fatal("Error while setting up the SMS sender: ", err)
+	}
+	return sender
+}
+
+// How long a party stays at a table when the tables have no history yet (WAITLIST_TURN_MINUTES, 60 by default),
+// how long a dirty table takes to be cleared, and how far back the history goes
+var defaultTurnMinutes float64 = readMinutes("WAITLIST_TURN_MINUTES", 60)
+
+const (
+	tableCleanupMinutes = 5
+	turnTimeHistory     = 30 * 24 * time.Hour
+)
+
+func readMinutes(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	minutes, err := strconv.ParseFloat(value, 64)
+	if err != nil || minutes <= 0 {
+		log.Fatal(name, " must be a number of minutes, got ", value)
+	}
+	return minutes
+}
+
+// What the waitlist can be filtered and sorted by
+var waitlistListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "party_size", "quoted_minutes"},
+	DefaultSort: "created_at",
+	Filters: map[string]helpers.FilterType{
+		"status":     helpers.FilterString,
+		"party_size": helpers.FilterInt,
+		"phone":      helpers.FilterString,
+		"table_id":   helpers.FilterString,
+		"created_at": helpers.FilterTime,
+	},
+}
+
+// The statuses a party can go to from each status, a notified party can be notified again
+var waitlistTransitions = map[string][]string{
+	models.WaitlistWaiting:  {models.WaitlistNotified, models.WaitlistSeated, models.WaitlistLeft},
+	models.WaitlistNotified: {models.WaitlistNotified, models.WaitlistSeated, models.WaitlistLeft},
+}
+
+// How long the parties usually stay at each table, in minutes, from their order to its payment.
+// The average over every table is returned along, or the default when there is no history.
+func turnTimes(ctx context.Context, now time.Time) (map[string]float64, float64, error) {
+	rows, err := aggregateRows(ctx, invoiceCollections, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}},
+			"paid_at":        bson.M{"$gte": now.Add(-turnTimeHistory)},
+			"deleted_at":     nil,
+		}}},
+		{{Key: "$lookup", Value: bson.M{"from": "order", "localField": "order_id", "foreignField": "order_id", "as": "order"}}},
+		{{Key: "$unwind", Value: "$order"}},
+		{{Key: "$match", Value: bson.M{"order.table_id": bson.M{"$type": "string"}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$order.table_id",
+			"minutes": bson.M{"$avg": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$paid_at", "$order.created_at"}}, 60000}}},
+			"turns":   bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	turns := map[string]float64{}
+	var total, count float64
+	for _, row := range rows {
+		tableID, _ := row["_id"].(string)
+		minutes, _ := row["minutes"].(float64)
+		n := float64(numberOf(row["turns"]))
+		turns[tableID] = minutes
+		total += minutes * n
+		count += n
+	}
+	if count == 0 {
+		return turns, defaultTurnMinutes, nil
+	}
+	return turns, total / count, nil
+}
+
+// An integer as it comes out of an aggregation
+func numberOf(value interface{}) int64 {
+	switch n := value.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// What the waits are estimated from, read once for a request: the tables and how long their parties usually stay
+type waitEstimator struct {
+	tables      []models.Table
+	turns       map[string]float64
+	averageTurn float64
+	now         time.Time
+}
+
+func newWaitEstimator(ctx context.Context, now time.Time) (waitEstimator, error) {
+	estimator := waitEstimator{now: now}
+	res, err := tablesCollection.Find(ctx, bson.M{"deleted_at": nil})
+	if err != nil {
+		return estimator, err
+	}
+	if err = res.All(ctx, &estimator.tables); err != nil {
+		return estimator, err
+	}
+	estimator.turns, estimator.averageTurn, err = turnTimes(ctx, now)
+	return estimator, err
+}
+
+// The minutes before each table seating a party of a size frees up, soonest first, and the size of the largest of
+// these tables. The tables free up in turn: an available one right away, a dirty one once cleared, a reserved one
+// after a whole turn, and an occupied one once its party stayed as long as the parties at that table usually do.
+// No table seats the party when there are none.
+func (e waitEstimator) freeIn(partySize int) ([]float64, int) {
+	largest := 0
+	freeIn := []float64{}
+	for _, table := range e.tables {
+		if table.Number_of_guests == nil || *table.Number_of_guests < partySize {
+			continue
+		}
+		if *table.Number_of_guests > largest {
+			largest = *table.Number_of_guests
+		}
+		turn, ok := e.turns[table.Table_id]
+		if !ok {
+			turn = e.averageTurn
+		}
+		switch tableStatusOf(table) {
+		case models.TableAvailable:
+			freeIn = append(freeIn, 0)
+		case models.TableDirty:
+			freeIn = append(freeIn, tableCleanupMinutes)
+		case models.TableReserved:
+			freeIn = append(freeIn, turn)
+		default:
+			seated := 0.0
+			if table.Seated_at != nil {
+				seated = e.now.Sub(*table.Seated_at).Minutes()
+			}
+			freeIn = append(freeIn, math.Max(turn-seated, tableCleanupMinutes))
+		}
+	}
+	sort.Float64s(freeIn)
+	return freeIn, largest
+}
+
+// The minutes a party waits with some parties ahead of it, which get the tables first
+func (e waitEstimator) wait(freeIn []float64, ahead int) int {
+	slot := ahead % len(freeIn)
+	rounds := ahead / len(freeIn)
+	return int(math.Ceil(freeIn[slot] + float64(rounds)*e.averageTurn))
+}
+
+// This function estimates how long a party of a size waits for a table, in minutes, and how many parties are ahead
+// of it: the ones waiting before it which the same tables seat. A party no table seats is a validationError.
+func estimateWait(ctx context.Context, partySize int, before *time.Time, now time.Time) (int, int, error) {
+	estimator, err := newWaitEstimator(ctx, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	freeIn, largest := estimator.freeIn(partySize)
+	if len(freeIn) == 0 {
+		return 0, 0, validationError{fmt.Sprintf("no table seats a party of %d", partySize)}
+	}
+
+	// the parties already waiting, or all of them for a party not on the list yet
+	waiting := bson.M{
+		"status":     bson.M{"$in": bson.A{models.WaitlistWaiting, models.WaitlistNotified}},
+		"party_size": bson.M{"$lte": largest},
+		"deleted_at": nil,
+	}
+	if before != nil {
+		waiting["created_at"] = bson.M{"$lt": *before}
+	}
+	ahead, err := waitlistCollection.CountDocuments(ctx, waiting)
+	if err != nil {
+		return 0, 0, err
+	}
+	return estimator.wait(freeIn, int(ahead)), int(ahead), nil
+}
+
+func respondWaitlistError(c *gin.Context, err error) {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.msg})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while estimating the wait"})
+}
+
+// This function tells how long a party of ?party_size= would wait if it was added now
+func EstimateWait() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		partySize, err := strconv.Atoi(c.Query("party_size"))
+		if err != nil || partySize < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "party_size must be a number of guests"})
+			return
+		}
+		minutes, ahead, err := estimateWait(ctx, partySize, nil, time.Now())
+		if err != nil {
+			respondWaitlistError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"party_size": partySize, "estimated_minutes": minutes, "parties_ahead": ahead})
+	}
+}
+
+func GetWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, waitlistCollection, waitlistListSpec, bson.M{})
+	}
+}
+
+func GetWaitlistEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.WaitlistEntry
+		err := waitlistCollection.FindOne(ctx, withDeleted(c, bson.M{"entry_id": c.Param("entry_id")})).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "waitlist entry not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the waitlist entry"})
+			return
+		}
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// This function puts a party on the waitlist with its estimated wait, which is also what it is quoted
+// unless the host quotes it something else (quoted_minutes)
+func CreateWaitlistEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.WaitlistEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if entry.Phone != nil {
+			phone := normalizePhone(*entry.Phone)
+			entry.Phone = &phone
+		}
+		if err := validate.Struct(entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		estimate, _, err := estimateWait(ctx, *entry.Party_size, nil, now)
+		if err != nil {
+			respondWaitlistError(c, err)
+			return
+		}
+
+		entry.ID = primitive.NewObjectID()
+		entry.Entry_id = entry.ID.Hex()
+		status := models.WaitlistWaiting
+		entry.Status = &status
+		entry.Estimated_minutes = &estimate
+		if entry.Quoted_minutes == nil {
+			entry.Quoted_minutes = &estimate
+		}
+		entry.Quoted_at = &now
+		entry.Notified_at, entry.Notifications, entry.Table_id, entry.Seated_at, entry.Left_at = nil, 0, nil, nil, nil
+		entry.Created_at, entry.Updated_at = now, now
+		entry.Version = 1
+		entry.Deleted_at, entry.Deleted_by = nil, nil
+
+		if _, err := insertAudited(ctx, c, waitlistCollection, "waitlist_entry", entry.Entry_id, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while adding the party to the waitlist"})
+			return
+		}
+		helpers.SetETag(c, entry.Version)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+func UpdateWaitlistEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entryID := c.Param("entry_id")
+
+		var entry models.WaitlistEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var updateObj primitive.D
+		if entry.Party_name != nil {
+			if err := validate.Var(*entry.Party_name, "min=2,max=100"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "party_name must be 2 to 100 characters"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "party_name", Value: entry.Party_name})
+		}
+		if entry.Party_size != nil {
+			if *entry.Party_size < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "party_size must be at least 1"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "party_size", Value: entry.Party_size})
+		}
+		if entry.Phone != nil {
+			phone := normalizePhone(*entry.Phone)
+			if err := validate.Var(phone, "min=6,max=20"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "phone must be 6 to 20 digits"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "phone", Value: phone})
+		}
+		if entry.Notes != nil {
+			if err := validate.Var(*entry.Notes, "max=500"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "notes can't be longer than 500 characters"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "notes", Value: entry.Notes})
+		}
+		// quoting the party again
+		if entry.Quoted_minutes != nil {
+			if *entry.Quoted_minutes < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "quoted_minutes can't be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "quoted_minutes", Value: entry.Quoted_minutes})
+			updateObj = append(updateObj, bson.E{Key: "quoted_at", Value: now})
+		}
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: now})
+
+		updateVersioned(ctx, c, waitlistCollection, "waitlist_entry", "entry_id", entryID, updateObj)
+	}
+}
+
+// This function reads a party and checks it can go to a status
+func waitlistEntryFor(ctx context.Context, c *gin.Context, entryID string, status string) (models.WaitlistEntry, bool) {
+	var entry models.WaitlistEntry
+	err := waitlistCollection.FindOne(ctx, bson.M{"entry_id": entryID, "deleted_at": nil}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "waitlist entry not found"})
+		return entry, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the waitlist entry"})
+		return entry, false
+	}
+	current := stringValue(entry.Status)
+	if !containsString(waitlistTransitions[current], status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("a party can't go from %s to %s", current, status)})
+		return entry, false
+	}
+	return entry, true
+}
+
+// This function texts the party its table is ready (or the message given as {"message": "..."}),
+// with its version in If-Match. The message goes once the party is NOTIFIED, so two hosts notifying it at once can't
+// text it twice. A message which can't be sent is recorded on the party as its notification_error.
+func NotifyWaitlistEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entryID := c.Param("entry_id")
+
+		var body struct {
+			Message *string `json:"message" validate:"omitempty,min=1,max=300"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message must be 1 to 300 characters"})
+			return
+		}
+
+		entry, ok := waitlistEntryFor(ctx, c, entryID, models.WaitlistNotified)
+		if !ok {
+			return
+		}
+		if entry.Phone == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "the party left no phone"})
+			return
+		}
+		message := fmt.Sprintf("Hi %s, your table for %d is ready. Please come to the host stand.", *entry.Party_name, *entry.Party_size)
+		if body.Message != nil {
+			message = *body.Message
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "status", Value: models.WaitlistNotified},
+			{Key: "notified_at", Value: now},
+			{Key: "notifications", Value: entry.Notifications + 1},
+			{Key: "notification_error", Value: nil},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersionedAfter(ctx, c, waitlistCollection, "waitlist_entry", "entry_id", entryID, updateObj, nil, func(updated bson.M) bool {
+			sendErr := smsSender.Send(ctx, *entry.Phone, message)
+			if sendErr == nil {
+				return true
+			}
+			log.Printf("Error while texting the party %s: %v", entryID, sendErr)
+			_, err := waitlistCollection.UpdateOne(ctx,
+				bson.M{"entry_id": entryID},
+				bson.M{"$set": bson.M{"notification_error": sendErr.Error()}, "$inc": bson.M{"version": 1}},
+			)
+			if err != nil {
+				log.Printf("Error while recording the notification error of the party %s: %v", entryID, err)
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "the party is NOTIFIED but the message couldn't be sent, notify it again"})
+			return false
+		})
+	}
+}
+
+// This function seats the party, at the table given as {"table_id": "..."} when there is one, with its version
+// in If-Match. The table has to seat the party and be AVAILABLE or RESERVED, it becomes SEATED.
+func SeatWaitlistEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entryID := c.Param("entry_id")
+
+		var body struct {
+			Table_id *string `json:"table_id"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		entry, ok := waitlistEntryFor(ctx, c, entryID, models.WaitlistSeated)
+		if !ok {
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "status", Value: models.WaitlistSeated},
+			{Key: "table_id", Value: body.Table_id},
+			{Key: "seated_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersionedThen(ctx, c, waitlistCollection, "waitlist_entry", "entry_id", entryID, updateObj, func(ctx context.Context) error {
+			if body.Table_id == nil {
+				return nil
+			}
+			return seatTable(ctx, c, *body.Table_id, *entry.Party_size, now)
+		})
+	}
+}
+
+// This function marks a table SEATED for a party, the ctx decides the transaction
+func seatTable(ctx context.Context, c *gin.Context, tableID string, partySize int, now time.Time) error {
+	var table models.Table
+	err := tablesCollection.FindOne(ctx, bson.M{"table_id": tableID, "deleted_at": nil}).Decode(&table)
+	if err == mongo.ErrNoDocuments {
+		return validationError{"table not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if *table.Number_of_guests < partySize {
+		return validationError{fmt.Sprintf("the table seats %d guests, the party is %d", *table.Number_of_guests, partySize)}
+	}
+	current := tableStatusOf(table)
+	if current != models.TableAvailable && current != models.TableReserved {
+		return validationError{fmt.Sprintf("the table is %s", current)}
+	}
+
+	fields := tableStatusFields(table, models.TableSeated, now)
+	err = updateEachAudited(ctx, c, tablesCollection, "table", "table_id", models.AuditUpdate,
+		bson.M{"table_id": tableID},
+		bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	return publishEvent(ctx, c, models.EventTableStatusChanged, "table", tablesCollection, "table_id", tableID)
+}
+
+// This function takes a party which left off the waitlist, with its version in If-Match
+func LeaveWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entryID := c.Param("entry_id")
+		if _, ok := waitlistEntryFor(ctx, c, entryID, models.WaitlistLeft); !ok {
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "status", Value: models.WaitlistLeft},
+			{Key: "left_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}
+		updateVersioned(ctx, c, waitlistCollection, "waitlist_entry", "entry_id", entryID, updateObj)
+	}
+}
+
+// The parties still waiting, in the order they came, with how long they have waited and are still expected to wait
+func GetWaitlistBoard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		res, err := waitlistCollection.Find(ctx,
+			bson.M{"status": bson.M{"$in": bson.A{models.WaitlistWaiting, models.WaitlistNotified}}, "deleted_at": nil},
+			options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the waitlist"})
+			return
+		}
+		var entries []models.WaitlistEntry
+		if err = res.All(ctx, &entries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the waitlist"})
+			return
+		}
+
+		now := time.Now()
+		estimator, err := newWaitEstimator(ctx, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while estimating the wait"})
+			return
+		}
+		board := []gin.H{}
+		for i, entry := range entries {
+			// the entries are in the order they came, the parties ahead are the earlier ones the same tables seat
+			freeIn, largest := estimator.freeIn(*entry.Party_size)
+			ahead := 0
+			for _, earlier := range entries[:i] {
+				if *earlier.Party_size <= largest {
+					ahead++
+				}
+			}
+			row := gin.H{
+				"entry":             entry,
+				"waited_minutes":    int(now.Sub(entry.Created_at).Minutes()),
+				"remaining_minutes": nil,
+				"parties_ahead":     ahead,
+				"unseatable":        len(freeIn) == 0,
+			}
+			if len(freeIn) > 0 {
+				row["remaining_minutes"] = estimator.wait(freeIn, ahead)
+			}
+			board = append(board, row)
+		}
+		c.JSON(http.StatusOK, board)
+	}
+}
@@ -0,0 +1,413 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/events"
+	"restaurantms/helpers"
+	"restaurantms/models"
+	"restaurantms/webhooks"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var webhookSubscriptionCollection *mongo.Collection = database.OpenCollection(database.Client, "webhook_subscriptions")
+var webhookDeliveryCollection *mongo.Collection = database.OpenCollection(database.Client, "webhook_deliveries")
+
+var webhookSender = webhooks.NewSender(10 * time.Second)
+
+// How the deliveries are retried: the nth failure waits webhookBackoff * 2^(n-1), at most webhookMaxBackoff,
+// and a delivery failing webhookMaxAttempts times is FAILED until someone redelivers it
+const (
+	webhookMaxAttempts = 8
+	webhookBackoff     = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+)
+
+// How often the dispatcher looks for deliveries to send, and how long a delivery it took is kept from the others
+const (
+	webhookPollInterval = 5 * time.Second
+	webhookLease        = time.Minute
+)
+
+// What the subscription listing can be filtered and sorted by, the secrets are never listed
+var webhookSubscriptionListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "updated_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"events":     helpers.FilterString,
+		"is_active":  helpers.FilterBool,
+		"created_at": helpers.FilterTime,
+	},
+	Projection: bson.M{"secret": 0},
+}
+
+// What the delivery log can be filtered and sorted by
+var webhookDeliveryListSpec = helpers.ListSpec{
+	SortFields:  []string{"created_at", "next_attempt_at"},
+	DefaultSort: "-created_at",
+	Filters: map[string]helpers.FilterType{
+		"subscription_id": helpers.FilterString,
+		"event":           helpers.FilterString,
+		"event_id":        helpers.FilterString,
+		"status":          helpers.FilterString,
+		"created_at":      helpers.FilterTime,
+	},
+}
+
+func GetWebhookSubscriptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, webhookSubscriptionCollection, webhookSubscriptionListSpec, bson.M{})
+	}
+}
+
+func GetWebhookSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var subscription models.WebhookSubscription
+		err := webhookSubscriptionCollection.FindOne(ctx, withDeleted(c, bson.M{"subscription_id": c.Param("subscription_id")})).Decode(&subscription)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the subscription"})
+			return
+		}
+		subscription.Secret = ""
+		helpers.SetETag(c, subscription.Version)
+		c.JSON(http.StatusOK, subscription)
+	}
+}
+
+// This function subscribes a URL to some events. The answer carries the secret signing the webhooks,
+// it is the only time it is shown.
+func CreateWebhookSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var subscription models.WebhookSubscription
+		if err := c.BindJSON(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validate.Struct(subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := webhooks.CheckDestination(ctx, *subscription.Url); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the subscription"})
+			return
+		}
+
+		subscription.ID = primitive.NewObjectID()
+		subscription.Subscription_id = subscription.ID.Hex()
+		subscription.Secret = hex.EncodeToString(secret)
+		subscription.Created_by = c.GetString("uid")
+		subscription.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		subscription.Updated_at = subscription.Created_at
+		subscription.Version = 1
+		subscription.Deleted_at, subscription.Deleted_by = nil, nil
+		if subscription.Is_active == nil {
+			active := true
+			subscription.Is_active = &active
+		}
+
+		if _, err := insertAudited(ctx, c, webhookSubscriptionCollection, "webhook_subscription", subscription.Subscription_id, subscription); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating the subscription"})
+			return
+		}
+		c.JSON(http.StatusOK, subscription)
+	}
+}
+
+// This function changes the URL, the events, the description of a subscription or pauses it with is_active
+func UpdateWebhookSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var subscription models.WebhookSubscription
+		if err := c.BindJSON(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if subscription.Url != nil {
+			if err := validate.Var(*subscription.Url, "url,max=500"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "url must be a URL"})
+				return
+			}
+			if err := webhooks.CheckDestination(ctx, *subscription.Url); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "url", Value: subscription.Url})
+		}
+		if subscription.Events != nil {
+			if err := validate.Var(subscription.Events, "min=1,dive,oneof=order.created order.status_changed invoice.paid reservation.created table.status_changed"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "events must list some of the webhook events"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "events", Value: subscription.Events})
+		}
+		if subscription.Description != nil {
+			if err := validate.Var(*subscription.Description, "max=200"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "description can't be longer than 200 characters"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "description", Value: subscription.Description})
+		}
+		if subscription.Is_active != nil {
+			updateObj = append(updateObj, bson.E{Key: "is_active", Value: subscription.Is_active})
+		}
+
+		subscription.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: subscription.Updated_at})
+
+		updateVersioned(ctx, c, webhookSubscriptionCollection, "webhook_subscription", "subscription_id", c.Param("subscription_id"), updateObj)
+	}
+}
+
+// The deliveries are kept with their payload, so a subscription can always be deleted
+var deletableWebhookSubscription = deletable{
+	collection:   webhookSubscriptionCollection,
+	resourceType: "webhook_subscription",
+	idField:      "subscription_id",
+}
+
+func DeleteWebhookSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleteRecord(c, deletableWebhookSubscription, c.Param("subscription_id"))
+	}
+}
+
+func RestoreWebhookSubscription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restoreRecord(c, deletableWebhookSubscription, c.Param("subscription_id"))
+	}
+}
+
+func GetWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listCollection(c, webhookDeliveryCollection, webhookDeliveryListSpec, bson.M{})
+	}
+}
+
+func GetWebhookDelivery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var delivery models.WebhookDelivery
+		err := webhookDeliveryCollection.FindOne(ctx, bson.M{"delivery_id": c.Param("delivery_id")}).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the delivery"})
+			return
+		}
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// This function sends a delivery again, as a new delivery of the same payload queued right away
+func RedeliverWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var original models.WebhookDelivery
+		err := webhookDeliveryCollection.FindOne(ctx, bson.M{"delivery_id": c.Param("delivery_id")}).Decode(&original)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the delivery"})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		delivery := newWebhookDelivery(original.Subscription_id, original.Event_id, original.Event, original.Payload, now)
+		delivery.Redelivery_of = &original.Delivery_id
+		if _, err := webhookDeliveryCollection.InsertOne(ctx, delivery); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while queuing the delivery"})
+			return
+		}
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+func newWebhookDelivery(subscriptionID string, eventID string, event string, payload string, now time.Time) models.WebhookDelivery {
+	delivery := models.WebhookDelivery{
+		ID:              primitive.NewObjectID(),
+		Subscription_id: subscriptionID,
+		Event_id:        eventID,
+		Event:           event,
+		Payload:         payload,
+		Status:          models.WebhookPending,
+		Next_attempt_at: &now,
+		Attempt_log:     []models.WebhookAttempt{},
+		Created_at:      now,
+	}
+	delivery.Delivery_id = delivery.ID.Hex()
+	return delivery
+}
+
+// This function queues an event for every active subscription to it, as a subscriber of the event bus.
+// The event is sent with the record as it was stored:
+//
+//	{"id": "...", "event": "order.created", "created_at": "...", "data": {...}}
+//
+// The bus can hand the same event again, its deliveries are only queued once.
+func queueWebhookDeliveries(ctx context.Context, event events.Event) error {
+	res, err := webhookSubscriptionCollection.Find(ctx, bson.M{"events": event.Type, "is_active": true, "deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	var subscriptions []models.WebhookSubscription
+	if err = res.All(ctx, &subscriptions); err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(gin.H{"id": event.Event_id, "event": event.Type, "created_at": event.Occurred_at, "data": event.Data})
+	if err != nil {
+		return err
+	}
+
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	deliveries := []interface{}{}
+	for _, subscription := range subscriptions {
+		delivery := newWebhookDelivery(subscription.Subscription_id, event.Event_id, event.Type, string(payload), now)
+		delivery.Delivery_id = event.Event_id + "-" + subscription.Subscription_id
+		deliveries = append(deliveries, delivery)
+	}
+	_, err = webhookDeliveryCollection.InsertMany(ctx, deliveries, options.InsertMany().SetOrdered(false))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// This function sends the queued deliveries until the ctx is done.
+// Every instance of the service runs one, a delivery is only taken by one of them at a time.
+func runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		// sending everything due before waiting again
+		for ctx.Err() == nil {
+			sent, err := dispatchWebhook(ctx)
+			if err != nil {
+				log.Print("Error while dispatching the webhooks: ", err)
+			}
+			if !sent || err != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// This function takes the next delivery due and sends it, it tells if there was one
+func dispatchWebhook(ctx context.Context) (bool, error) {
+	now := time.Now()
+	lease := now.Add(webhookLease)
+
+	var delivery models.WebhookDelivery
+	err := webhookDeliveryCollection.FindOneAndUpdate(ctx,
+		bson.M{"status": models.WebhookPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": lease}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}),
+	).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var subscription models.WebhookSubscription
+	err = webhookSubscriptionCollection.FindOne(ctx, bson.M{"subscription_id": delivery.Subscription_id, "deleted_at": nil}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments || (err == nil && subscription.Is_active != nil && !*subscription.Is_active) {
+		msg := "the subscription was deleted or paused"
+		_, err = webhookDeliveryCollection.UpdateOne(ctx,
+			bson.M{"delivery_id": delivery.Delivery_id},
+			bson.M{"$set": bson.M{"status": models.WebhookFailed, "last_error": msg, "next_attempt_at": nil}},
+		)
+		return true, err
+	}
+	if err != nil {
+		return true, err
+	}
+
+	started := time.Now()
+	statusCode, sendErr := webhookSender.Send(ctx, *subscription.Url, subscription.Secret, delivery.Event_id, delivery.Event, []byte(delivery.Payload))
+	attempt := models.WebhookAttempt{At: started.UTC(), Duration_ms: time.Since(started).Milliseconds()}
+	if statusCode != 0 {
+		attempt.Status_code = &statusCode
+	}
+	if sendErr != nil {
+		msg := sendErr.Error()
+		attempt.Error = &msg
+	}
+
+	attempts := delivery.Attempts + 1
+	set := bson.M{"attempts": attempts, "last_status_code": attempt.Status_code, "last_error": attempt.Error}
+	switch {
+	case sendErr == nil:
+		set["status"] = models.WebhookDelivered
+		set["delivered_at"] = attempt.At
+		set["next_attempt_at"] = nil
+	case attempts >= webhookMaxAttempts:
+		set["status"] = models.WebhookFailed
+		set["next_attempt_at"] = nil
+	default:
+		set["next_attempt_at"] = time.Now().Add(webhookRetryDelay(attempts))
+	}
+	_, err = webhookDeliveryCollection.UpdateOne(ctx,
+		bson.M{"delivery_id": delivery.Delivery_id},
+		bson.M{"$set": set, "$push": bson.M{"attempt_log": attempt}},
+	)
+	return true, err
+}
+
+// How long a delivery waits after its nth failure
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(webhookBackoff) * math.Pow(2, float64(attempts-1)))
+	if delay > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return delay
+}
@@ -32,9 +32,14 @@ func DBInstance() *mongo.Client {
 
 var Client *mongo.Client = DBInstance()
 
+// Getting the restaurant database itself, for the parts which need more than a single collection (like GridFS)
+func OpenDatabase(client *mongo.Client) *mongo.Database {
+	return client.Database("restaurant")
+}
+
 // Initializing the database for the restaurant, this will be accessing the database if exist, otherwise will create one.
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	var collection *mongo.Collection = (*mongo.Collection)(client.Database("restaurant").Collection(collectionName))
+	var collection *mongo.Collection = (*mongo.Collection)(OpenDatabase(client).Collection(collectionName))
 
 	return collection
 }
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemoryUnitOfWork runs the work without a mongo transaction, it is a helper for the tests of the units themselves.
+// The units run one at a time, and a unit whose work fails is rolled back by running the undo steps its writes
+// registered with OnRollback, the newest first. The writes which registered nothing stay, and most of the writes
+// of the controllers register nothing: it can't stand in for MongoUnitOfWork in front of the service.
+type MemoryUnitOfWork struct {
+	mu sync.Mutex
+}
+
+func NewMemoryUnitOfWork() *MemoryUnitOfWork {
+	return &MemoryUnitOfWork{}
+}
+
+// The undo steps of the unit running, kept in its ctx
+type undoLogKey struct{}
+
+type undoLog struct {
+	steps []func(ctx context.Context) error
+}
+
+func (u *MemoryUnitOfWork) Do(ctx context.Context, work func(ctx context.Context) error) error {
+	// a unit started inside another one is part of it, as the writes made with a mongo session ctx are
+	if _, ok := ctx.Value(undoLogKey{}).(*undoLog); ok {
+		return work(ctx)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	undo := &undoLog{}
+	err := work(context.WithValue(ctx, undoLogKey{}, undo))
+	if err == nil {
+		return nil
+	}
+	for i := len(undo.steps) - 1; i >= 0; i-- {
+		if undoErr := undo.steps[i](ctx); undoErr != nil {
+			return fmt.Errorf("%w (and the rollback failed: %v)", err, undoErr)
+		}
+	}
+	return err
+}
+
+// This function registers how to undo a write made in a unit of work. Only a MemoryUnitOfWork runs the step, when the
+// unit fails; outside of it (a mongo transaction rolls itself back) the step is dropped.
+func OnRollback(ctx context.Context, step func(ctx context.Context) error) {
+	if undo, ok := ctx.Value(undoLogKey{}).(*undoLog); ok {
+		undo.steps = append(undo.steps, step)
+	}
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryUnitOfWorkRollsBackNewestFirst(t *testing.T) {
+	unit := NewMemoryUnitOfWork()
+	failed := errors.New("failed")
+	undone := []string{}
+
+	err := unit.Do(context.Background(), func(ctx context.Context) error {
+		OnRollback(ctx, func(ctx context.Context) error { undone = append(undone, "order"); return nil })
+		OnRollback(ctx, func(ctx context.Context) error { undone = append(undone, "items"); return nil })
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the error of the work", err)
+	}
+	if want := []string{"items", "order"}; !reflect.DeepEqual(undone, want) {
+		t.Fatalf("undone %v, want %v", undone, want)
+	}
+}
+
+func TestMemoryUnitOfWorkKeepsCommittedWork(t *testing.T) {
+	unit := NewMemoryUnitOfWork()
+	undone := false
+
+	err := unit.Do(context.Background(), func(ctx context.Context) error {
+		OnRollback(ctx, func(ctx context.Context) error { undone = true; return nil })
+		return nil
+	})
+	if err != nil || undone {
+		t.Fatalf("got %v and undone %v, want the work kept", err, undone)
+	}
+}
+
+func TestMemoryUnitOfWorkNestedUnitsJoinTheOuterOne(t *testing.T) {
+	unit := NewMemoryUnitOfWork()
+	failed := errors.New("failed")
+	undone := 0
+
+	err := unit.Do(context.Background(), func(ctx context.Context) error {
+		// the inner unit succeeds, its write is still undone when the outer one fails
+		if err := unit.Do(ctx, func(ctx context.Context) error {
+			OnRollback(ctx, func(ctx context.Context) error { undone++; return nil })
+			return nil
+		}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) || undone != 1 {
+		t.Fatalf("got %v and %d undone, want the error and the inner write undone", err, undone)
+	}
+}
+
+func TestMemoryUnitOfWorkReportsAFailedRollback(t *testing.T) {
+	unit := NewMemoryUnitOfWork()
+	failed := errors.New("failed")
+
+	err := unit.Do(context.Background(), func(ctx context.Context) error {
+		OnRollback(ctx, func(ctx context.Context) error { return errors.New("gone") })
+		return failed
+	})
+	if !errors.Is(err, failed) || err.Error() == failed.Error() {
+		t.Fatalf("got %v, want the error of the work along with the one of the rollback", err)
+	}
+}
+
+func TestOnRollbackOutsideAMemoryUnitIsDropped(t *testing.T) {
+	OnRollback(context.Background(), func(ctx context.Context) error {
+		t.Fatal("the step ran outside of a unit")
+		return nil
+	})
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// UnitOfWork runs a group of writes so that either all of them happen, or none does.
+// The work gets a context which has to be passed to every read and write that belongs to the unit,
+// returning an error from the work rolls everything back. MongoUnitOfWork is the one used by the service,
+// MemoryUnitOfWork only a helper for the tests.
+type UnitOfWork interface {
+	Do(ctx context.Context, work func(ctx context.Context) error) error
+}
+
+// MongoUnitOfWork runs the work inside a multi-document transaction.
+// Transactions need mongo to run as a replica set (a single node replica set is enough).
+type MongoUnitOfWork struct {
+	client *mongo.Client
+}
+
+func NewUnitOfWork(client *mongo.Client) *MongoUnitOfWork {
+	return &MongoUnitOfWork{client: client}
+}
+
+func (u *MongoUnitOfWork) Do(ctx context.Context, work func(ctx context.Context) error) error {
+	session, err := u.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	opts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	// WithTransaction retries the work on transient errors, so the work must not have side effects outside mongo
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, work(sessionCtx)
+	}, opts)
+	return err
+}
@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JSONAdapter reads the webhooks sent in our own JSON format, signed with the shared secret:
+//
+//	{"id": "A-1042", "type": "DELIVERY", "ready_by": "2026-01-31T19:30:00Z",
+//	 "customer": {"name": "Ada", "phone": "+15550102"},
+//	 "address": {"street": "1 Main St", "city": "Springfield", "postal_code": "12345", "instructions": "ring twice"},
+//	 "delivery_fee": 2.5,
+//	 "items": [{"id": "sku-9", "name": "Burger", "quantity": 2, "size": "L", "price": 9.5}]}
+type JSONAdapter struct {
+	secret string
+}
+
+func NewJSONAdapter(secret string) *JSONAdapter {
+	return &JSONAdapter{secret: secret}
+}
+
+type jsonOrder struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	Ready_by *time.Time `json:"ready_by"`
+	Customer struct {
+		Name  string `json:"name"`
+		Phone string `json:"phone"`
+	} `json:"customer"`
+	Address *struct {
+		Street       string `json:"street"`
+		City         string `json:"city"`
+		Postal_code  string `json:"postal_code"`
+		Instructions string `json:"instructions"`
+	} `json:"address"`
+	Delivery_fee float64 `json:"delivery_fee"`
+	Items        []struct {
+		ID       string  `json:"id"`
+		Name     string  `json:"name"`
+		Quantity int     `json:"quantity"`
+		Size     string  `json:"size"`
+		Price    float64 `json:"price"`
+	} `json:"items"`
+}
+
+func (a *JSONAdapter) Verify(header http.Header, body []byte) error {
+	return VerifySignature(header, a.secret, body)
+}
+
+func (a *JSONAdapter) Parse(body []byte) (ExternalOrder, error) {
+	var payload jsonOrder
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ExternalOrder{}, err
+	}
+	if payload.ID == "" {
+		return ExternalOrder{}, errors.New("the order has no id")
+	}
+	if len(payload.Items) == 0 {
+		return ExternalOrder{}, errors.New("the order has no items")
+	}
+
+	order := ExternalOrder{
+		External_id:    payload.ID,
+		Fulfillment:    strings.ToUpper(payload.Type),
+		Customer_name:  payload.Customer.Name,
+		Customer_phone: payload.Customer.Phone,
+		Delivery_fee:   payload.Delivery_fee,
+		Promised_at:    payload.Ready_by,
+	}
+	switch order.Fulfillment {
+	case "":
+		order.Fulfillment = FulfillmentDelivery
+	case FulfillmentDelivery, FulfillmentPickup:
+	default:
+		return ExternalOrder{}, fmt.Errorf("unknown order type %s", payload.Type)
+	}
+	if payload.Address != nil {
+		order.Address = &Address{
+			Street:       payload.Address.Street,
+			City:         payload.Address.City,
+			Postal_code:  payload.Address.Postal_code,
+			Instructions: payload.Address.Instructions,
+		}
+	}
+
+	for i, item := range payload.Items {
+		if item.ID == "" {
+			return ExternalOrder{}, fmt.Errorf("item %d has no id", i)
+		}
+		if item.Quantity <= 0 {
+			item.Quantity = 1
+		}
+		order.Items = append(order.Items, ExternalItem{
+			External_item_id: item.ID,
+			Name:             item.Name,
+			Quantity:         item.Quantity,
+			Size:             strings.ToUpper(item.Size),
+			Unit_price:       item.Price,
+		})
+	}
+	return order, nil
+}
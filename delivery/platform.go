@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The order statuses the platforms are told about
+const (
+	StatusAccepted  = "ACCEPTED"
+	StatusReady     = "READY"
+	StatusPickedUp  = "PICKED_UP"
+	StatusCancelled = "CANCELLED"
+)
+
+// How the platform hands the order to the customer
+const (
+	FulfillmentDelivery = "DELIVERY"
+	FulfillmentPickup   = "PICKUP"
+)
+
+// Returned by Verify, when the webhook was not sent by the platform
+var ErrBadSignature = errors.New("invalid signature")
+
+// An order sent by a platform, once mapped onto our terms
+type ExternalOrder struct {
+	External_id    string
+	Fulfillment    string
+	Customer_name  string
+	Customer_phone string
+	Address        *Address
+	Delivery_fee   float64
+	Promised_at    *time.Time
+	Items          []ExternalItem
+}
+
+type Address struct {
+	Street       string
+	City         string
+	Postal_code  string
+	Instructions string
+}
+
+// One line of an external order, the platform knows the food by its own id
+type ExternalItem struct {
+	External_item_id string
+	Name             string
+	Quantity         int
+	Size             string
+	Unit_price       float64
+}
+
+// Adapter reads the webhooks of one delivery platform
+type Adapter interface {
+	// Verify checks the webhook was signed by the platform
+	Verify(header http.Header, body []byte) error
+	// Parse maps the body of the webhook onto an ExternalOrder
+	Parse(body []byte) (ExternalOrder, error)
+}
+
+// StatusClient tells a platform where its orders are at
+type StatusClient interface {
+	SendStatus(ctx context.Context, externalID string, status string) error
+}
+
+// A delivery platform we take orders from. Client is nil when the platform doesn't want the statuses back.
+type Platform struct {
+	Name    string
+	Adapter Adapter
+	Client  StatusClient
+}
+
+// This function sets up the platforms listed in DELIVERY_PLATFORMS (like "fakeplatform,ubereats").
+// Each one is configured by DELIVERY_<NAME>_SECRET (required), DELIVERY_<NAME>_CALLBACK_URL where the statuses
+// are sent, and DELIVERY_<NAME>_FORMAT, the shape of its webhooks ("json" by default, the only one so far).
+func OpenPlatforms() (map[string]Platform, error) {
+	platforms := map[string]Platform{}
+	for _, name := range strings.Split(os.Getenv("DELIVERY_PLATFORMS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "DELIVERY_" + strings.ToUpper(name) + "_"
+		secret := os.Getenv(prefix + "SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("%sSECRET is required", prefix)
+		}
+
+		platform := Platform{Name: name}
+		switch os.Getenv(prefix + "FORMAT") {
+		case "", "json":
+			platform.Adapter = NewJSONAdapter(secret)
+		default:
+			return nil, fmt.Errorf("unknown %sFORMAT, use json", prefix)
+		}
+		if url := os.Getenv(prefix + "CALLBACK_URL"); url != "" {
+			platform.Client = NewHTTPStatusClient(url, secret)
+		}
+		platforms[name] = platform
+	}
+	return platforms, nil
+}
@@ -0,0 +1,53 @@
+package delivery
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The headers carrying the signature of a request, both ways
+const (
+	SignatureHeader = "X-Delivery-Signature"
+	TimestampHeader = "X-Delivery-Timestamp"
+)
+
+// How old a signed request can be, so a captured one can't be replayed later
+const signatureTolerance = 5 * time.Minute
+
+// This function signs a body sent at a given unix time: the hex HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// This function puts the signature headers of a body on a request
+func SignRequest(req *http.Request, secret string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+}
+
+// This function checks the signature headers of a body, and that it was signed recently
+func VerifySignature(header http.Header, secret string, body []byte) error {
+	timestamp := header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > signatureTolerance || age < -signatureTolerance {
+		return ErrBadSignature
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(SignatureHeader))) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPStatusClient posts the statuses to the callback URL of the platform, signed like the webhooks:
+//
+//	{"id": "A-1042", "status": "READY", "at": "2026-01-31T19:24:00Z"}
+type HTTPStatusClient struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewHTTPStatusClient(url string, secret string) *HTTPStatusClient {
+	return &HTTPStatusClient{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *HTTPStatusClient) SendStatus(ctx context.Context, externalID string, status string) error {
+	body, err := json.Marshal(map[string]interface{}{"id": externalID, "status": status, "at": time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SignRequest(req, s.secret, body)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("the platform answered %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Event is something which happened to a record, published along with the change
+type Event struct {
+	Event_id      string    `json:"event_id"`
+	Type          string    `json:"type"`
+	Resource_type string    `json:"resource_type"`
+	Resource_id   string    `json:"resource_id"`
+	Data          bson.M    `json:"data"`
+	Actor_id      string    `json:"actor_id"`
+	Request_id    string    `json:"request_id"`
+	Occurred_at   time.Time `json:"occurred_at"`
+}
+
+// Handler reacts to an event. An event is handled at least once, so the handlers must be fine with getting it
+// again, and an error makes the handler get the same event again later.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus carries the events from the changes to the subscribers inside the service.
+// The events are recorded with the change, and handed to the subscribers after it is saved.
+type Bus interface {
+	// Publish records an event, the ctx decides the transaction it belongs to
+	Publish(ctx context.Context, event Event) error
+	// Subscribe registers a handler under a name, which has to stay the same from one start to the next
+	Subscribe(name string, handler Handler)
+	// Run hands the events to the subscribers until the ctx is done, and returns once the handlers are done
+	Run(ctx context.Context) error
+	// Watch follows the events of a type published from now on, by any instance of the service, until the ctx is done.
+	// Unlike the subscribers it keeps no checkpoint, what is published while nobody watches is missed.
+	Watch(ctx context.Context, eventType string) (<-chan Event, error)
+}
@@ -0,0 +1,289 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How the subscribers go through the outbox. The lease outlasts the handler, so no other instance takes the
+// subscriber over while an event is being handled. A subscriber failing an event maxAttempts times gives up on it.
+const (
+	pollInterval   = time.Second
+	handlerTimeout = time.Minute
+	leaseDuration  = 2 * handlerTimeout
+	maxBackoff     = 5 * time.Minute
+	maxAttempts    = 10
+)
+
+// An event as kept in the outbox, with the subscribers which didn't handle it yet, how many times each of them
+// failed it, and the ones which gave up on it (its dead letters) with their last error
+type outboxRecord struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Event        `bson:",inline"`
+	Pending      []string          `bson:"pending"`
+	Attempts     map[string]int    `bson:"attempts,omitempty"`
+	Dead_letters []string          `bson:"dead_letters,omitempty"`
+	Errors       map[string]string `bson:"errors,omitempty"`
+	Completed_at *time.Time        `bson:"completed_at"`
+}
+
+// MongoBus keeps the events in an outbox collection, written in the transaction of the change.
+// Every subscriber goes through the outbox in the order of the events, and is taken off an event once it handled it.
+// Its checkpoint records how far it got, and leases it to one instance of the service at a time.
+type MongoBus struct {
+	outbox      *mongo.Collection
+	checkpoints *mongo.Collection
+	owner       string
+
+	mu          sync.Mutex
+	subscribers map[string]Handler
+}
+
+func NewMongoBus(outbox *mongo.Collection, checkpoints *mongo.Collection) *MongoBus {
+	host, _ := os.Hostname()
+	return &MongoBus{
+		outbox:      outbox,
+		checkpoints: checkpoints,
+		owner:       fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+		subscribers: map[string]Handler{},
+	}
+}
+
+func (b *MongoBus) Subscribe(name string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[name] = handler
+}
+
+func (b *MongoBus) subscriberNames() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := []string{}
+	for name := range b.subscribers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *MongoBus) Publish(ctx context.Context, event Event) error {
+	record := outboxRecord{ID: primitive.NewObjectID(), Event: event, Pending: b.subscriberNames()}
+	if record.Event_id == "" {
+		record.Event_id = record.ID.Hex()
+	}
+	if record.Occurred_at.IsZero() {
+		record.Occurred_at = time.Now().UTC()
+	}
+	if len(record.Pending) == 0 {
+		record.Completed_at = &record.Occurred_at
+	}
+	_, err := b.outbox.InsertOne(ctx, record)
+	return err
+}
+
+func (b *MongoBus) Run(ctx context.Context) error {
+	b.mu.Lock()
+	subscribers := map[string]Handler{}
+	for name, handler := range b.subscribers {
+		subscribers[name] = handler
+	}
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, handler := range subscribers {
+		wg.Add(1)
+		go func(name string, handler Handler) {
+			defer wg.Done()
+			b.consume(ctx, name, handler)
+		}(name, handler)
+	}
+	wg.Wait()
+	return nil
+}
+
+// This function feeds a subscriber while this instance holds its lease, and backs off while its handler fails
+func (b *MongoBus) consume(ctx context.Context, name string, handler Handler) {
+	failures := 0
+	for {
+		wait := pollInterval
+		held, err := b.acquire(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error while taking the lease of the subscriber %s: %v", name, err)
+		}
+		if held {
+			handled, err := b.deliverNext(name, handler)
+			switch {
+			case err != nil:
+				failures++
+				wait = backoff(failures)
+				log.Printf("Error while handing an event to the subscriber %s (failure %d): %v", name, failures, err)
+			case handled:
+				failures = 0
+				wait = 0
+			default:
+				failures = 0
+			}
+		}
+
+		if ctx.Err() != nil {
+			b.release(name)
+			return
+		}
+		if wait == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			b.release(name)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func backoff(failures int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(failures))) * time.Second
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// This function takes or renews the lease of a subscriber, it tells if this instance holds it
+func (b *MongoBus) acquire(ctx context.Context, name string) (bool, error) {
+	now := time.Now()
+	_, err := b.checkpoints.UpdateOne(ctx,
+		bson.M{"subscriber": name, "$or": bson.A{
+			bson.M{"owner": b.owner},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+			bson.M{"locked_until": nil},
+		}},
+		bson.M{"$set": bson.M{"owner": b.owner, "locked_until": now.Add(leaseDuration)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// another instance holds it
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// This function gives the lease up when stopping, so another instance takes over right away
+func (b *MongoBus) release(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b.checkpoints.UpdateOne(ctx,
+		bson.M{"subscriber": name, "owner": b.owner},
+		bson.M{"$set": bson.M{"locked_until": nil}},
+	)
+}
+
+// This function hands the next event the subscriber didn't handle to it, and tells if there was one.
+// It doesn't stop on shutdown: the handler is given the time to finish. An event failing for the last time is a
+// dead letter: the subscriber is taken off it and goes on with the next one.
+func (b *MongoBus) deliverNext(name string, handler Handler) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	var record outboxRecord
+	err := b.outbox.FindOne(ctx, bson.M{"pending": name},
+		options.FindOne().SetSort(bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}}),
+	).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := handler(ctx, record.Event); err != nil {
+		attempts := record.Attempts[name] + 1
+		if attempts >= maxAttempts {
+			log.Printf("The subscriber %s gives up on the event %s after %d attempts: %v", name, record.Event_id, attempts, err)
+			return true, b.complete(ctx, name, record, bson.M{
+				"$pull": bson.M{"pending": name},
+				"$push": bson.M{"dead_letters": name},
+				"$set":  bson.M{"attempts." + name: attempts, "errors." + name: err.Error()},
+			})
+		}
+		b.outbox.UpdateOne(ctx, bson.M{"_id": record.ID}, bson.M{"$set": bson.M{"attempts." + name: attempts}})
+		b.checkpoints.UpdateOne(ctx,
+			bson.M{"subscriber": name},
+			bson.M{"$set": bson.M{"last_error": err.Error(), "failed_event_id": record.Event_id}, "$inc": bson.M{"failures": 1}},
+		)
+		return true, err
+	}
+	return true, b.complete(ctx, name, record, bson.M{"$pull": bson.M{"pending": name}})
+}
+
+// This function takes the subscriber off the event with the update, and moves its checkpoint past the event
+func (b *MongoBus) complete(ctx context.Context, name string, record outboxRecord, update bson.M) error {
+	now := time.Now().UTC()
+	if _, err := b.outbox.UpdateOne(ctx, bson.M{"_id": record.ID}, update); err != nil {
+		return err
+	}
+	if _, err := b.outbox.UpdateOne(ctx,
+		bson.M{"_id": record.ID, "pending": bson.M{"$size": 0}, "completed_at": nil},
+		bson.M{"$set": bson.M{"completed_at": now}},
+	); err != nil {
+		return err
+	}
+	_, err := b.checkpoints.UpdateOne(ctx,
+		bson.M{"subscriber": name},
+		bson.M{"$set": bson.M{
+			"last_event_id":    record.Event_id,
+			"last_occurred_at": record.Occurred_at,
+			"handled_at":       now,
+			"last_error":       nil,
+			"failed_event_id":  nil,
+			"failures":         0,
+		}},
+	)
+	return err
+}
+
+// Watch follows the inserts into the outbox through a change stream, which needs the replica set the transactions
+// already need. The channel is closed once the ctx is done or the stream fails.
+func (b *MongoBus) Watch(ctx context.Context, eventType string) (<-chan Event, error) {
+	stream, err := b.outbox.Watch(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert", "fullDocument.type": eventType}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	watched := make(chan Event)
+	go func() {
+		defer close(watched)
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument outboxRecord `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				log.Print("Error while reading a watched event: ", err)
+				continue
+			}
+			select {
+			case watched <- change.FullDocument.Event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Print("Error while watching the events: ", err)
+		}
+	}()
+	return watched, nil
+}
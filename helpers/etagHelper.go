@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Returned by IfMatchVersion when the request has no If-Match header
+	ErrMissingIfMatch = errors.New("If-Match header is required")
+	// Returned by IfMatchVersion when the If-Match header is not one of our ETags
+	ErrInvalidIfMatch = errors.New("If-Match header is not a valid ETag")
+)
+
+// The ETag of a record is its version, like "3"
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func SetETag(c *gin.Context, version int64) {
+	c.Header("ETag", ETag(version))
+}
+
+// This function reads the version the client expects to update, from the If-Match header
+func IfMatchVersion(c *gin.Context) (int64, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return 0, ErrMissingIfMatch
+	}
+	header = strings.TrimPrefix(header, "W/")
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, ErrInvalidIfMatch
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version < 0 {
+		return 0, ErrInvalidIfMatch
+	}
+	return version, nil
+}
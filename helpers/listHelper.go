@@ -0,0 +1,267 @@
+package helpers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every list endpoint accepts these, on top of its own filters
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// Returned (wrapped) when the query of a list request can't be used, the handlers answer these with a 400
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// The types a filter value can be parsed into
+type FilterType int
+
+const (
+	FilterString FilterType = iota
+	FilterInt
+	FilterFloat
+	FilterBool
+	FilterTime
+)
+
+// ListSpec describes what a list endpoint lets the client filter and sort by.
+// Filters are given as field=value or field[op]=value, op being one of eq, ne, gt, gte, lt, lte or in (comma separated).
+// Sort is given as sort=field or sort=-field for descending.
+type ListSpec struct {
+	SortFields  []string
+	DefaultSort string
+	Filters     map[string]FilterType
+	Projection  bson.M
+}
+
+// The envelope every list endpoint answers with
+type ListPage struct {
+	Items       []bson.M `json:"items"`
+	Next_cursor string   `json:"next_cursor"`
+	Total       int64    `json:"total"`
+}
+
+// What is kept inside the opaque cursor token: the sort in use and where the last page stopped
+type listCursor struct {
+	Sort  string             `bson:"s"`
+	Value interface{}        `bson:"v"`
+	Last  primitive.ObjectID `bson:"i"`
+}
+
+// These query params are not filters
+var reservedListParams = map[string]bool{"limit": true, "cursor": true, "sort": true, "include_deleted": true, "format": true}
+
+var filterParamPattern = regexp.MustCompile(`^([a-z_]+)(?:\[(eq|ne|gt|gte|lt|lte|in)\])?$`)
+
+// This function lists the collection with the filters, sort, limit and cursor found in the query string.
+// The base filter is always applied, whatever the client asks for.
+func ListCollection(ctx context.Context, c *gin.Context, collection *mongo.Collection, spec ListSpec, base bson.M) (*ListPage, error) {
+	limit := defaultListLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxListLimit {
+			return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, maxListLimit)
+		}
+		limit = parsed
+	}
+
+	sortParam := c.DefaultQuery("sort", spec.DefaultSort)
+	sortField := strings.TrimPrefix(sortParam, "-")
+	direction := 1
+	if strings.HasPrefix(sortParam, "-") {
+		direction = -1
+	}
+	if !contains(spec.SortFields, sortField) {
+		return nil, fmt.Errorf("%w: sort must be one of %s", ErrInvalidListQuery, strings.Join(spec.SortFields, ", "))
+	}
+
+	filter, err := parseListFilters(c, spec, base)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cursor only narrows down the page, the total is counted without it
+	pageFilter := filter
+	if token := c.Query("cursor"); token != "" {
+		cursor, err := decodeListCursor(token)
+		if err != nil || cursor.Sort != sortParam {
+			return nil, fmt.Errorf("%w: the cursor is invalid or was made for another sort", ErrInvalidListQuery)
+		}
+		pageFilter = bson.M{"$and": bson.A{filter, cursorFilter(sortField, direction, cursor)}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortField, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+	if spec.Projection != nil {
+		opts.SetProjection(spec.Projection)
+	}
+
+	res, err := collection.Find(ctx, pageFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+	items := []bson.M{}
+	if err = res.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	// one more than the limit is fetched, so we know if there is a next page
+	page := &ListPage{Items: items, Total: total}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := page.Items[limit-1]
+		lastID, _ := last["_id"].(primitive.ObjectID)
+		page.Next_cursor, err = encodeListCursor(listCursor{Sort: sortParam, Value: last[sortField], Last: lastID})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// This function gives the filter a list request asks for, without any paging, for the exports
+func ListFilter(c *gin.Context, spec ListSpec, base bson.M) (bson.M, error) {
+	return parseListFilters(c, spec, base)
+}
+
+func parseListFilters(c *gin.Context, spec ListSpec, base bson.M) (bson.M, error) {
+	conditions := bson.A{}
+	if len(base) > 0 {
+		conditions = append(conditions, base)
+	}
+
+	for param, values := range c.Request.URL.Query() {
+		if reservedListParams[param] {
+			continue
+		}
+		match := filterParamPattern.FindStringSubmatch(param)
+		if match == nil {
+			return nil, fmt.Errorf("%w: unknown filter %s", ErrInvalidListQuery, param)
+		}
+		field, op := match[1], match[2]
+		filterType, ok := spec.Filters[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown filter %s", ErrInvalidListQuery, param)
+		}
+		if op == "" {
+			op = "eq"
+		}
+
+		var value interface{}
+		var err error
+		if op == "in" {
+			list := bson.A{}
+			for _, part := range strings.Split(values[0], ",") {
+				parsed, err := parseFilterValue(filterType, part)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %s: %v", ErrInvalidListQuery, param, err)
+				}
+				list = append(list, parsed)
+			}
+			value = list
+		} else if value, err = parseFilterValue(filterType, values[0]); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidListQuery, param, err)
+		}
+
+		// kept as separate conditions, so created_at[gte] and created_at[lt] don't overwrite each other
+		conditions = append(conditions, bson.M{field: bson.M{"$" + op: value}})
+	}
+	if len(conditions) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+func parseFilterValue(filterType FilterType, value string) (interface{}, error) {
+	switch filterType {
+	case FilterInt:
+		return strconv.ParseInt(value, 10, 64)
+	case FilterFloat:
+		return strconv.ParseFloat(value, 64)
+	case FilterBool:
+		return strconv.ParseBool(value)
+	case FilterTime:
+		if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			return parsed, nil
+		}
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, errors.New("dates must be RFC3339 or YYYY-MM-DD")
+		}
+		return parsed, nil
+	default:
+		return value, nil
+	}
+}
+
+// Keyset pagination: everything after the last item of the previous page, in the same order.
+// The _id breaks the ties between items having the same value in the sort field. The items without a value (null or
+// missing) come first in ascending order and last in descending order, as MongoDB sorts them, and a $gt or $lt never
+// matches them, so they are asked for on their own.
+func cursorFilter(field string, direction int, cursor listCursor) bson.M {
+	op := "$gt"
+	if direction < 0 {
+		op = "$lt"
+	}
+	if field == "_id" {
+		return bson.M{"_id": bson.M{op: cursor.Last}}
+	}
+	sameValue := bson.M{field: cursor.Value, "_id": bson.M{op: cursor.Last}}
+	if cursor.Value == nil {
+		if direction < 0 {
+			return sameValue
+		}
+		return bson.M{"$or": bson.A{sameValue, bson.M{field: bson.M{"$ne": nil}}}}
+	}
+	after := bson.A{bson.M{field: bson.M{op: cursor.Value}}, sameValue}
+	if direction < 0 {
+		after = append(after, bson.M{field: nil})
+	}
+	return bson.M{"$or": after}
+}
+
+func encodeListCursor(cursor listCursor) (string, error) {
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeListCursor(token string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, err
+	}
+	err = bson.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
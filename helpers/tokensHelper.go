@@ -12,7 +12,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // This function will be generating tokens
@@ -22,21 +21,23 @@ type SignedDetails struct {
 	First_name string
 	Last_name  string
 	Uid        string
+	Role       string
 	jwt.StandardClaims
 }
 
 // Connection to the user data instance
-var userCollection *mongo.Collection = database.OpenCollection(database.Client, "users")
+var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
 // Generating the tokens
-func GenerateAllToken(email string, firstname string, lastname string, uid string) (signedtoken string, signedRefreshedToken string, err error) {
+func GenerateAllToken(email string, firstname string, lastname string, uid string, role string) (signedtoken string, signedRefreshedToken string, err error) {
 	claims := &SignedDetails{
 		Email:      email,
 		First_name: firstname,
 		Last_name:  lastname,
 		Uid:        uid,
+		Role:       role,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * 1).Unix(),
 		}}
@@ -69,18 +70,15 @@ func UpdateAllTokens(signedToken string, signedRefreshToken string, userId strin
 
 	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	updateObj = append(updateObj, bson.E{"updated_at", Updated_at})
-	upsert := true
+	// the user always exists here, it was just found by the login.
+	// New tokens don't change the user, so its version stays and the ETags the clients hold remain valid.
 	filter := bson.M{"user_id": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
 
 	_, err := userCollection.UpdateOne(ctx,
 		filter,
 		bson.D{
 			{"$set", updateObj},
 		},
-		&opt,
 	)
 	defer cancel()
 	if err != nil {
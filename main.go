@@ -1,9 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"restaurantms/controllers"
 	"restaurantms/database"
 	"restaurantms/middleware"
+	"restaurantms/migrations"
 	"restaurantms/routes"
 
 	"github.com/gin-gonic/gin"
@@ -17,9 +28,31 @@ func main() {
 	if port == "" {
 		port = "8000"
 	}
+
+	// "restaurantms migrate ..." only runs the migrations, without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+	// "restaurantms manager -email ..." makes a user a manager, the first one of a new deployment is made this way
+	if len(os.Args) > 1 && os.Args[1] == "manager" {
+		os.Exit(managerCommand(os.Args[2:]))
+	}
+
+	// the indexes and validators are kept up to date at startup, unless told otherwise
+	if os.Getenv("MIGRATE_ON_STARTUP") != "false" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := migrations.Up(ctx, database.OpenDatabase(database.Client), false); err != nil {
+			log.Fatal("Error while migrating the database: ", err)
+		}
+		cancel()
+	}
+
 	router := gin.New()
 	router.Use(gin.Logger())
+	router.Use(middleware.RequestID())
 	routes.UserRoutes(router)
+	routes.AssetRoutes(router)
+	routes.DeliveryWebhookRoutes(router)
 	router.Use(middleware.Authentication())
 
 	routes.FoodRoutes(router)
@@ -28,6 +61,42 @@ func main() {
 	routes.OrderRoutes(router)
 	routes.OrderItemsRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.AuditRoutes(router)
+	routes.ReportRoutes(router)
+	routes.DrawerRoutes(router)
+	routes.BusinessDayRoutes(router)
+	routes.PromotionRoutes(router)
+	routes.CustomerRoutes(router)
+	routes.GiftCardRoutes(router)
+	routes.DeliveryRoutes(router)
+	routes.WebhookRoutes(router)
+
+	routes.EventRoutes(router)
+	routes.WaitlistRoutes(router)
+	routes.SectionRoutes(router)
+	routes.TimeClockRoutes(router)
+	routes.TipRoutes(router)
+
+	// the event bus and the webhooks run in the background until the server is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	workers := controllers.StartWorkers(ctx)
 
-	router.Run(":" + port)
+	// the requests get the ctx of the server, so the streams end once it is stopped
+	server := &http.Server{Addr: ":" + port, Handler: router, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Error while serving: ", err)
+		}
+	}()
+
+	// once stopped, the requests running are finished and the workers are left to hand their events over
+	<-ctx.Done()
+	log.Print("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Print("Error while shutting down the server: ", err)
+	}
+	workers.Wait()
 }
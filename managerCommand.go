@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"restaurantms/controllers"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// This function runs the manager subcommand, which makes a user who signed up a manager
+// Usage: restaurantms manager -email <email>
+func managerCommand(args []string) int {
+	flags := flag.NewFlagSet("manager", flag.ContinueOnError)
+	email := flags.String("email", "", "the email the user signed up with")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" {
+		log.Println("the email of the user is required, use -email")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := controllers.PromoteManager(ctx, *email)
+	if err == mongo.ErrNoDocuments {
+		log.Println("Error: no user signed up with", *email)
+		return 1
+	}
+	if err != nil {
+		log.Println("Error:", err)
+		return 1
+	}
+	log.Println(*email, "is a manager")
+	return 0
+}
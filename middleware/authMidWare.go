@@ -1,12 +1,21 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"restaurantms/database"
 	"restaurantms/helpers"
+	"restaurantms/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
+
 func Authentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clientToken := c.Request.Header.Get("token")
@@ -22,14 +31,53 @@ func Authentication() gin.HandlerFunc {
 			return
 		}
 
+		// the token outlives the user: a deleted user is turned away even with a token that hasn't expired yet,
+		// and the role is the one the user has now, not the one it had when the token was made
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var user bson.M
+		findErr := userCollection.FindOne(ctx,
+			bson.M{"user_id": claims.Uid, "deleted_at": nil},
+			options.FindOne().SetProjection(bson.M{"role": 1}),
+		).Decode(&user)
+		if findErr == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "this user doesn't exist anymore"})
+			c.Abort()
+			return
+		}
+		if findErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the user"})
+			c.Abort()
+			return
+		}
+
 		// setting all the data
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid", claims.Uid)
+		c.Set("role", models.RoleServer)
+		if role, ok := user["role"].(string); ok {
+			c.Set("role", role)
+		}
 
 		// autoverification
 		c.Next()
 
 	}
 }
+
+// RequireRole only lets through the users having one of the roles, it goes after Authentication
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to do this"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"restaurantms/database"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var timeEntryCollection *mongo.Collection = database.OpenCollection(database.Client, "time_entries")
+
+// RequireClockIn only lets through the users who are clocked in, it goes after Authentication
+func RequireClockIn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, err := timeEntryCollection.CountDocuments(ctx, bson.M{"user_id": c.GetString("uid"), "clock_out": nil, "deleted_at": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while reading the time clock"})
+			c.Abort()
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "clock in before taking orders"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
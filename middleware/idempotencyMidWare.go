@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"restaurantms/database"
+	"restaurantms/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	idempotencyInProgress = "IN_PROGRESS"
+	idempotencyCompleted  = "COMPLETED"
+)
+
+// How long a request holds its key while running, longer than the 100 seconds the handlers are given.
+// A key still IN_PROGRESS after that belongs to a request which died, a retry takes it over.
+const idempotencyLease = 2 * time.Minute
+
+// The keys expire through a TTL index on created_at (see the migrations)
+var idempotencyCollection *mongo.Collection = database.OpenCollection(database.Client, "idempotency_keys")
+
+// Keeps a copy of everything written to the response, so it can be replayed
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotency makes retried requests safe. When the client sends an Idempotency-Key header,
+// the first response is stored and sent back again for every retry with the same key and body.
+// Reusing the key with a different body is refused. Requests without the header go through as usual.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "couldn't read the request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// the same key of two different users are two different keys
+		scopeKey := c.GetString("uid") + ":" + key
+		sum := sha256.Sum256([]byte(c.Request.Method + "\n" + c.FullPath() + "\n" + string(body)))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		now := time.Now().UTC()
+		lockedUntil := now.Add(idempotencyLease)
+		record := models.IdempotencyKey{
+			ID:           primitive.NewObjectID(),
+			Scope_key:    scopeKey,
+			Fingerprint:  fingerprint,
+			Status:       idempotencyInProgress,
+			Lease:        primitive.NewObjectID().Hex(),
+			Locked_until: &lockedUntil,
+			Created_at:   now,
+		}
+		_, err = idempotencyCollection.InsertOne(ctx, record)
+		if mongo.IsDuplicateKeyError(err) {
+			var taken bool
+			if taken, err = takeOverIdempotencyKey(ctx, &record); err == nil && !taken {
+				replayIdempotentResponse(ctx, c, scopeKey, fingerprint)
+				return
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while checking the Idempotency-Key"})
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// server errors are not kept, the client is expected to retry them.
+		// The lease is part of the filter: a request which outlived it leaves the key to the one which took it over.
+		held := bson.M{"_id": record.ID, "lease": record.Lease}
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if _, err = idempotencyCollection.DeleteOne(ctx, held); err != nil {
+				log.Println("Error while releasing the Idempotency-Key:", err)
+			}
+			return
+		}
+		res, err := idempotencyCollection.UpdateOne(ctx,
+			held,
+			bson.M{"$set": bson.M{
+				"status":          idempotencyCompleted,
+				"response_status": status,
+				"response_body":   recorder.body.Bytes(),
+				"content_type":    recorder.Header().Get("Content-Type"),
+				"locked_until":    nil,
+			}},
+		)
+		if err != nil {
+			log.Println("Error while storing the idempotent response:", err)
+		} else if res.MatchedCount == 0 {
+			log.Println("The Idempotency-Key", key, "was taken over by a retry before its response was stored")
+		}
+	}
+}
+
+// This function takes over a key left IN_PROGRESS past its lease by a request which never finished, for the same
+// request. The record gets the id of the key and a fresh lease; it tells if the key was taken over.
+func takeOverIdempotencyKey(ctx context.Context, record *models.IdempotencyKey) (bool, error) {
+	var existing models.IdempotencyKey
+	err := idempotencyCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"scope_key":   record.Scope_key,
+			"fingerprint": record.Fingerprint,
+			"status":      idempotencyInProgress,
+			"$or": bson.A{
+				bson.M{"locked_until": bson.M{"$lt": record.Created_at}},
+				bson.M{"locked_until": nil},
+			},
+		},
+		bson.M{"$set": bson.M{"lease": record.Lease, "locked_until": record.Locked_until, "created_at": record.Created_at}},
+	).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	record.ID = existing.ID
+	return true, nil
+}
+
+func replayIdempotentResponse(ctx context.Context, c *gin.Context, scopeKey string, fingerprint string) {
+	defer c.Abort()
+
+	var existing models.IdempotencyKey
+	if err := idempotencyCollection.FindOne(ctx, bson.M{"scope_key": scopeKey}).Decode(&existing); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is being processed, retry later"})
+		return
+	}
+	if existing.Fingerprint != fingerprint {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "this Idempotency-Key was already used for a different request"})
+		return
+	}
+	if existing.Status != idempotencyCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is being processed, retry later"})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(existing.Response_status, existing.Content_type, existing.Response_body)
+}
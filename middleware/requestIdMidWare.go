@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestID gives every request an id, sent back in the X-Request-ID header and kept in the audit log.
+// An id sent by the client (or a proxy in front of us) is kept, so the logs can be matched.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 128 {
+			random := make([]byte, 16)
+			rand.Read(random)
+			requestID = hex.EncodeToString(random)
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
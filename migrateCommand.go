@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"restaurantms/database"
+	"restaurantms/migrations"
+	"strings"
+	"time"
+)
+
+// This function runs the migrate subcommand
+// Usage: restaurantms migrate [up|down|status] [-dry-run] [-steps N]
+func migrateCommand(args []string) int {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print what would be done")
+	steps := flags.Int("steps", 1, "how many migrations to roll back with down")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	db := database.OpenDatabase(database.Client)
+
+	var err error
+	switch action {
+	case "up":
+		err = migrations.Up(ctx, db, *dryRun)
+	case "down":
+		err = migrations.Down(ctx, db, *steps, *dryRun)
+	case "status":
+		var status []migrations.MigrationStatus
+		status, err = migrations.Status(ctx, db)
+		for _, migration := range status {
+			applied := "pending"
+			if migration.Applied {
+				applied = "applied " + migration.Applied_at.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-45s %s\n", migration.Version, migration.Name, applied)
+		}
+	default:
+		log.Println("unknown migrate action, use up, down or status")
+		return 2
+	}
+
+	if err != nil {
+		log.Println("Error:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The ids every controller looks records up by, and the lookups done while signing up
+var initialIndexes = []index{
+	{collection: "user", name: "user_id_unique", keys: bson.D{{Key: "user_id", Value: 1}}, unique: true},
+	{collection: "user", name: "email_unique", keys: bson.D{{Key: "email", Value: 1}}, unique: true},
+	{collection: "user", name: "phone_unique", keys: bson.D{{Key: "phone", Value: 1}}, unique: true},
+	{collection: "food", name: "food_id_unique", keys: bson.D{{Key: "food_id", Value: 1}}, unique: true},
+	{collection: "food", name: "menu_id", keys: bson.D{{Key: "menu_id", Value: 1}, {Key: "name", Value: 1}}},
+	{collection: "menu", name: "menu_id_unique", keys: bson.D{{Key: "menu_id", Value: 1}}, unique: true},
+	{collection: "tables", name: "table_id_unique", keys: bson.D{{Key: "table_id", Value: 1}}, unique: true},
+	{collection: "order", name: "order_id_unique", keys: bson.D{{Key: "order_id", Value: 1}}, unique: true},
+	{collection: "order", name: "table_id_created_at", keys: bson.D{{Key: "table_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	{collection: "orderItem", name: "order_item_id_unique", keys: bson.D{{Key: "order_item_id", Value: 1}}, unique: true},
+	{collection: "orderItem", name: "order_id_food_id", keys: bson.D{{Key: "order_id", Value: 1}, {Key: "food_id", Value: 1}}},
+	{collection: "orderItem", name: "food_id", keys: bson.D{{Key: "food_id", Value: 1}}},
+	{collection: "Invoice", name: "invoice_id_unique", keys: bson.D{{Key: "invoice_id", Value: 1}}, unique: true},
+	{collection: "Invoice", name: "order_id", keys: bson.D{{Key: "order_id", Value: 1}}},
+	{collection: "assets", name: "asset_id_unique", keys: bson.D{{Key: "asset_id", Value: 1}}, unique: true},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "unique and lookup indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, initialIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, initialIndexes)
+		},
+	})
+}
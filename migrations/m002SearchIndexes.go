@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The text indexes used by the food search, a collection can only have one of them
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "text indexes for the food search",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("food").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+				Options: options.Index().SetName("food_text").SetWeights(bson.M{"name": 10, "description": 2}),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection("menu").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "category", Value: "text"}},
+				Options: options.Index().SetName("menu_text"),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, []index{
+				{collection: "food", name: "food_text"},
+				{collection: "menu", name: "menu_text"},
+			})
+		},
+	})
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The bson types used in the schemas below
+var (
+	numberType         = bson.A{"double", "int", "long", "decimal"}
+	nullableStringType = bson.A{"string", "null"}
+	nullableDateType   = bson.A{"date", "null"}
+)
+
+// The shape of the documents written by the controllers, only the fields we rely on are checked
+var collectionSchemas = map[string]bson.M{
+	"user": {
+		"bsonType": "object",
+		"required": bson.A{"user_id", "email", "password"},
+		"properties": bson.M{
+			"user_id":  bson.M{"bsonType": "string"},
+			"email":    bson.M{"bsonType": "string"},
+			"password": bson.M{"bsonType": "string"},
+			"phone":    bson.M{"bsonType": nullableStringType},
+		},
+	},
+	"menu": {
+		"bsonType": "object",
+		"required": bson.A{"menu_id", "name", "category"},
+		"properties": bson.M{
+			"menu_id":    bson.M{"bsonType": "string"},
+			"name":       bson.M{"bsonType": "string"},
+			"category":   bson.M{"bsonType": "string"},
+			"start_date": bson.M{"bsonType": nullableDateType},
+			"end_date":   bson.M{"bsonType": nullableDateType},
+		},
+	},
+	"food": {
+		"bsonType": "object",
+		"required": bson.A{"food_id", "name", "price", "menu_id"},
+		"properties": bson.M{
+			"food_id":    bson.M{"bsonType": "string"},
+			"name":       bson.M{"bsonType": "string"},
+			"price":      bson.M{"bsonType": numberType, "minimum": 0},
+			"menu_id":    bson.M{"bsonType": "string"},
+			"food_image": bson.M{"bsonType": nullableStringType},
+		},
+	},
+	"tables": {
+		"bsonType": "object",
+		"required": bson.A{"table_id", "number_of_guests", "table_number"},
+		"properties": bson.M{
+			"table_id":         bson.M{"bsonType": "string"},
+			"number_of_guests": bson.M{"bsonType": numberType, "minimum": 1},
+			"table_number":     bson.M{"bsonType": numberType},
+		},
+	},
+	"order": {
+		"bsonType": "object",
+		"required": bson.A{"order_id", "order_date"},
+		"properties": bson.M{
+			"order_id":   bson.M{"bsonType": "string"},
+			"order_date": bson.M{"bsonType": "date"},
+			"table_id":   bson.M{"bsonType": nullableStringType},
+		},
+	},
+	"orderItem": {
+		"bsonType": "object",
+		"required": bson.A{"order_item_id", "order_id", "food_id", "unit_price"},
+		"properties": bson.M{
+			"order_item_id": bson.M{"bsonType": "string"},
+			"order_id":      bson.M{"bsonType": "string"},
+			"food_id":       bson.M{"bsonType": "string"},
+			"unit_price":    bson.M{"bsonType": numberType, "minimum": 0},
+			"quantity":      bson.M{"enum": bson.A{"S", "M", "L"}},
+		},
+	},
+	"Invoice": {
+		"bsonType": "object",
+		"required": bson.A{"invoice_id", "order_id", "payment_status"},
+		"properties": bson.M{
+			"invoice_id":     bson.M{"bsonType": "string"},
+			"order_id":       bson.M{"bsonType": "string"},
+			"payment_method": bson.M{"enum": bson.A{"CARD", "CASH", "", nil}},
+			"payment_status": bson.M{"enum": bson.A{"PENDING", "PAID"}},
+		},
+	},
+}
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "JSON schema validators",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for collection, schema := range collectionSchemas {
+				if err := setValidator(ctx, db, collection, schema); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for collection := range collectionSchemas {
+				if err := setValidator(ctx, db, collection, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
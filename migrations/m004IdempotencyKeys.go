@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The Idempotency-Key records are unique per user and key, and forgotten after a day
+var idempotencyIndexes = []index{
+	{collection: "idempotency_keys", name: "scope_key_unique", keys: bson.D{{Key: "scope_key", Value: 1}}, unique: true},
+	{collection: "idempotency_keys", name: "created_at_ttl", keys: bson.D{{Key: "created_at", Value: 1}}, expireAfter: 24 * 60 * 60},
+}
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "idempotency keys",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, idempotencyIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, idempotencyIndexes)
+		},
+	})
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The audit log is searched by record, by actor and by request, always newest first
+var auditIndexes = []index{
+	{collection: "audit_log", name: "audit_id_unique", keys: bson.D{{Key: "audit_id", Value: 1}}, unique: true},
+	{collection: "audit_log", name: "created_at", keys: bson.D{{Key: "created_at", Value: -1}}},
+	{collection: "audit_log", name: "resource_created_at", keys: bson.D{{Key: "resource_type", Value: 1}, {Key: "resource_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	{collection: "audit_log", name: "actor_created_at", keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	{collection: "audit_log", name: "request_id", keys: bson.D{{Key: "request_id", Value: 1}}},
+}
+
+// The users created before the roles existed: the oldest one becomes the manager, the others servers
+func backfillRoles(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("user")
+	managers, err := users.CountDocuments(ctx, bson.M{"role": "MANAGER"})
+	if err != nil {
+		return err
+	}
+	if managers == 0 {
+		var oldest bson.M
+		err = users.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})).Decode(&oldest)
+		if err == nil {
+			_, err = users.UpdateOne(ctx, bson.M{"_id": oldest["_id"]}, bson.M{"$set": bson.M{"role": "MANAGER"}})
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+	}
+	_, err = users.UpdateMany(ctx, bson.M{"role": nil}, bson.M{"$set": bson.M{"role": "SERVER"}})
+	return err
+}
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "audit log and user roles",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := createIndexes(ctx, db, auditIndexes); err != nil {
+				return err
+			}
+			return backfillRoles(ctx, db)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if _, err := db.Collection("user").UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"role": ""}}); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, db, auditIndexes)
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Invoices can now be refunded once paid
+var refundableInvoiceSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"invoice_id", "order_id", "payment_status"},
+	"properties": bson.M{
+		"invoice_id":     bson.M{"bsonType": "string"},
+		"order_id":       bson.M{"bsonType": "string"},
+		"payment_method": bson.M{"enum": bson.A{"CARD", "CASH", "", nil}},
+		"payment_status": bson.M{"enum": bson.A{"PENDING", "PAID", "REFUNDED"}},
+		"refunded_at":    bson.M{"bsonType": nullableDateType},
+	},
+}
+
+// The reports go through the orders by date, the voids by deletion date and the refunds by refund date
+var reportIndexes = []index{
+	{collection: "order", name: "order_date", keys: bson.D{{Key: "order_date", Value: 1}}},
+	{collection: "orderItem", name: "deleted_at", keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	{collection: "Invoice", name: "payment_status_refunded_at", keys: bson.D{{Key: "payment_status", Value: 1}, {Key: "refunded_at", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "invoice refunds and report indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "Invoice", refundableInvoiceSchema); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, reportIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, reportIndexes); err != nil {
+				return err
+			}
+			return setValidator(ctx, db, "Invoice", collectionSchemas["Invoice"])
+		},
+	})
+}
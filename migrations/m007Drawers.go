@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The drawers and closed days are looked up by id and by business day, and a day can only be closed once
+var drawerIndexes = []index{
+	{collection: "drawers", name: "drawer_id_unique", keys: bson.D{{Key: "drawer_id", Value: 1}}, unique: true},
+	{collection: "drawers", name: "status_name", keys: bson.D{{Key: "status", Value: 1}, {Key: "name", Value: 1}}},
+	{collection: "drawers", name: "business_day", keys: bson.D{{Key: "business_day", Value: 1}}},
+	{collection: "business_days", name: "business_day_unique", keys: bson.D{{Key: "business_day", Value: 1}}, unique: true},
+	{collection: "Invoice", name: "business_day", keys: bson.D{{Key: "business_day", Value: 1}}},
+	{collection: "Invoice", name: "refund_day", keys: bson.D{{Key: "refund_day", Value: 1}}},
+	{collection: "Invoice", name: "drawer_id", keys: bson.D{{Key: "drawer_id", Value: 1}}},
+	{collection: "Invoice", name: "refund_drawer_id", keys: bson.D{{Key: "refund_drawer_id", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "cash drawers and business days",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, drawerIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, drawerIndexes)
+		},
+	})
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A coupon code names one promotion; the promotions without a code are left out of the unique index
+var promotionIndexes = []index{
+	{collection: "promotions", name: "promotion_id_unique", keys: bson.D{{Key: "promotion_id", Value: 1}}, unique: true},
+	{collection: "promotions", name: "coupon_code_unique", keys: bson.D{{Key: "coupon_code", Value: 1}}, unique: true, partial: bson.M{"coupon_code": bson.M{"$type": "string"}}},
+	{collection: "promotions", name: "is_active_created_at", keys: bson.D{{Key: "is_active", Value: 1}, {Key: "created_at", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "promotions",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, promotionIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, promotionIndexes)
+		},
+	})
+}
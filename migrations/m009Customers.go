@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Customers are found by phone, and their profile goes through the orders and invoices attached to them
+var customerIndexes = []index{
+	{collection: "customers", name: "customer_id_unique", keys: bson.D{{Key: "customer_id", Value: 1}}, unique: true},
+	{collection: "customers", name: "phone_unique", keys: bson.D{{Key: "phone", Value: 1}}, unique: true},
+	{collection: "order", name: "customer_id_order_date", keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "order_date", Value: -1}}},
+	{collection: "Invoice", name: "customer_id", keys: bson.D{{Key: "customer_id", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "customers",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, customerIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, customerIndexes)
+		},
+	})
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The sequence of the entries is unique per customer, so two entries can't be written over the same balance
+var loyaltyIndexes = []index{
+	{collection: "loyalty_ledger", name: "entry_id_unique", keys: bson.D{{Key: "entry_id", Value: 1}}, unique: true},
+	{collection: "loyalty_ledger", name: "customer_id_sequence_unique", keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "sequence", Value: -1}}, unique: true},
+	{collection: "loyalty_ledger", name: "invoice_id", keys: bson.D{{Key: "invoice_id", Value: 1}}},
+	{collection: "loyalty_ledger", name: "reverses_id", keys: bson.D{{Key: "reverses_id", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "loyalty ledger",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, loyaltyIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, loyaltyIndexes)
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Invoices can now be paid entirely with gift cards
+var giftCardInvoiceSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"invoice_id", "order_id", "payment_status"},
+	"properties": bson.M{
+		"invoice_id":     bson.M{"bsonType": "string"},
+		"order_id":       bson.M{"bsonType": "string"},
+		"payment_method": bson.M{"enum": bson.A{"CARD", "CASH", "GIFT_CARD", "", nil}},
+		"payment_status": bson.M{"enum": bson.A{"PENDING", "PAID", "REFUNDED"}},
+		"refunded_at":    bson.M{"bsonType": nullableDateType},
+	},
+}
+
+// The codes are unique, and a card can't go below zero
+var giftCardIndexes = []index{
+	{collection: "gift_cards", name: "gift_card_id_unique", keys: bson.D{{Key: "gift_card_id", Value: 1}}, unique: true},
+	{collection: "gift_cards", name: "code_unique", keys: bson.D{{Key: "code", Value: 1}}, unique: true},
+	{collection: "gift_card_transactions", name: "transaction_id_unique", keys: bson.D{{Key: "transaction_id", Value: 1}}, unique: true},
+	{collection: "gift_card_transactions", name: "code_created_at", keys: bson.D{{Key: "code", Value: 1}, {Key: "created_at", Value: -1}}},
+	{collection: "gift_card_transactions", name: "invoice_id", keys: bson.D{{Key: "invoice_id", Value: 1}}},
+}
+
+var giftCardSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"gift_card_id", "code", "balance"},
+	"properties": bson.M{
+		"gift_card_id": bson.M{"bsonType": "string"},
+		"code":         bson.M{"bsonType": "string"},
+		"balance":      bson.M{"bsonType": numberType, "minimum": -0.005},
+	},
+}
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "gift cards",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "Invoice", giftCardInvoiceSchema); err != nil {
+				return err
+			}
+			if err := setValidator(ctx, db, "gift_cards", giftCardSchema); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, giftCardIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, giftCardIndexes); err != nil {
+				return err
+			}
+			if err := setValidator(ctx, db, "gift_cards", nil); err != nil {
+				return err
+			}
+			return setValidator(ctx, db, "Invoice", refundableInvoiceSchema)
+		},
+	})
+}
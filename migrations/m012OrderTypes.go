@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Orders get a type, the orders from before it are dine-in
+var typedOrderSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"order_id", "order_date"},
+	"properties": bson.M{
+		"order_id":     bson.M{"bsonType": "string"},
+		"order_date":   bson.M{"bsonType": "date"},
+		"order_type":   bson.M{"enum": bson.A{"DINE_IN", "TAKEOUT", "PICKUP", "DELIVERY", nil}},
+		"table_id":     bson.M{"bsonType": nullableStringType},
+		"delivery_fee": bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+	},
+}
+
+// The kitchen and the drivers look for the takeout and delivery orders by the time they are promised
+var orderTypeIndexes = []index{
+	{collection: "order", name: "order_type_promised_at", keys: bson.D{{Key: "order_type", Value: 1}, {Key: "promised_at", Value: 1}}},
+	{collection: "order", name: "driver_id", keys: bson.D{{Key: "driver_id", Value: 1}}, partial: bson.M{"driver_id": bson.M{"$type": "string"}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "order types",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "order", typedOrderSchema); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, orderTypeIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, orderTypeIndexes); err != nil {
+				return err
+			}
+			return setValidator(ctx, db, "order", collectionSchemas["order"])
+		},
+	})
+}
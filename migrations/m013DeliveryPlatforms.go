@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Orders get a status, and the ones of the delivery platforms their source and external id
+var statusOrderSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"order_id", "order_date"},
+	"properties": bson.M{
+		"order_id":     bson.M{"bsonType": "string"},
+		"order_date":   bson.M{"bsonType": "date"},
+		"order_type":   bson.M{"enum": bson.A{"DINE_IN", "TAKEOUT", "PICKUP", "DELIVERY", nil}},
+		"status":       bson.M{"enum": bson.A{"PLACED", "ACCEPTED", "READY", "PICKED_UP", "CANCELLED", nil}},
+		"source":       bson.M{"bsonType": nullableStringType},
+		"external_id":  bson.M{"bsonType": nullableStringType},
+		"table_id":     bson.M{"bsonType": nullableStringType},
+		"delivery_fee": bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+	},
+}
+
+// A platform sends an order once per external id, and maps an item once
+var deliveryIndexes = []index{
+	{collection: "delivery_orders", name: "delivery_order_id_unique", keys: bson.D{{Key: "delivery_order_id", Value: 1}}, unique: true},
+	{collection: "delivery_orders", name: "platform_external_id_unique", keys: bson.D{{Key: "platform", Value: 1}, {Key: "external_id", Value: 1}}, unique: true},
+	{collection: "delivery_orders", name: "order_id", keys: bson.D{{Key: "order_id", Value: 1}}},
+	{collection: "delivery_orders", name: "received_at", keys: bson.D{{Key: "received_at", Value: -1}}},
+	{collection: "delivery_item_mappings", name: "mapping_id_unique", keys: bson.D{{Key: "mapping_id", Value: 1}}, unique: true},
+	{collection: "delivery_item_mappings", name: "platform_external_item_id_unique", keys: bson.D{{Key: "platform", Value: 1}, {Key: "external_item_id", Value: 1}}, unique: true},
+	{collection: "order", name: "status_order_date", keys: bson.D{{Key: "status", Value: 1}, {Key: "order_date", Value: -1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 13,
+		Name:    "delivery platforms",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "order", statusOrderSchema); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, deliveryIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, deliveryIndexes); err != nil {
+				return err
+			}
+			return setValidator(ctx, db, "order", typedOrderSchema)
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The dispatcher looks for the pending deliveries due, the log is read by subscription and event
+var webhookIndexes = []index{
+	{collection: "webhook_subscriptions", name: "subscription_id_unique", keys: bson.D{{Key: "subscription_id", Value: 1}}, unique: true},
+	{collection: "webhook_subscriptions", name: "events", keys: bson.D{{Key: "events", Value: 1}}},
+	{collection: "webhook_deliveries", name: "delivery_id_unique", keys: bson.D{{Key: "delivery_id", Value: 1}}, unique: true},
+	{collection: "webhook_deliveries", name: "status_next_attempt_at", keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+	{collection: "webhook_deliveries", name: "subscription_id_created_at", keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	{collection: "webhook_deliveries", name: "event_id", keys: bson.D{{Key: "event_id", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 14,
+		Name:    "webhooks",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, webhookIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, webhookIndexes)
+		},
+	})
+}
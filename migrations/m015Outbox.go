@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Every subscriber looks for the oldest event it didn't handle, the events handled by all are kept a week.
+// A subscriber has one checkpoint, its lease can't be taken twice.
+var outboxIndexes = []index{
+	{collection: "outbox", name: "event_id_unique", keys: bson.D{{Key: "event_id", Value: 1}}, unique: true},
+	{collection: "outbox", name: "pending_occurred_at", keys: bson.D{{Key: "pending", Value: 1}, {Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}}},
+	{collection: "outbox", name: "completed_at_ttl", keys: bson.D{{Key: "completed_at", Value: 1}}, expireAfter: 7 * 24 * 60 * 60},
+	{collection: "outbox_checkpoints", name: "subscriber_unique", keys: bson.D{{Key: "subscriber", Value: 1}}, unique: true},
+}
+
+func init() {
+	register(Migration{
+		Version: 15,
+		Name:    "outbox",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, outboxIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, outboxIndexes)
+		},
+	})
+}
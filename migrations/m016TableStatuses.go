@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tables get a status and a place on the floor plan, the tables from before it are available
+var floorTableSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"table_id", "number_of_guests", "table_number"},
+	"properties": bson.M{
+		"table_id":         bson.M{"bsonType": "string"},
+		"number_of_guests": bson.M{"bsonType": numberType, "minimum": 1},
+		"table_number":     bson.M{"bsonType": numberType},
+		"section":          bson.M{"bsonType": nullableStringType},
+		"position_x":       bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+		"position_y":       bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+		"shape":            bson.M{"enum": bson.A{"ROUND", "SQUARE", "RECTANGLE", "BOOTH", nil}},
+		"status":           bson.M{"enum": bson.A{"AVAILABLE", "RESERVED", "SEATED", "ORDERED", "CHECK_DROPPED", "DIRTY", nil}},
+	},
+}
+
+// The floor plan reads the tables by section, the hosts look for the tables in a status
+var floorIndexes = []index{
+	{collection: "tables", name: "section_table_number", keys: bson.D{{Key: "section", Value: 1}, {Key: "table_number", Value: 1}}},
+	{collection: "tables", name: "status", keys: bson.D{{Key: "status", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 16,
+		Name:    "table statuses",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "tables", floorTableSchema); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, floorIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, floorIndexes); err != nil {
+				return err
+			}
+			return setValidator(ctx, db, "tables", collectionSchemas["tables"])
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Orders can be MERGED into another order
+var mergedOrderSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"order_id", "order_date"},
+	"properties": bson.M{
+		"order_id":     bson.M{"bsonType": "string"},
+		"order_date":   bson.M{"bsonType": "date"},
+		"order_type":   bson.M{"enum": bson.A{"DINE_IN", "TAKEOUT", "PICKUP", "DELIVERY", nil}},
+		"status":       bson.M{"enum": bson.A{"PLACED", "ACCEPTED", "READY", "PICKED_UP", "CANCELLED", "MERGED", nil}},
+		"source":       bson.M{"bsonType": nullableStringType},
+		"external_id":  bson.M{"bsonType": nullableStringType},
+		"table_id":     bson.M{"bsonType": nullableStringType},
+		"merged_into":  bson.M{"bsonType": nullableStringType},
+		"delivery_fee": bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+	},
+}
+
+func init() {
+	register(Migration{
+		Version: 17,
+		Name:    "merged orders",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "order", mergedOrderSchema)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "order", statusOrderSchema)
+		},
+	})
+}
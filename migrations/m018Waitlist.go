@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The hosts read the parties still waiting in the order they came, the turn times come from the invoices paid lately
+var waitlistIndexes = []index{
+	{collection: "waitlist", name: "entry_id_unique", keys: bson.D{{Key: "entry_id", Value: 1}}, unique: true},
+	{collection: "waitlist", name: "status_created_at", keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	{collection: "Invoice", name: "payment_status_paid_at", keys: bson.D{{Key: "payment_status", Value: 1}, {Key: "paid_at", Value: -1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 18,
+		Name:    "waitlist",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, waitlistIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, waitlistIndexes)
+		},
+	})
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Orders carry the server who has them
+var serverOrderSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"order_id", "order_date"},
+	"properties": bson.M{
+		"order_id":     bson.M{"bsonType": "string"},
+		"order_date":   bson.M{"bsonType": "date"},
+		"order_type":   bson.M{"enum": bson.A{"DINE_IN", "TAKEOUT", "PICKUP", "DELIVERY", nil}},
+		"status":       bson.M{"enum": bson.A{"PLACED", "ACCEPTED", "READY", "PICKED_UP", "CANCELLED", "MERGED", nil}},
+		"source":       bson.M{"bsonType": nullableStringType},
+		"external_id":  bson.M{"bsonType": nullableStringType},
+		"table_id":     bson.M{"bsonType": nullableStringType},
+		"merged_into":  bson.M{"bsonType": nullableStringType},
+		"server_id":    bson.M{"bsonType": nullableStringType},
+		"delivery_fee": bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+	},
+}
+
+// The checks of a server are listed and reported on, the sections are looked up by shift
+var serverIndexes = []index{
+	{collection: "order", name: "server_id", keys: bson.D{{Key: "server_id", Value: 1}}, partial: bson.M{"server_id": bson.M{"$type": "string"}}},
+	{collection: "section_assignments", name: "assignment_id_unique", keys: bson.D{{Key: "assignment_id", Value: 1}}, unique: true},
+	{collection: "section_assignments", name: "business_day_shift_section", keys: bson.D{{Key: "business_day", Value: 1}, {Key: "shift", Value: 1}, {Key: "section", Value: 1}}},
+	{collection: "section_assignments", name: "server_id_business_day", keys: bson.D{{Key: "server_id", Value: 1}, {Key: "business_day", Value: -1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 19,
+		Name:    "servers",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "order", serverOrderSchema); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, serverIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, serverIndexes); err != nil {
+				return err
+			}
+			return setValidator(ctx, db, "order", mergedOrderSchema)
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The time clock looks for the open entry of a user on every order taken, the payroll reads the entries and the
+// shifts by business day
+var timeClockIndexes = []index{
+	{collection: "time_entries", name: "entry_id_unique", keys: bson.D{{Key: "entry_id", Value: 1}}, unique: true},
+	{collection: "time_entries", name: "user_id_clock_out", keys: bson.D{{Key: "user_id", Value: 1}, {Key: "clock_out", Value: 1}}},
+	{collection: "time_entries", name: "business_day", keys: bson.D{{Key: "business_day", Value: 1}}},
+	{collection: "schedule_shifts", name: "shift_id_unique", keys: bson.D{{Key: "shift_id", Value: 1}}, unique: true},
+	{collection: "schedule_shifts", name: "user_id_starts_at", keys: bson.D{{Key: "user_id", Value: 1}, {Key: "starts_at", Value: 1}}},
+	{collection: "schedule_shifts", name: "starts_at", keys: bson.D{{Key: "starts_at", Value: 1}}},
+	{collection: "schedule_shifts", name: "business_day", keys: bson.D{{Key: "business_day", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 20,
+		Name:    "time clock",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, timeClockIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, timeClockIndexes)
+		},
+	})
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The tips of a business day are shared once, and read by day for the tips report
+var tipPoolIndexes = []index{
+	{collection: "tip_pool_rules", name: "rule_id_unique", keys: bson.D{{Key: "rule_id", Value: 1}}, unique: true},
+	{collection: "tip_distributions", name: "distribution_id_unique", keys: bson.D{{Key: "distribution_id", Value: 1}}, unique: true},
+	{collection: "tip_distributions", name: "business_day", keys: bson.D{{Key: "business_day", Value: 1}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 21,
+		Name:    "tip pools",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, tipPoolIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, tipPoolIndexes)
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A user has one open time entry at most, even when they clock in twice at once. The partial filters can't test
+// for null, the $type "null" keeps the entries which are neither clocked out nor deleted.
+var openTimeEntryIndexes = []index{
+	{collection: "time_entries", name: "user_id_open_unique", keys: bson.D{{Key: "user_id", Value: 1}}, unique: true, partial: bson.M{"clock_out": bson.M{"$type": "null"}, "deleted_at": bson.M{"$type": "null"}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 22,
+		Name:    "open time entries",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, openTimeEntryIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, openTimeEntryIndexes)
+		},
+	})
+}
@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Orders carry the guests seated, which dine-in orders need
+var guestOrderSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"order_id", "order_date"},
+	"properties": bson.M{
+		"order_id":     bson.M{"bsonType": "string"},
+		"order_date":   bson.M{"bsonType": "date"},
+		"order_type":   bson.M{"enum": bson.A{"DINE_IN", "TAKEOUT", "PICKUP", "DELIVERY", nil}},
+		"status":       bson.M{"enum": bson.A{"PLACED", "ACCEPTED", "READY", "PICKED_UP", "CANCELLED", "MERGED", nil}},
+		"source":       bson.M{"bsonType": nullableStringType},
+		"external_id":  bson.M{"bsonType": nullableStringType},
+		"table_id":     bson.M{"bsonType": nullableStringType},
+		"merged_into":  bson.M{"bsonType": nullableStringType},
+		"server_id":    bson.M{"bsonType": nullableStringType},
+		"guests":       bson.M{"bsonType": bson.A{"int", "long", "null"}, "minimum": 1},
+		"delivery_fee": bson.M{"bsonType": bson.A{"double", "int", "long", "decimal", "null"}, "minimum": 0},
+	},
+}
+
+// A section has one server per shift. The index of m019 has the same keys without being unique, so it is replaced.
+// The partial filters can't test for null, the $type "null" keeps the assignments which are not deleted.
+var sectionAssignmentIndex = index{collection: "section_assignments", name: "business_day_shift_section", keys: bson.D{{Key: "business_day", Value: 1}, {Key: "shift", Value: 1}, {Key: "section", Value: 1}}}
+
+var uniqueSectionIndexes = []index{
+	{collection: "section_assignments", name: "business_day_shift_section_unique", keys: sectionAssignmentIndex.keys, unique: true, partial: bson.M{"deleted_at": bson.M{"$type": "null"}}},
+}
+
+// The dine-in orders from before the guests were recorded seat as many guests as their table, or one guest when
+// their table is gone
+func backfillGuests(ctx context.Context, db *mongo.Database) error {
+	orders := db.Collection("order")
+	dineIn := bson.M{"guests": nil, "order_type": bson.M{"$in": bson.A{"DINE_IN", nil}}}
+
+	res, err := db.Collection("tables").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"table_id": 1, "number_of_guests": 1}))
+	if err != nil {
+		return err
+	}
+	var tables []struct {
+		Table_id         string `bson:"table_id"`
+		Number_of_guests int    `bson:"number_of_guests"`
+	}
+	if err = res.All(ctx, &tables); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if table.Number_of_guests < 1 {
+			continue
+		}
+		filter := bson.M{"table_id": table.Table_id}
+		for key, value := range dineIn {
+			filter[key] = value
+		}
+		if _, err = orders.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"guests": table.Number_of_guests}}); err != nil {
+			return err
+		}
+	}
+	_, err = orders.UpdateMany(ctx, dineIn, bson.M{"$set": bson.M{"guests": 1}})
+	return err
+}
+
+func init() {
+	register(Migration{
+		Version: 23,
+		Name:    "unique sections and guests",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, []index{sectionAssignmentIndex}); err != nil {
+				return err
+			}
+			if err := createIndexes(ctx, db, uniqueSectionIndexes); err != nil {
+				return err
+			}
+			if err := setValidator(ctx, db, "order", guestOrderSchema); err != nil {
+				return err
+			}
+			return backfillGuests(ctx, db)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := setValidator(ctx, db, "order", serverOrderSchema); err != nil {
+				return err
+			}
+			if err := dropIndexes(ctx, db, uniqueSectionIndexes); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, []index{sectionAssignmentIndex})
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The tips of a business day are shared once. The index of m021 has the same key without being unique, so it is
+// replaced. The partial filters can't test for null, the $type "null" keeps the distributions which are not deleted.
+var tipDistributionDayIndex = index{collection: "tip_distributions", name: "business_day", keys: bson.D{{Key: "business_day", Value: 1}}}
+
+var uniqueTipDistributionIndexes = []index{
+	{collection: "tip_distributions", name: "business_day_unique", keys: tipDistributionDayIndex.keys, unique: true, partial: bson.M{"deleted_at": bson.M{"$type": "null"}}},
+}
+
+func init() {
+	register(Migration{
+		Version: 24,
+		Name:    "unique tip distributions",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, []index{tipDistributionDayIndex}); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, uniqueTipDistributionIndexes)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, uniqueTipDistributionIndexes); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, []index{tipDistributionDayIndex})
+		},
+	})
+}
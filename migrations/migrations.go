@@ -0,0 +1,234 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The collection keeping track of the migrations already applied
+const migrationsCollection = "schema_migrations"
+
+// Migration is one versioned change of the database (indexes, validators...).
+// Down has to undo exactly what Up did, so the migration can be rolled back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+// The record kept for every applied migration
+type appliedMigration struct {
+	Version    int       `bson:"version"`
+	Name       string    `bson:"name"`
+	Applied_at time.Time `bson:"applied_at"`
+}
+
+// One line of the status report
+type MigrationStatus struct {
+	Version    int
+	Name       string
+	Applied    bool
+	Applied_at time.Time
+}
+
+var registry []Migration
+
+// Every migration file registers itself from its init function
+func register(migration Migration) {
+	for _, existing := range registry {
+		if existing.Version == migration.Version {
+			panic(fmt.Sprintf("migration version %d is registered twice", migration.Version))
+		}
+	}
+	registry = append(registry, migration)
+	sort.Slice(registry, func(i, j int) bool { return registry[i].Version < registry[j].Version })
+}
+
+func appliedVersions(ctx context.Context, db *mongo.Database) (map[int]appliedMigration, error) {
+	res, err := db.Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var applied []appliedMigration
+	if err = res.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	versions := map[int]appliedMigration{}
+	for _, migration := range applied {
+		versions[migration.Version] = migration
+	}
+	return versions, nil
+}
+
+// This function applies every migration which has not been applied yet, in order.
+// With dryRun it only tells what would be applied.
+func Up(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range registry {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if dryRun {
+			log.Printf("[dry-run] would apply migration %d: %s", migration.Version, migration.Name)
+			continue
+		}
+		log.Printf("applying migration %d: %s", migration.Version, migration.Name)
+		if err = migration.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		_, err = db.Collection(migrationsCollection).InsertOne(ctx, appliedMigration{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Applied_at: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function rolls back the last applied migrations, steps tells how many.
+// With dryRun it only tells what would be rolled back.
+func Down(ctx context.Context, db *mongo.Database, steps int, dryRun bool) error {
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+	for i := len(registry) - 1; i >= 0 && steps > 0; i-- {
+		migration := registry[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		steps--
+		if dryRun {
+			log.Printf("[dry-run] would roll back migration %d: %s", migration.Version, migration.Name)
+			continue
+		}
+		log.Printf("rolling back migration %d: %s", migration.Version, migration.Name)
+		if err = migration.Down(ctx, db); err != nil {
+			return fmt.Errorf("rolling back migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		if _, err = db.Collection(migrationsCollection).DeleteOne(ctx, bson.M{"version": migration.Version}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function lists every known migration, and if it has been applied
+func Status(ctx context.Context, db *mongo.Database) ([]MigrationStatus, error) {
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var status []MigrationStatus
+	for _, migration := range registry {
+		record, ok := applied[migration.Version]
+		status = append(status, MigrationStatus{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Applied:    ok,
+			Applied_at: record.Applied_at,
+		})
+	}
+	return status, nil
+}
+
+// Small helpers used by the migrations themselves
+
+type index struct {
+	collection string
+	name       string
+	keys       bson.D
+	unique     bool
+	// documents are removed this many seconds after the indexed date, when set
+	expireAfter int32
+	// only the documents matching this filter are indexed, when set
+	partial bson.M
+}
+
+func createIndexes(ctx context.Context, db *mongo.Database, indexes []index) error {
+	for _, idx := range indexes {
+		opts := options.Index().SetName(idx.name)
+		if idx.unique {
+			opts.SetUnique(true)
+		}
+		if idx.expireAfter > 0 {
+			opts.SetExpireAfterSeconds(idx.expireAfter)
+		}
+		if idx.partial != nil {
+			opts.SetPartialFilterExpression(idx.partial)
+		}
+		_, err := db.Collection(idx.collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys, Options: opts})
+		if err != nil {
+			return fmt.Errorf("index %s on %s: %w", idx.name, idx.collection, err)
+		}
+	}
+	return nil
+}
+
+func dropIndexes(ctx context.Context, db *mongo.Database, indexes []index) error {
+	for _, idx := range indexes {
+		_, err := db.Collection(idx.collection).Indexes().DropOne(ctx, idx.name)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("index %s on %s: %w", idx.name, idx.collection, err)
+		}
+	}
+	return nil
+}
+
+// This function sets the JSON schema validator of a collection, creating the collection if needed.
+// An empty schema removes the validator.
+func setValidator(ctx context.Context, db *mongo.Database, collection string, schema bson.M) error {
+	err := db.CreateCollection(ctx, collection)
+	if err != nil && !isNamespaceExists(err) {
+		return err
+	}
+	validator := bson.M{}
+	if schema != nil {
+		validator = bson.M{"$jsonSchema": schema}
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "moderate"},
+		{Key: "validationAction", Value: "error"},
+	}).Err()
+}
+
+// The server error codes the migrations can live with
+const (
+	codeNamespaceNotFound = 26
+	codeIndexNotFound     = 27
+	codeNamespaceExists   = 48
+)
+
+func isNamespaceExists(err error) bool {
+	return commandErrorCode(err) == codeNamespaceExists
+}
+
+func isNotFound(err error) bool {
+	code := commandErrorCode(err)
+	return code == codeNamespaceNotFound || code == codeIndexNotFound
+}
+
+func commandErrorCode(err error) int32 {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code
+	}
+	return 0
+}
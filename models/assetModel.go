@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Structure for the uploaded files, the bytes themselves are kept in the blob store
+type Asset struct {
+	ID           primitive.ObjectID      `bson:"_id"`
+	Asset_id     string                  `json:"asset_id"`
+	Content_type string                  `json:"content_type"`
+	Size         int64                   `json:"size"`
+	Width        int                     `json:"width"`
+	Height       int                     `json:"height"`
+	Variants     map[string]AssetVariant `json:"variants"`
+	Created_at   time.Time               `json:"created_at"`
+}
+
+// One stored version of an asset, the original or one of the thumbnails
+type AssetVariant struct {
+	Key          string `json:"-"`
+	Content_type string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Size         int64  `json:"size"`
+}
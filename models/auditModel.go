@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The actions recorded in the audit log
+const (
+	AuditCreate  = "CREATE"
+	AuditUpdate  = "UPDATE"
+	AuditDelete  = "DELETE"
+	AuditRestore = "RESTORE"
+)
+
+// Structure for one entry of the audit log, written for every change made through the API.
+// The entries are only ever inserted, never updated or removed.
+type AuditEntry struct {
+	ID            primitive.ObjectID     `bson:"_id"`
+	Audit_id      string                 `json:"audit_id"`
+	Actor_id      string                 `json:"actor_id"`
+	Action        string                 `json:"action"`
+	Resource_type string                 `json:"resource_type"`
+	Resource_id   string                 `json:"resource_id"`
+	Before        bson.M                 `json:"before"`
+	After         bson.M                 `json:"after"`
+	Diff          map[string]AuditChange `json:"diff"`
+	Ip            string                 `json:"ip"`
+	Request_id    string                 `json:"request_id"`
+	Created_at    time.Time              `json:"created_at"`
+}
+
+// The value of a field before and after the change
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Structure for a closed business day. Once it exists, nothing belonging to that day can be changed anymore.
+type BusinessDay struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Business_day string             `json:"business_day"`
+	Z_report     ZReport            `json:"z_report"`
+	Locked_by    string             `json:"locked_by"`
+	Locked_at    time.Time          `json:"locked_at"`
+	Created_at   time.Time          `json:"created_at"`
+}
+
+// The end of day totals
+type ZReport struct {
+	Business_day    string          `json:"business_day"`
+	Checks          int64           `json:"checks"`
+	Open_checks     int64           `json:"open_checks"`
+	Gross_sales     float64         `json:"gross_sales"`
+	Discounts       float64         `json:"discounts"`
+	Net_sales       float64         `json:"net_sales"`
+	Service_charges float64         `json:"service_charges"`
+	Delivery_fees   float64         `json:"delivery_fees"`
+	Taxes           float64         `json:"taxes"`
+	Tips            float64         `json:"tips"`
+	Total           float64         `json:"total"`
+	Refunds         float64         `json:"refunds"`
+	Refund_count    int64           `json:"refund_count"`
+	Tenders         []ZReportTender `json:"tenders"`
+	Drawers         []ZReportDrawer `json:"drawers"`
+}
+
+// What was taken with one payment method
+type ZReportTender struct {
+	Payment_method string  `json:"payment_method"`
+	Checks         int64   `json:"checks"`
+	Amount         float64 `json:"amount"`
+}
+
+// How a drawer of the day was closed
+type ZReportDrawer struct {
+	Drawer_id       string  `json:"drawer_id"`
+	Name            string  `json:"name"`
+	Opening_float   float64 `json:"opening_float"`
+	Expected_amount float64 `json:"expected_amount"`
+	Counted_amount  float64 `json:"counted_amount"`
+	Variance        float64 `json:"variance"`
+}
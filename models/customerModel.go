@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Structure for Customers, the phone is kept with its digits only (and the leading +)
+type Customer struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Customer_id string             `json:"customer_id"`
+	Name        *string            `json:"name" validate:"required,min=2,max=100"`
+	Phone       *string            `json:"phone" validate:"required,min=6,max=20"`
+	Email       *string            `json:"email" validate:"omitempty,email"`
+	Preferences *string            `json:"preferences" validate:"omitempty,max=1000"`
+	Allergies   []string           `json:"allergies"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+	Version     int64              `json:"version"`
+	Deleted_at  *time.Time         `json:"deleted_at"`
+	Deleted_by  *string            `json:"deleted_by"`
+}
+
+// What a customer came for, read from the orders attached to them
+type CustomerProfile struct {
+	Customer       Customer        `json:"customer"`
+	Visits         int64           `json:"visits"`
+	First_visit    *time.Time      `json:"first_visit"`
+	Last_visit     *time.Time      `json:"last_visit"`
+	Lifetime_spend float64         `json:"lifetime_spend"`
+	Average_check  float64         `json:"average_check"`
+	Favorite_items []FavoriteItem  `json:"favorite_items"`
+	Visit_history  []CustomerVisit `json:"visit_history"`
+}
+
+type FavoriteItem struct {
+	Food_id string  `json:"food_id"`
+	Name    string  `json:"name"`
+	Count   int64   `json:"count"`
+	Amount  float64 `json:"amount"`
+}
+
+type CustomerVisit struct {
+	Order_id   string    `json:"order_id"`
+	Order_date time.Time `json:"order_date"`
+	Table_id   string    `json:"table_id"`
+	Items      int64     `json:"items"`
+	Amount     float64   `json:"amount"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What happened to an order sent by a delivery platform
+const (
+	DeliveryOrderCreated  = "CREATED"
+	DeliveryOrderRejected = "REJECTED"
+)
+
+// An order received from a delivery platform. A platform sends the same order again when it doesn't hear back,
+// so there is only one per external id, and a rejected one is taken again once what was wrong is fixed.
+type DeliveryOrder struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Delivery_order_id string             `json:"delivery_order_id"`
+	Platform          string             `json:"platform"`
+	External_id       string             `json:"external_id"`
+	Status            string             `json:"status"`
+	Error             *string            `json:"error"`
+	Order_id          *string            `json:"order_id"`
+	Payload           string             `json:"payload"`
+	Callbacks         []DeliveryCallback `json:"callbacks"`
+	Received_at       time.Time          `json:"received_at"`
+	Updated_at        time.Time          `json:"updated_at"`
+}
+
+// A status sent back to the platform, Sent_at stays nil until the platform got it
+type DeliveryCallback struct {
+	Callback_id string     `json:"callback_id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	Error       *string    `json:"error"`
+	Created_at  time.Time  `json:"created_at"`
+	Sent_at     *time.Time `json:"sent_at"`
+}
+
+// Which of our foods an item of a delivery platform is
+type DeliveryItemMapping struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Mapping_id       string             `json:"mapping_id"`
+	Platform         *string            `json:"platform" validate:"required,min=2,max=50"`
+	External_item_id *string            `json:"external_item_id" validate:"required,min=1,max=100"`
+	Food_id          *string            `json:"food_id" validate:"required"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+	Version          int64              `json:"version"`
+	Deleted_at       *time.Time         `json:"deleted_at"`
+	Deleted_by       *string            `json:"deleted_by"`
+}
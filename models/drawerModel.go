@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DrawerOpen   = "OPEN"
+	DrawerClosed = "CLOSED"
+
+	DrawerDrop   = "DROP"
+	DrawerPayout = "PAYOUT"
+)
+
+// Structure for a cash drawer, from the float it is opened with to the amount counted when closing it
+type Drawer struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Drawer_id       string             `json:"drawer_id"`
+	Name            *string            `json:"name" validate:"required,min=1,max=50"`
+	Status          string             `json:"status"`
+	Business_day    string             `json:"business_day"`
+	Opening_float   *float64           `json:"opening_float" validate:"required,min=0"`
+	Movements       []DrawerMovement   `json:"movements"`
+	Last_cash_at    *time.Time         `json:"last_cash_at"`
+	Opened_by       string             `json:"opened_by"`
+	Opened_at       time.Time          `json:"opened_at"`
+	Counted_amount  *float64           `json:"counted_amount"`
+	Expected_amount *float64           `json:"expected_amount"`
+	Variance        *float64           `json:"variance"`
+	Closed_by       *string            `json:"closed_by"`
+	Closed_at       *time.Time         `json:"closed_at"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Version         int64              `json:"version"`
+}
+
+// Cash taken out of an open drawer: a drop to the safe, or a payout to a supplier
+type DrawerMovement struct {
+	Kind       string    `json:"kind"`
+	Amount     *float64  `json:"amount" validate:"required,gt=0"`
+	Reason     *string   `json:"reason" validate:"required,min=1,max=200"`
+	Created_by string    `json:"created_by"`
+	Created_at time.Time `json:"created_at"`
+}
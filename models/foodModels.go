@@ -8,12 +8,19 @@ import (
 
 // Structure of the food models
 type Food struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Name       *string            `json:"name" validate:"required,min=2,max=100"`
-	Price      *float64           `json:"price" validate:"required"`
-	Food_image *string            `json:"food_image" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Food_id    string             `json:"food_id"`
-	Menu_id    *string            `json:"menu_id" validate:"required"`
+	ID             primitive.ObjectID `bson:"_id"`
+	Name           *string            `json:"name" validate:"required,min=2,max=100"`
+	Price          *float64           `json:"price" validate:"required"`
+	Description    *string            `json:"description" validate:"omitempty,max=1000"`
+	Allergens      []string           `json:"allergens"`
+	Is_available   *bool              `json:"is_available"`
+	Food_image     *string            `json:"food_image"`
+	Image_asset_id *string            `json:"image_asset_id"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+	Version        int64              `json:"version"`
+	Deleted_at     *time.Time         `json:"deleted_at"`
+	Deleted_by     *string            `json:"deleted_by"`
+	Food_id        string             `json:"food_id"`
+	Menu_id        *string            `json:"menu_id" validate:"required"`
 }
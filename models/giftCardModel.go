@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The kinds of gift card transactions
+const (
+	GiftCardIssue  = "ISSUE"
+	GiftCardRedeem = "REDEEM"
+	GiftCardReload = "RELOAD"
+	GiftCardRefund = "REFUND"
+)
+
+// The payment method of the invoices paid entirely with gift cards
+const PaymentGiftCard = "GIFT_CARD"
+
+// Structure for Gift cards. The balance only changes along with a transaction recording it.
+type GiftCard struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Gift_card_id    string             `json:"gift_card_id"`
+	Code            string             `json:"code"`
+	Initial_balance *float64           `json:"initial_balance" validate:"required,gt=0"`
+	Balance         float64            `json:"balance"`
+	Expires_at      *time.Time         `json:"expires_at"`
+	Issued_by       string             `json:"issued_by"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Version         int64              `json:"version"`
+}
+
+// One change of the balance of a gift card, with the balance after it
+type GiftCardTransaction struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Transaction_id string             `json:"transaction_id"`
+	Gift_card_id   string             `json:"gift_card_id"`
+	Code           string             `json:"code"`
+	Kind           string             `json:"kind"`
+	Amount         float64            `json:"amount"`
+	Balance        float64            `json:"balance"`
+	Invoice_id     *string            `json:"invoice_id"`
+	Created_by     string             `json:"created_by"`
+	Created_at     time.Time          `json:"created_at"`
+}
+
+// A gift card used to pay an invoice. Without an amount, the card pays as much as it can.
+type GiftCardTender struct {
+	Code           *string  `json:"code" validate:"required"`
+	Amount         *float64 `json:"amount" validate:"omitempty,gt=0"`
+	Transaction_id *string  `json:"transaction_id"`
+}
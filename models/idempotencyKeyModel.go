@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Structure for the stored Idempotency-Key requests, and the response given to them.
+// While IN_PROGRESS the key is leased to the request running it until Locked_until, a retry takes it over after that.
+type IdempotencyKey struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Scope_key       string             `json:"scope_key"`
+	Fingerprint     string             `json:"fingerprint"`
+	Status          string             `json:"status"`
+	Lease           string             `json:"lease"`
+	Locked_until    *time.Time         `json:"locked_until"`
+	Response_status int                `json:"response_status"`
+	Response_body   []byte             `json:"response_body"`
+	Content_type    string             `json:"content_type"`
+	Created_at      time.Time          `json:"created_at"`
+}
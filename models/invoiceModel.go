@@ -11,9 +11,32 @@ type Invoice struct {
 	ID               primitive.ObjectID `bson:"_id"`
 	Invoice_id       string             `json:"invoice_id"`
 	Order_id         string             `json:"order_id"`
-	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
-	Payment_status   *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID"`
+	Customer_id      *string            `json:"customer_id"`
+	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq=GIFT_CARD|eq="`
+	Payment_status   *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID|eq=REFUNDED"`
 	Payment_due_date time.Time          `json:"payment_due_date"`
+	Subtotal         *float64           `json:"subtotal"`
+	Discount         *float64           `json:"discount"`
+	Service_charge   *float64           `json:"service_charge"`
+	Delivery_fee     *float64           `json:"delivery_fee"`
+	Tax              *float64           `json:"tax"`
+	Tip              *float64           `json:"tip"`
+	Total            *float64           `json:"total"`
+	Coupon_codes     []string           `json:"coupon_codes"`
+	Manual_discount  *ManualDiscount    `json:"manual_discount"`
+	Loyalty_points   *int64             `json:"loyalty_points" validate:"omitempty,min=0"`
+	Gift_cards       []GiftCardTender   `json:"gift_cards" validate:"dive"`
+	Gift_card_amount *float64           `json:"gift_card_amount"`
+	Discount_lines   []DiscountLine     `json:"discount_lines"`
+	Paid_at          *time.Time         `json:"paid_at"`
+	Business_day     *string            `json:"business_day"`
+	Drawer_id        *string            `json:"drawer_id"`
+	Refunded_at      *time.Time         `json:"refunded_at"`
+	Refund_day       *string            `json:"refund_day"`
+	Refund_drawer_id *string            `json:"refund_drawer_id"`
 	Created_at       time.Time          `json:"created_at"`
 	Updated_at       time.Time          `json:"updated_at"`
+	Version          int64              `json:"version"`
+	Deleted_at       *time.Time         `json:"deleted_at"`
+	Deleted_by       *string            `json:"deleted_by"`
 }
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The kinds of loyalty ledger entries
+const (
+	LoyaltyEarn     = "EARN"
+	LoyaltyRedeem   = "REDEEM"
+	LoyaltyReversal = "REVERSAL"
+	LoyaltyExpire   = "EXPIRE"
+)
+
+// The kind of the discount lines paid with loyalty points
+const DiscountLoyalty = "LOYALTY"
+
+// One entry of the loyalty ledger. Entries are only ever appended: each one carries the balance of the customer
+// after it (a snapshot), and its sequence number, which is unique per customer.
+type LoyaltyEntry struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Entry_id        string             `json:"entry_id"`
+	Customer_id     string             `json:"customer_id"`
+	Sequence        int64              `json:"sequence"`
+	Kind            string             `json:"kind"`
+	Points          int64              `json:"points"`
+	Balance         int64              `json:"balance"`
+	Lifetime_points int64              `json:"lifetime_points"`
+	Tier            string             `json:"tier"`
+	Invoice_id      *string            `json:"invoice_id"`
+	Reverses_id     *string            `json:"reverses_id"`
+	Last_earned_at  *time.Time         `json:"last_earned_at"`
+	Created_by      string             `json:"created_by"`
+	Created_at      time.Time          `json:"created_at"`
+}
+
+// A tier of the loyalty program, reached with the points earned over time
+type LoyaltyTier struct {
+	Name       string  `json:"name"`
+	Min_points int64   `json:"min_points"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// The loyalty balance of a customer
+type LoyaltyAccount struct {
+	Customer_id         string       `json:"customer_id"`
+	Balance             int64        `json:"balance"`
+	Balance_value       float64      `json:"balance_value"`
+	Lifetime_points     int64        `json:"lifetime_points"`
+	Tier                LoyaltyTier  `json:"tier"`
+	Next_tier           *LoyaltyTier `json:"next_tier"`
+	Points_to_next_tier int64        `json:"points_to_next_tier"`
+	Expires_at          *time.Time   `json:"expires_at"`
+}
@@ -13,5 +13,8 @@ type Note struct {
 	Title      string             `json:"title"`
 	Created_at time.Time          `json:"created_at"`
 	Updated_at time.Time          `json:"updated_at"`
+	Version    int64              `json:"version"`
+	Deleted_at *time.Time         `json:"deleted_at"`
+	Deleted_by *string            `json:"deleted_by"`
 	Note_id    string             `json:"note_id"`
 }
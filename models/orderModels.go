@@ -6,12 +6,66 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Structure of Orders
+// The kinds of orders, only the dine-in ones sit at a table
+const (
+	OrderDineIn   = "DINE_IN"
+	OrderTakeout  = "TAKEOUT"
+	OrderPickup   = "PICKUP"
+	OrderDelivery = "DELIVERY"
+)
+
+var OrderTypes = []string{OrderDineIn, OrderTakeout, OrderPickup, OrderDelivery}
+
+// Where an order is at. The orders taken here start ACCEPTED, the ones coming from a delivery platform
+// start PLACED until someone accepts them. Only the orders leaving the restaurant get PICKED_UP.
+// A MERGED order gave its items to the order in merged_into.
+const (
+	OrderPlaced    = "PLACED"
+	OrderAccepted  = "ACCEPTED"
+	OrderReady     = "READY"
+	OrderPickedUp  = "PICKED_UP"
+	OrderCancelled = "CANCELLED"
+	OrderMerged    = "MERGED"
+)
+
+// Where the orders taken here come from, the others carry the name of their platform
+const OrderSourcePOS = "POS"
+
+// Structure of Orders. Takeout and pickup orders carry who they are for and when they are promised,
+// delivery orders also carry where they go, their fee and their driver. The server is who took the order,
+// or who it was handed over to.
 type Order struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Order_Date time.Time          `json:"order_date" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Order_id   string             `json:"order_id"`
-	Table_id   *string            `json:"table_id" validate:"required"`
+	ID                 primitive.ObjectID `bson:"_id"`
+	Order_Date         time.Time          `json:"order_date" validate:"required"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Version            int64              `json:"version"`
+	Deleted_at         *time.Time         `json:"deleted_at"`
+	Deleted_by         *string            `json:"deleted_by"`
+	Order_id           string             `json:"order_id"`
+	Order_type         *string            `json:"order_type" validate:"omitempty,eq=DINE_IN|eq=TAKEOUT|eq=PICKUP|eq=DELIVERY"`
+	Status             *string            `json:"status"`
+	Status_changed_at  *time.Time         `json:"status_changed_at"`
+	Source             *string            `json:"source"`
+	External_id        *string            `json:"external_id"`
+	Table_id           *string            `json:"table_id"`
+	Customer_id        *string            `json:"customer_id"`
+	Customer_name      *string            `json:"customer_name" validate:"omitempty,min=2,max=100"`
+	Customer_phone     *string            `json:"customer_phone" validate:"omitempty,min=6,max=20"`
+	Promised_at        *time.Time         `json:"promised_at"`
+	Delivery_address   *DeliveryAddress   `json:"delivery_address"`
+	Delivery_fee       *float64           `json:"delivery_fee" validate:"omitempty,min=0"`
+	Driver_id          *string            `json:"driver_id"`
+	Driver_assigned_at *time.Time         `json:"driver_assigned_at"`
+	Merged_into        *string            `json:"merged_into"`
+	Server_id          *string            `json:"server_id"`
+	Guests             *int               `json:"guests" validate:"omitempty,min=1"`
+}
+
+// Where a delivery order goes
+type DeliveryAddress struct {
+	Street       *string `json:"street" validate:"required,min=2,max=200"`
+	City         *string `json:"city" validate:"required,min=2,max=100"`
+	Postal_code  *string `json:"postal_code" validate:"omitempty,max=20"`
+	Instructions *string `json:"instructions" validate:"omitempty,max=500"`
 }
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The kinds of promotions
+const (
+	PromotionPercentage = "PERCENTAGE"
+	PromotionFixed      = "FIXED"
+	PromotionBuyXGetY   = "BUY_X_GET_Y"
+)
+
+// What a promotion applies to
+const (
+	PromotionScopeOrder = "ORDER"
+	PromotionScopeFoods = "FOODS"
+	PromotionScopeMenus = "MENUS"
+)
+
+// The kind of the discount lines given by hand
+const DiscountManual = "MANUAL"
+
+// Structure for Promotions. Value is a percentage for PERCENTAGE (and the part taken off the Y items of
+// BUY_X_GET_Y, 100 when not set), and an amount for FIXED. A promotion with a coupon code only applies
+// to the invoices carrying the code, the others apply by themselves while active.
+type Promotion struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Promotion_id string             `json:"promotion_id"`
+	Name         *string            `json:"name" validate:"required,min=2,max=100"`
+	Kind         *string            `json:"kind" validate:"required,eq=PERCENTAGE|eq=FIXED|eq=BUY_X_GET_Y"`
+	Value        *float64           `json:"value" validate:"omitempty,gt=0"`
+	Buy_quantity *int               `json:"buy_quantity" validate:"omitempty,min=1"`
+	Get_quantity *int               `json:"get_quantity" validate:"omitempty,min=1"`
+	Scope        *string            `json:"scope" validate:"required,eq=ORDER|eq=FOODS|eq=MENUS"`
+	Food_ids     []string           `json:"food_ids"`
+	Menu_ids     []string           `json:"menu_ids"`
+	Coupon_code  *string            `json:"coupon_code" validate:"omitempty,min=3,max=40,alphanum"`
+	Usage_limit  *int               `json:"usage_limit" validate:"omitempty,min=1"`
+	Times_used   int                `json:"times_used"`
+	Starts_at    *time.Time         `json:"starts_at"`
+	Ends_at      *time.Time         `json:"ends_at"`
+	Days         []int              `json:"days" validate:"omitempty,dive,min=0,max=6"`
+	Start_time   *string            `json:"start_time" validate:"omitempty,datetime=15:04"`
+	End_time     *string            `json:"end_time" validate:"omitempty,datetime=15:04"`
+	Is_active    *bool              `json:"is_active"`
+	Created_by   string             `json:"created_by"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Version      int64              `json:"version"`
+	Deleted_at   *time.Time         `json:"deleted_at"`
+	Deleted_by   *string            `json:"deleted_by"`
+}
+
+// A discount given by hand on an invoice, counted once a manager approved it
+type ManualDiscount struct {
+	Amount       *float64   `json:"amount" validate:"omitempty,gt=0"`
+	Percentage   *float64   `json:"percentage" validate:"omitempty,gt=0,max=100"`
+	Reason       *string    `json:"reason" validate:"required,min=2,max=200"`
+	Requested_by string     `json:"requested_by"`
+	Approved_by  *string    `json:"approved_by"`
+	Approved_at  *time.Time `json:"approved_at"`
+}
+
+// One discount taken off an invoice
+type DiscountLine struct {
+	Kind         string  `json:"kind"`
+	Promotion_id *string `json:"promotion_id"`
+	Name         string  `json:"name"`
+	Coupon_code  *string `json:"coupon_code"`
+	Amount       float64 `json:"amount"`
+	Points       *int64  `json:"points"`
+	Approved_by  *string `json:"approved_by"`
+}
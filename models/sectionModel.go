@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The shifts of a business day, they are the services the reports split the day into
+const (
+	ShiftBreakfast = "BREAKFAST"
+	ShiftLunch     = "LUNCH"
+	ShiftDinner    = "DINNER"
+)
+
+// A section of the floor given to a server for one shift of a business day. A section has one server per shift.
+type SectionAssignment struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Assignment_id string             `json:"assignment_id"`
+	Section       *string            `json:"section" validate:"required,min=1,max=50"`
+	Server_id     *string            `json:"server_id" validate:"required"`
+	Business_day  *string            `json:"business_day" validate:"required,datetime=2006-01-02"`
+	Shift         *string            `json:"shift" validate:"required,eq=BREAKFAST|eq=LUNCH|eq=DINNER"`
+	Created_at    time.Time          `json:"created_at"`
+	Updated_at    time.Time          `json:"updated_at"`
+	Version       int64              `json:"version"`
+	Deleted_at    *time.Time         `json:"deleted_at"`
+	Deleted_by    *string            `json:"deleted_by"`
+}
+
+// A section of the floor with its tables and who serves it during a shift
+type FloorSection struct {
+	Section    string   `json:"section"`
+	Table_ids  []string `json:"table_ids"`
+	Server_ids []string `json:"server_ids"`
+}
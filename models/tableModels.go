@@ -6,12 +6,40 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// The statuses of a table. ORDERED and CHECK_DROPPED follow its orders and invoices, the others are set by the staff.
+const (
+	TableAvailable    = "AVAILABLE"
+	TableReserved     = "RESERVED"
+	TableSeated       = "SEATED"
+	TableOrdered      = "ORDERED"
+	TableCheckDropped = "CHECK_DROPPED"
+	TableDirty        = "DIRTY"
+)
+
 // Structure for table management
 type Table struct {
-	ID               primitive.ObjectID `bson:"_id"`
-	Number_of_guests *int               `json:"number_of_guests" validate:"required"`
-	Table_number     *int               `json:"table_number" validate:"required"`
-	Created_at       time.Time          `json:"created_at"`
-	Updated_at       time.Time          `json:"updated_at"`
-	Table_id         string             `json:"table_id"`
+	ID                primitive.ObjectID `bson:"_id"`
+	Number_of_guests  *int               `json:"number_of_guests" validate:"required"`
+	Table_number      *int               `json:"table_number" validate:"required"`
+	Section           *string            `json:"section"`
+	Position_x        *float64           `json:"position_x" validate:"omitempty,min=0"`
+	Position_y        *float64           `json:"position_y" validate:"omitempty,min=0"`
+	Shape             *string            `json:"shape" validate:"omitempty,eq=ROUND|eq=SQUARE|eq=RECTANGLE|eq=BOOTH"`
+	Status            *string            `json:"status"`
+	Status_changed_at *time.Time         `json:"status_changed_at"`
+	Seated_at         *time.Time         `json:"seated_at"`
+	Created_at        time.Time          `json:"created_at"`
+	Updated_at        time.Time          `json:"updated_at"`
+	Version           int64              `json:"version"`
+	Deleted_at        *time.Time         `json:"deleted_at"`
+	Deleted_by        *string            `json:"deleted_by"`
+	Table_id          string             `json:"table_id"`
+}
+
+// A table as the floor plan shows it, with how long its party has been seated and what its open orders come to
+type FloorTable struct {
+	Table
+	Seated_minutes   *int     `json:"seated_minutes"`
+	Open_orders      []string `json:"open_orders"`
+	Open_check_total float64  `json:"open_check_total"`
 }
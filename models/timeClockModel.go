@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A stretch of work of a user, from clocking in to clocking out, in the role they worked.
+// The business day is the one they clocked in on, and the unpaid breaks don't count as worked.
+type TimeEntry struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Entry_id     string             `json:"entry_id"`
+	User_id      string             `json:"user_id"`
+	Role         *string            `json:"role" validate:"omitempty,min=2,max=50"`
+	Shift_id     *string            `json:"shift_id"`
+	Clock_in     time.Time          `json:"clock_in"`
+	Clock_out    *time.Time         `json:"clock_out"`
+	Breaks       []TimeBreak        `json:"breaks" validate:"dive"`
+	Business_day string             `json:"business_day"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Version      int64              `json:"version"`
+	Deleted_at   *time.Time         `json:"deleted_at"`
+	Deleted_by   *string            `json:"deleted_by"`
+}
+
+// A break taken during a time entry, the one without an end is going on
+type TimeBreak struct {
+	Started_at *time.Time `json:"started_at" validate:"required"`
+	Ended_at   *time.Time `json:"ended_at"`
+	Paid       bool       `json:"paid"`
+}
+
+// A shift of the schedule: who works, in which role, from when to when
+type ScheduledShift struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Shift_id     string             `json:"shift_id"`
+	User_id      *string            `json:"user_id" validate:"required"`
+	Role         *string            `json:"role" validate:"required,min=2,max=50"`
+	Starts_at    *time.Time         `json:"starts_at" validate:"required"`
+	Ends_at      *time.Time         `json:"ends_at" validate:"required"`
+	Notes        *string            `json:"notes" validate:"omitempty,max=500"`
+	Business_day string             `json:"business_day"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Version      int64              `json:"version"`
+	Deleted_at   *time.Time         `json:"deleted_at"`
+	Deleted_by   *string            `json:"deleted_by"`
+}
+
+// The shifts of a role in a week of the schedule
+type ScheduleRole struct {
+	Role   string           `json:"role"`
+	Hours  float64          `json:"hours"`
+	Shifts []ScheduledShift `json:"shifts"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How a tip pool is shared: by the weight of the role of each employee, by the hours they worked (times the weight
+// of their role, 1 without one) or by a fixed percentage of the pool for each role, split by hours within the role
+const (
+	TipPoolRoleWeight = "ROLE_WEIGHT"
+	TipPoolHours      = "HOURS"
+	TipPoolPercentage = "PERCENTAGE"
+)
+
+// Where the tips of a business day are at. A LOCKED distribution can't be calculated again nor adjusted.
+const (
+	TipDistributionDraft  = "DRAFT"
+	TipDistributionLocked = "LOCKED"
+)
+
+// A rule sharing the tips of a business day between the employees who worked it. Only the roles it lists take part,
+// unless it shares by hours without listing any.
+type TipPoolRule struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Rule_id    string             `json:"rule_id"`
+	Name       *string            `json:"name" validate:"required,min=2,max=100"`
+	Method     *string            `json:"method" validate:"required,eq=ROLE_WEIGHT|eq=HOURS|eq=PERCENTAGE"`
+	Shares     []TipPoolShare     `json:"shares" validate:"dive"`
+	Is_active  *bool              `json:"is_active"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+	Version    int64              `json:"version"`
+	Deleted_at *time.Time         `json:"deleted_at"`
+	Deleted_by *string            `json:"deleted_by"`
+}
+
+// What a role takes from a tip pool: its weight, or its percentage of the pool
+type TipPoolShare struct {
+	Role       *string  `json:"role" validate:"required,min=2,max=50"`
+	Weight     *float64 `json:"weight" validate:"omitempty,gt=0"`
+	Percentage *float64 `json:"percentage" validate:"omitempty,gt=0,lte=100"`
+}
+
+// The tips of a business day shared by a rule. The pool is the tips of the invoices paid that day; what no one
+// could take (a role of the rule nobody worked) is undistributed.
+type TipDistribution struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Distribution_id string             `json:"distribution_id"`
+	Business_day    string             `json:"business_day"`
+	Rule_id         string             `json:"rule_id"`
+	Method          string             `json:"method"`
+	Pool            float64            `json:"pool"`
+	Distributed     float64            `json:"distributed"`
+	Undistributed   float64            `json:"undistributed"`
+	Open_entries    int                `json:"open_entries"`
+	Shares          []TipShare         `json:"shares"`
+	Status          string             `json:"status"`
+	Calculated_at   time.Time          `json:"calculated_at"`
+	Locked_at       *time.Time         `json:"locked_at"`
+	Locked_by       *string            `json:"locked_by"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Version         int64              `json:"version"`
+	Deleted_at      *time.Time         `json:"deleted_at"`
+	Deleted_by      *string            `json:"deleted_by"`
+}
+
+// What an employee takes from the tips of a day in a role: what the rule gave them and what a manager changed
+type TipShare struct {
+	User_id    string  `json:"user_id"`
+	Name       string  `json:"name"`
+	Role       string  `json:"role"`
+	Hours      float64 `json:"hours"`
+	Calculated float64 `json:"calculated"`
+	Adjustment float64 `json:"adjustment"`
+	Amount     float64 `json:"amount"`
+	Note       *string `json:"note"`
+}
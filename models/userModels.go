@@ -6,6 +6,13 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// The roles a user can have, managers can do everything. Drivers are the ones delivery orders are assigned to.
+const (
+	RoleManager = "MANAGER"
+	RoleServer  = "SERVER"
+	RoleDriver  = "DRIVER"
+)
+
 // Structure to define the users
 type User struct {
 	ID            primitive.ObjectID `bson:"_id"`
@@ -15,9 +22,13 @@ type User struct {
 	Email         *string            `json:"email" validate:"email,required"`
 	Avatar        *string            `json:"avatar"`
 	Phone         *string            `json:"phone" validate:"required"`
+	Role          *string            `json:"role" validate:"omitempty,eq=MANAGER|eq=SERVER|eq=DRIVER"`
 	Token         *string            `json:"token"`
 	Refresh_Token *string            `json:"refresh_token"`
 	Created_at    time.Time          `json:"created_at"`
 	Updated_at    time.Time          `json:"updated_at"`
+	Version       int64              `json:"version"`
+	Deleted_at    *time.Time         `json:"deleted_at"`
+	Deleted_by    *string            `json:"deleted_by"`
 	User_id       string             `json:"user_id"`
 }
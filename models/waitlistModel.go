@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Where a party on the waitlist is at: WAITING, NOTIFIED once told its table is ready, then SEATED or LEFT
+const (
+	WaitlistWaiting  = "WAITING"
+	WaitlistNotified = "NOTIFIED"
+	WaitlistSeated   = "SEATED"
+	WaitlistLeft     = "LEFT"
+)
+
+// A party waiting for a table. The quoted time is what the host told the party, the estimate what the service
+// computed when it was added.
+type WaitlistEntry struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Entry_id           string             `json:"entry_id"`
+	Party_name         *string            `json:"party_name" validate:"required,min=2,max=100"`
+	Party_size         *int               `json:"party_size" validate:"required,min=1"`
+	Phone              *string            `json:"phone" validate:"omitempty,min=6,max=20"`
+	Notes              *string            `json:"notes" validate:"omitempty,max=500"`
+	Status             *string            `json:"status"`
+	Estimated_minutes  *int               `json:"estimated_minutes"`
+	Quoted_minutes     *int               `json:"quoted_minutes" validate:"omitempty,min=0"`
+	Quoted_at          *time.Time         `json:"quoted_at"`
+	Notified_at        *time.Time         `json:"notified_at"`
+	Notifications      int                `json:"notifications"`
+	Notification_error *string            `json:"notification_error"`
+	Table_id           *string            `json:"table_id"`
+	Seated_at          *time.Time         `json:"seated_at"`
+	Left_at            *time.Time         `json:"left_at"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Version            int64              `json:"version"`
+	Deleted_at         *time.Time         `json:"deleted_at"`
+	Deleted_by         *string            `json:"deleted_by"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The events published by the changes. The webhooks can be sent the ones in WebhookEvents,
+// the others are only followed inside the service. Nothing publishes reservation.created until reservations exist.
+const (
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventOrderTransferred   = "order.transferred"
+	EventOrderMerged        = "order.merged"
+	EventOrderSplit         = "order.split"
+	EventOrderServerChanged = "order.server_changed"
+	EventInvoiceCreated     = "invoice.created"
+	EventInvoicePaid        = "invoice.paid"
+	EventInvoiceRefunded    = "invoice.refunded"
+	EventReservationCreated = "reservation.created"
+	EventTableStatusChanged = "table.status_changed"
+)
+
+var WebhookEvents = []string{EventOrderCreated, EventOrderStatusChanged, EventInvoicePaid, EventReservationCreated, EventTableStatusChanged}
+
+// Where a webhook delivery is at
+const (
+	WebhookPending   = "PENDING"
+	WebhookDelivered = "DELIVERED"
+	WebhookFailed    = "FAILED"
+)
+
+// A system which wants to hear about some events. The secret signs what it is sent, it is only shown when
+// the subscription is created.
+type WebhookSubscription struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Subscription_id string             `json:"subscription_id"`
+	Url             *string            `json:"url" validate:"required,url,max=500"`
+	Events          []string           `json:"events" validate:"required,min=1,dive,oneof=order.created order.status_changed invoice.paid reservation.created table.status_changed"`
+	Description     *string            `json:"description" validate:"omitempty,max=200"`
+	Secret          string             `json:"secret,omitempty"`
+	Is_active       *bool              `json:"is_active"`
+	Created_by      string             `json:"created_by"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Version         int64              `json:"version"`
+	Deleted_at      *time.Time         `json:"deleted_at"`
+	Deleted_by      *string            `json:"deleted_by"`
+}
+
+// An event to send to a subscription, sent again with a growing delay until the subscription takes it
+// or the attempts run out
+type WebhookDelivery struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Delivery_id      string             `json:"delivery_id"`
+	Subscription_id  string             `json:"subscription_id"`
+	Event_id         string             `json:"event_id"`
+	Event            string             `json:"event"`
+	Payload          string             `json:"payload"`
+	Status           string             `json:"status"`
+	Attempts         int                `json:"attempts"`
+	Next_attempt_at  *time.Time         `json:"next_attempt_at"`
+	Last_status_code *int               `json:"last_status_code"`
+	Last_error       *string            `json:"last_error"`
+	Attempt_log      []WebhookAttempt   `json:"attempt_log"`
+	Redelivery_of    *string            `json:"redelivery_of"`
+	Created_at       time.Time          `json:"created_at"`
+	Delivered_at     *time.Time         `json:"delivered_at"`
+}
+
+// One try at sending a delivery
+type WebhookAttempt struct {
+	At          time.Time `json:"at"`
+	Status_code *int      `json:"status_code"`
+	Error       *string   `json:"error"`
+	Duration_ms int64     `json:"duration_ms"`
+}
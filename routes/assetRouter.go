@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The images are served without authentication, so they can be used directly in the apps
+func AssetRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/assets/:asset_id", controllers.GetAsset())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Only the managers can read the audit log
+func AuditRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/audit", middleware.RequireRole(models.RoleManager), controllers.GetAudit())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Closing the day (and reading the Z reports) is for the managers
+func BusinessDayRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/zreports", middleware.RequireRole(models.RoleManager), controllers.CloseBusinessDay())
+	incomingRoutes.GET("/zreports/:business_day", middleware.RequireRole(models.RoleManager), controllers.GetZReport())
+}
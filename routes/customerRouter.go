@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CustomerRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/customers", controllers.GetCustomers())
+	incomingRoutes.GET("/customers/search", controllers.SearchCustomers())
+	incomingRoutes.GET("/customers/:customer_id", controllers.GetCustomerbyID())
+	incomingRoutes.GET("/customers/:customer_id/profile", controllers.GetCustomerProfile())
+	incomingRoutes.GET("/customers/:customer_id/loyalty", controllers.GetLoyaltyAccount())
+	incomingRoutes.GET("/customers/:customer_id/loyalty/transactions", controllers.GetLoyaltyTransactions())
+	incomingRoutes.POST("/customers", controllers.CreateCustomer())
+	incomingRoutes.PATCH("/customers/:customer_id", controllers.UpdateCustomer())
+	incomingRoutes.DELETE("/customers/:customer_id", controllers.DeleteCustomer())
+	incomingRoutes.POST("/customers/:customer_id/restore", controllers.RestoreCustomer())
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The platforms have no token, their webhooks are signed instead
+func DeliveryWebhookRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/webhooks/delivery/:platform", controllers.ReceiveDeliveryOrder())
+}
+
+// Only the managers look after the orders received and map the items of the platforms onto the foods
+func DeliveryRoutes(incomingRoutes *gin.Engine) {
+	delivery := incomingRoutes.Group("/delivery", middleware.RequireRole(models.RoleManager))
+	delivery.GET("/orders", controllers.GetDeliveryOrders())
+	delivery.GET("/orders/:delivery_order_id", controllers.GetDeliveryOrder())
+	delivery.POST("/orders/:delivery_order_id/callbacks/retry", controllers.RetryDeliveryCallbacks())
+	delivery.GET("/mappings", controllers.GetDeliveryMappings())
+	delivery.POST("/mappings", controllers.CreateDeliveryMapping())
+	delivery.PATCH("/mappings/:mapping_id", controllers.UpdateDeliveryMapping())
+	delivery.DELETE("/mappings/:mapping_id", controllers.DeleteDeliveryMapping())
+	delivery.POST("/mappings/:mapping_id/restore", controllers.RestoreDeliveryMapping())
+}
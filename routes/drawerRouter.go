@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Opening and counting the drawers is for the managers
+func DrawerRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/drawers", controllers.GetDrawers())
+	incomingRoutes.GET("/drawers/:drawer_id", controllers.GetDrawerbyID())
+	incomingRoutes.POST("/drawers", middleware.RequireRole(models.RoleManager), controllers.OpenDrawer())
+	incomingRoutes.POST("/drawers/:drawer_id/drops", controllers.DropCash())
+	incomingRoutes.POST("/drawers/:drawer_id/payouts", controllers.PayoutCash())
+	incomingRoutes.POST("/drawers/:drawer_id/close", middleware.RequireRole(models.RoleManager), controllers.CloseDrawer())
+}
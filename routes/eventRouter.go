@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Only the managers look at how far the subscribers of the events got
+func EventRoutes(incomingRoutes *gin.Engine) {
+	events := incomingRoutes.Group("/events", middleware.RequireRole(models.RoleManager))
+	events.GET("/subscribers", controllers.GetEventSubscribers())
+}
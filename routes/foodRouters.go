@@ -8,7 +8,11 @@ import (
 
 func FoodRoutes(imcomingRoutes *gin.Engine) {
 	imcomingRoutes.GET("/food", controllers.GetFoods())
-	imcomingRoutes.GET("/food", controllers.GetFoodbyID())
+	imcomingRoutes.GET("/food/search", controllers.SearchFoods())
+	imcomingRoutes.GET("/food/:food_id", controllers.GetFoodbyID())
 	imcomingRoutes.POST("/food", controllers.CreateFood())
-	imcomingRoutes.PATCH("/food", controllers.UpdateFood())
+	imcomingRoutes.PATCH("/food/:food_id", controllers.UpdateFood())
+	imcomingRoutes.POST("/food/:food_id/image", controllers.UploadFoodImage())
+	imcomingRoutes.DELETE("/food/:food_id", controllers.DeleteFood())
+	imcomingRoutes.POST("/food/:food_id/restore", controllers.RestoreFood())
 }
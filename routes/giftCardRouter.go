@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gift cards are found by their code. Only the managers list them, issue them and reload them: a card is money.
+func GiftCardRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/giftcards", middleware.RequireRole(models.RoleManager), controllers.GetGiftCards())
+	incomingRoutes.GET("/giftcards/:code", controllers.GetGiftCard())
+	incomingRoutes.GET("/giftcards/:code/transactions", controllers.GetGiftCardTransactions())
+	incomingRoutes.POST("/giftcards", middleware.RequireRole(models.RoleManager), controllers.IssueGiftCard())
+	incomingRoutes.POST("/giftcards/:code/reload", middleware.RequireRole(models.RoleManager), controllers.ReloadGiftCard())
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,6 +11,10 @@ import (
 func InvoiceRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/invoices", controllers.GetInvoice())
 	incomingRoutes.GET("/invoices/:invoice_id", controllers.GetInvoicebyID())
-	incomingRoutes.POST("/invoices", controllers.CreateInvoice())
+	incomingRoutes.POST("/invoices", middleware.Idempotency(), controllers.CreateInvoice())
 	incomingRoutes.PATCH("/invoices/:invoice_id", controllers.UpdateInvoice())
+	incomingRoutes.DELETE("/invoices/:invoice_id", controllers.DeleteInvoice())
+	incomingRoutes.POST("/invoices/:invoice_id/restore", controllers.RestoreInvoice())
+	incomingRoutes.POST("/invoices/:invoice_id/discount/approve", middleware.RequireRole(models.RoleManager), controllers.ApproveInvoiceDiscount())
+	incomingRoutes.POST("/invoices/:invoice_id/discount/reject", middleware.RequireRole(models.RoleManager), controllers.RejectInvoiceDiscount())
 }
@@ -11,4 +11,6 @@ func MenuRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/menu/:menu_id", controllers.GetMenubyID())
 	incomingRoutes.POST("/menu", controllers.CreateMenu())
 	incomingRoutes.PATCH("/menu/:menu_id", controllers.UpdateMenu())
+	incomingRoutes.DELETE("/menu/:menu_id", controllers.DeleteMenu())
+	incomingRoutes.POST("/menu/:menu_id/restore", controllers.RestoreMenu())
 }
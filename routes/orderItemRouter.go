@@ -2,6 +2,7 @@ package routes
 
 import (
 	"restaurantms/controllers"
+	"restaurantms/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,6 +11,8 @@ func OrderItemsRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orderitems", controllers.GetOrderItems())
 	incomingRoutes.GET("/orderitems/:orderitems_id", controllers.GetOrderItemsbyID())
 	incomingRoutes.GET("orderitems-orders/:order_id", controllers.GetOrderItemsbyOrder())
-	incomingRoutes.POST("/orderitems", controllers.CreateOrderItems())
+	incomingRoutes.POST("/orderitems", middleware.RequireClockIn(), middleware.Idempotency(), controllers.CreateOrderItems())
 	incomingRoutes.PATCH("/orderitems/:orderitems_id", controllers.UpdateOrderItems())
+	incomingRoutes.DELETE("/orderitems/:orderitems_id", controllers.DeleteOrderItem())
+	incomingRoutes.POST("/orderitems/:orderitems_id/restore", controllers.RestoreOrderItem())
 }
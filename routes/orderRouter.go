@@ -2,6 +2,8 @@ package routes
 
 import (
 	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,6 +11,14 @@ import (
 func OrderRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/order", controllers.GetOrder())
 	incomingRoutes.GET("/order/:order_id", controllers.GetOrderbyID())
-	incomingRoutes.POST("/order", controllers.CreateOrder())
+	incomingRoutes.POST("/order", middleware.RequireClockIn(), middleware.Idempotency(), controllers.CreateOrder())
 	incomingRoutes.PATCH("/order/:order_id", controllers.UpdateOrder())
+	// the statuses are sent on to the delivery platforms, the drivers don't set them
+	incomingRoutes.POST("/order/:order_id/status", middleware.RequireRole(models.RoleManager, models.RoleServer), controllers.UpdateOrderStatus())
+	incomingRoutes.POST("/order/:order_id/transfer", controllers.TransferOrder())
+	// a split can make a new order, which only the users clocked in do
+	incomingRoutes.POST("/order/:order_id/split", middleware.RequireClockIn(), controllers.SplitOrder())
+	incomingRoutes.POST("/order/:order_id/server", controllers.TransferCheck())
+	incomingRoutes.DELETE("/order/:order_id", controllers.DeleteOrder())
+	incomingRoutes.POST("/order/:order_id/restore", controllers.RestoreOrder())
 }
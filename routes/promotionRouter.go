@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Everyone can read the promotions, only the managers set them up
+func PromotionRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/promotions", controllers.GetPromotions())
+	incomingRoutes.GET("/promotions/:promotion_id", controllers.GetPromotionbyID())
+	incomingRoutes.POST("/promotions", middleware.RequireRole(models.RoleManager), controllers.CreatePromotion())
+	incomingRoutes.PATCH("/promotions/:promotion_id", middleware.RequireRole(models.RoleManager), controllers.UpdatePromotion())
+	incomingRoutes.DELETE("/promotions/:promotion_id", middleware.RequireRole(models.RoleManager), controllers.DeletePromotion())
+	incomingRoutes.POST("/promotions/:promotion_id/restore", middleware.RequireRole(models.RoleManager), controllers.RestorePromotion())
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every report takes ?from=&to=&tz= and can be downloaded as CSV with ?format=csv
+func ReportRoutes(incomingRoutes *gin.Engine) {
+	reports := incomingRoutes.Group("/reports", middleware.RequireRole(models.RoleManager))
+	reports.GET("/sales-by-day", controllers.SalesByDay())
+	reports.GET("/sales-by-hour", controllers.SalesByHour())
+	reports.GET("/sales-by-food", controllers.SalesByFood())
+	reports.GET("/sales-by-category", controllers.SalesByCategory())
+	reports.GET("/average-check", controllers.AverageCheck())
+	reports.GET("/covers", controllers.Covers())
+	reports.GET("/payment-methods", controllers.PaymentMethods())
+	reports.GET("/servers", controllers.SalesByServer())
+	reports.GET("/labor", controllers.Labor())
+	reports.GET("/tips", controllers.Tips())
+	reports.GET("/voids-refunds", controllers.VoidsRefunds())
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Everyone sees who serves which section, only the managers give the sections out and move checks between servers
+func SectionRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/sections", controllers.GetSections())
+
+	assignments := incomingRoutes.Group("/sections/assignments", middleware.RequireRole(models.RoleManager))
+	assignments.GET("", controllers.GetSectionAssignments())
+	assignments.GET("/:assignment_id", controllers.GetSectionAssignment())
+	assignments.POST("", controllers.CreateSectionAssignment())
+	assignments.PATCH("/:assignment_id", controllers.UpdateSectionAssignment())
+	assignments.DELETE("/:assignment_id", controllers.DeleteSectionAssignment())
+	assignments.POST("/:assignment_id/restore", controllers.RestoreSectionAssignment())
+
+	servers := incomingRoutes.Group("/servers", middleware.RequireRole(models.RoleManager))
+	servers.POST("/:server_id/checks/transfer", controllers.TransferServerChecks())
+}
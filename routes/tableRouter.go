@@ -11,4 +11,10 @@ func TableRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/table/:table_id", controllers.GetTablebyID())
 	incomingRoutes.POST("/table", controllers.CreateTable())
 	incomingRoutes.PATCH("/table/:table_id", controllers.UpdateTable())
+	incomingRoutes.POST("/table/:table_id/status", controllers.UpdateTableStatus())
+	incomingRoutes.POST("/table/:table_id/merge", controllers.MergeTables())
+	incomingRoutes.DELETE("/table/:table_id", controllers.DeleteTable())
+	incomingRoutes.POST("/table/:table_id/restore", controllers.RestoreTable())
+	incomingRoutes.GET("/floor", controllers.GetFloor())
+	incomingRoutes.GET("/floor/stream", controllers.StreamFloor())
 }
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Everyone clocks themselves in and out and reads the schedule, only the managers correct the time entries,
+// build the schedule and export the payroll
+func TimeClockRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/timeclock", controllers.GetTimeClock())
+	incomingRoutes.POST("/timeclock/clock-in", controllers.ClockIn())
+	incomingRoutes.POST("/timeclock/clock-out", controllers.ClockOut())
+	incomingRoutes.POST("/timeclock/breaks/start", controllers.StartBreak())
+	incomingRoutes.POST("/timeclock/breaks/end", controllers.EndBreak())
+	incomingRoutes.GET("/schedules/week", controllers.GetScheduleWeek())
+
+	entries := incomingRoutes.Group("/timeclock/entries", middleware.RequireRole(models.RoleManager))
+	entries.GET("", controllers.GetTimeEntries())
+	entries.GET("/:entry_id", controllers.GetTimeEntry())
+	entries.PATCH("/:entry_id", controllers.UpdateTimeEntry())
+	entries.DELETE("/:entry_id", controllers.DeleteTimeEntry())
+	entries.POST("/:entry_id/restore", controllers.RestoreTimeEntry())
+
+	shifts := incomingRoutes.Group("/schedules", middleware.RequireRole(models.RoleManager))
+	shifts.GET("/shifts", controllers.GetScheduledShifts())
+	shifts.GET("/shifts/:shift_id", controllers.GetScheduledShift())
+	shifts.POST("/shifts", controllers.CreateScheduledShift())
+	shifts.PATCH("/shifts/:shift_id", controllers.UpdateScheduledShift())
+	shifts.DELETE("/shifts/:shift_id", controllers.DeleteScheduledShift())
+	shifts.POST("/shifts/:shift_id/restore", controllers.RestoreScheduledShift())
+	shifts.POST("/copy", controllers.CopyScheduleWeek())
+
+	incomingRoutes.GET("/payroll", middleware.RequireRole(models.RoleManager), controllers.GetPayroll())
+}
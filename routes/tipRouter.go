@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Only the managers set the tip pools up, share the tips of a day, adjust and lock them.
+// What each employee took is the /reports/tips report.
+func TipRoutes(incomingRoutes *gin.Engine) {
+	tips := incomingRoutes.Group("/tips", middleware.RequireRole(models.RoleManager))
+	tips.GET("/rules", controllers.GetTipPoolRules())
+	tips.GET("/rules/:rule_id", controllers.GetTipPoolRule())
+	tips.POST("/rules", controllers.CreateTipPoolRule())
+	tips.PATCH("/rules/:rule_id", controllers.UpdateTipPoolRule())
+	tips.DELETE("/rules/:rule_id", controllers.DeleteTipPoolRule())
+	tips.POST("/rules/:rule_id/restore", controllers.RestoreTipPoolRule())
+	tips.GET("/distributions", controllers.GetTipDistributions())
+	tips.GET("/distributions/:business_day", controllers.GetTipDistribution())
+	tips.POST("/distributions", controllers.CalculateTipDistribution())
+	tips.PATCH("/distributions/:business_day/shares", controllers.AdjustTipShare())
+	tips.POST("/distributions/:business_day/lock", controllers.LockTipDistribution())
+}
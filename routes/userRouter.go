@@ -2,6 +2,8 @@ package routes
 
 import (
 	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,4 +13,7 @@ func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/user/:user_id", controllers.GetUserbyID())
 	incomingRoutes.POST("/user/signup", controllers.Signup())
 	incomingRoutes.POST("/user/login", controllers.Login())
+	incomingRoutes.PATCH("/user/:user_id/role", middleware.Authentication(), middleware.RequireRole(models.RoleManager), controllers.UpdateUserRole())
+	incomingRoutes.DELETE("/user/:user_id", middleware.Authentication(), middleware.RequireRole(models.RoleManager), controllers.DeleteUser())
+	incomingRoutes.POST("/user/:user_id/restore", middleware.Authentication(), middleware.RequireRole(models.RoleManager), controllers.RestoreUser())
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func WaitlistRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/waitlist", controllers.GetWaitlist())
+	incomingRoutes.GET("/waitlist/board", controllers.GetWaitlistBoard())
+	incomingRoutes.GET("/waitlist/estimate", controllers.EstimateWait())
+	incomingRoutes.GET("/waitlist/:entry_id", controllers.GetWaitlistEntry())
+	incomingRoutes.POST("/waitlist", controllers.CreateWaitlistEntry())
+	incomingRoutes.PATCH("/waitlist/:entry_id", controllers.UpdateWaitlistEntry())
+	incomingRoutes.POST("/waitlist/:entry_id/notify", controllers.NotifyWaitlistEntry())
+	incomingRoutes.POST("/waitlist/:entry_id/seat", controllers.SeatWaitlistEntry())
+	incomingRoutes.POST("/waitlist/:entry_id/leave", controllers.LeaveWaitlist())
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"restaurantms/controllers"
+	"restaurantms/middleware"
+	"restaurantms/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Only the managers subscribe the other systems to the events and look at what was sent
+func WebhookRoutes(incomingRoutes *gin.Engine) {
+	webhooks := incomingRoutes.Group("/webhooks", middleware.RequireRole(models.RoleManager))
+	webhooks.GET("/subscriptions", controllers.GetWebhookSubscriptions())
+	webhooks.GET("/subscriptions/:subscription_id", controllers.GetWebhookSubscription())
+	webhooks.POST("/subscriptions", controllers.CreateWebhookSubscription())
+	webhooks.PATCH("/subscriptions/:subscription_id", controllers.UpdateWebhookSubscription())
+	webhooks.DELETE("/subscriptions/:subscription_id", controllers.DeleteWebhookSubscription())
+	webhooks.POST("/subscriptions/:subscription_id/restore", controllers.RestoreWebhookSubscription())
+	webhooks.GET("/deliveries", controllers.GetWebhookDeliveries())
+	webhooks.GET("/deliveries/:delivery_id", controllers.GetWebhookDelivery())
+	webhooks.POST("/deliveries/:delivery_id/redeliver", controllers.RedeliverWebhook())
+}
@@ -0,0 +1,33 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Sender sends a text message to a phone number
+type Sender interface {
+	Send(ctx context.Context, to string, message string) error
+}
+
+// LogSender only writes the messages to the log, to run the service without an SMS provider
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, to string, message string) error {
+	log.Printf("SMS to %s: %s", to, message)
+	return nil
+}
+
+// OpenSender sets up the sender named in SMS_SENDER, the log-only one by default.
+// A provider is plugged in by implementing Sender and adding its name here.
+func OpenSender() (Sender, error) {
+	switch strings.ToLower(os.Getenv("SMS_SENDER")) {
+	case "", "log":
+		return LogSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_SENDER %q, use log", os.Getenv("SMS_SENDER"))
+	}
+}
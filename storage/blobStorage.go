@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Returned by Get, when there is nothing stored under the key
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore is the place where the uploaded files (like food images) are kept.
+// The records in mongo only keep the key, so the actual bytes can live anywhere.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// This function picks the blob store based on the BLOB_STORAGE environment variable
+// "local" keeps the files in UPLOAD_DIR (default: uploads), "gridfs" keeps them inside mongo
+func NewBlobStore(db *mongo.Database) (BlobStore, error) {
+	switch os.Getenv("BLOB_STORAGE") {
+	case "gridfs":
+		return NewGridFSStore(db, "images")
+	case "", "local":
+		dir := os.Getenv("UPLOAD_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocalStore(dir)
+	default:
+		return nil, errors.New("unknown BLOB_STORAGE, use local or gridfs")
+	}
+}
@@ -0,0 +1,57 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// Returned when a webhook would be sent inside our own network: to a loopback, link-local or private address
+var ErrForbiddenDestination = errors.New("the webhooks can't be sent to a loopback, link-local or private address")
+
+// Tells if an address is one of ours rather than one of the subscriber's
+func forbiddenIP(ip net.IP) bool {
+	return ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// This function checks a subscription URL: http or https, with a host which only resolves to public addresses.
+// The sender checks the address again when it connects, a host can resolve somewhere else later.
+func CheckDestination(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("the url must be an http or https URL")
+	}
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if forbiddenIP(ip) {
+			return ErrForbiddenDestination
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("the host %s can't be resolved", host)
+	}
+	for _, addr := range addrs {
+		if forbiddenIP(addr.IP) {
+			return ErrForbiddenDestination
+		}
+	}
+	return nil
+}
+
+// Refuses the connections to our own network, whatever the host of the URL resolved to
+func refuseForbidden(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if forbiddenIP(net.ParseIP(host)) {
+		return ErrForbiddenDestination
+	}
+	return nil
+}
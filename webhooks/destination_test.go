@@ -0,0 +1,54 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCheckDestinationRefusesOurNetwork(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://127.0.0.1/hook",
+		"http://[::1]:8080/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://10.0.0.5/hook",
+		"https://172.16.3.4/hook",
+		"https://192.168.1.10/hook",
+		"http://[fd00::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if err := CheckDestination(context.Background(), rawURL); !errors.Is(err, ErrForbiddenDestination) {
+			t.Errorf("%s was taken: %v", rawURL, err)
+		}
+	}
+}
+
+func TestCheckDestinationTakesPublicAddresses(t *testing.T) {
+	for _, rawURL := range []string{"https://93.184.216.34/hook", "http://[2606:2800:220:1::]/hook"} {
+		if err := CheckDestination(context.Background(), rawURL); err != nil {
+			t.Errorf("%s was refused: %v", rawURL, err)
+		}
+	}
+}
+
+func TestCheckDestinationNeedsHTTP(t *testing.T) {
+	for _, rawURL := range []string{"ftp://93.184.216.34/hook", "93.184.216.34", "https:///hook"} {
+		if err := CheckDestination(context.Background(), rawURL); err == nil {
+			t.Errorf("%s was taken", rawURL)
+		}
+	}
+}
+
+func TestSenderRefusesToConnectToOurNetwork(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	_, err = NewSender(0).Send(context.Background(), "http://"+listener.Addr().String(), "secret", "event-1", "order.created", []byte("{}"))
+	if !errors.Is(err, ErrForbiddenDestination) {
+		t.Fatalf("the sender connected: %v", err)
+	}
+}
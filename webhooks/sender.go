@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The headers sent with every webhook
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-Id"
+)
+
+// This function signs a payload sent at a given unix time: "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<payload>".
+// The receivers compute it again with their secret to check the webhook came from us.
+func Sign(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sender posts the events to the subscribers. It never connects to our own network, see CheckDestination.
+type Sender struct {
+	client *http.Client
+}
+
+func NewSender(timeout time.Duration) *Sender {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: refuseForbidden}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second}
+	return &Sender{client: &http.Client{Timeout: timeout, Transport: transport}}
+}
+
+// This function posts a payload to a subscriber, signed with its secret. The id is the one of the event, the same
+// for every try, so the subscriber can tell it already got it. It gives the status code answered
+// (0 when there was no answer), anything but a 2xx is an error.
+func (s *Sender) Send(ctx context.Context, url string, secret string, eventID string, event string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, payload))
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(IDHeader, eventID)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("the subscriber answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}